@@ -0,0 +1,58 @@
+package authorizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const actKey = "act"
+
+var ErrActorNotAllowed = errors.New("actor not allowed")
+
+// WithAllowedActors rejects tokens whose act claim names a current actor,
+// the outermost act.sub, other than one of subs. Tokens without an act claim
+// are unaffected.
+func WithAllowedActors(subs ...string) handlerOpt {
+	return func(h *handler) {
+		h.AllowedActors = append(h.AllowedActors, subs...)
+	}
+}
+
+type actorContextKey struct{}
+
+// ActorFromContext returns the chain of actors named by the token's RFC 8693
+// act claim, current actor first, or nil when the token carries none. The
+// subject the request acts for remains the sub claim.
+func ActorFromContext(ctx context.Context) []string {
+	actors, _ := ctx.Value(actorContextKey{}).([]string)
+	return actors
+}
+
+func actorChain(claims map[string]interface{}) []string {
+
+	var actors []string
+
+	for act, ok := claims[actKey].(map[string]interface{}); ok; act, ok = act[actKey].(map[string]interface{}) {
+		sub, _ := act[subKey].(string)
+		actors = append(actors, sub)
+	}
+
+	return actors
+}
+
+func (h *handler) checkActor(r *http.Request) error {
+
+	actors := ActorFromContext(r.Context())
+	if len(h.AllowedActors) == 0 || len(actors) == 0 {
+		return nil
+	}
+
+	if !slices.Contains(h.AllowedActors, actors[0]) {
+		return fmt.Errorf("%w: %q", ErrActorNotAllowed, actors[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Impersonation", func() {
+
+	var (
+		claims map[string]interface{}
+
+		rec       *httptest.ResponseRecorder
+		forwarded *http.Request
+		events    []authorizer.AuditEvent
+	)
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+		forwarded = nil
+		events = nil
+	})
+
+	JustBeforeEach(func() {
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithAllowedActors("support-alice"),
+			authorizer.WithAuthorizedSubjects("end-user"),
+			authorizer.IncludeClaimInContext("sub"),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				events = append(events, event)
+			}),
+		)
+
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+	})
+
+	Context("when the token has no act claim", func() {
+		BeforeEach(func() {
+			claims = map[string]interface{}{"sub": "end-user"}
+		})
+
+		It("forwards the request without an actor", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(authorizer.ActorFromContext(forwarded.Context())).To(BeNil())
+			Expect(events[0].Actors).To(BeNil())
+		})
+	})
+
+	Context("when an allowed actor impersonates the subject", func() {
+		BeforeEach(func() {
+			claims = map[string]interface{}{
+				"sub": "end-user",
+				"act": map[string]interface{}{
+					"sub": "support-alice",
+					"act": map[string]interface{}{"sub": "support-tool"},
+				},
+			}
+		})
+
+		It("keeps the subject and exposes the actor chain", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(forwarded.Context().Value(authorizer.ContextKey("sub"))).To(Equal("end-user"))
+			Expect(authorizer.ActorFromContext(forwarded.Context())).To(Equal([]string{"support-alice", "support-tool"}))
+		})
+
+		It("audits the actors", func() {
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Allowed).To(BeTrue())
+			Expect(events[0].Actors).To(Equal([]string{"support-alice", "support-tool"}))
+		})
+	})
+
+	Context("when an unlisted actor impersonates the subject", func() {
+		BeforeEach(func() {
+			claims = map[string]interface{}{
+				"sub": "end-user",
+				"act": map[string]interface{}{"sub": "support-mallory"},
+			}
+		})
+
+		It("rejects the request", func() {
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(forwarded).To(BeNil())
+			Expect(events[0].Err).To(MatchError(authorizer.ErrActorNotAllowed))
+			Expect(events[0].Actors).To(Equal([]string{"support-mallory"}))
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithSignedApiKeys", func() {
+
+	var (
+		secret = []byte("some-secret")
+
+		req *http.Request
+		rec *httptest.ResponseRecorder
+
+		handler   http.Handler
+		forwarded *http.Request
+	)
+
+	mint := func(secret []byte, payload authorizer.ApiKeyPayload) string {
+		key, err := authorizer.MintApiKey(secret, payload)
+		Expect(err).NotTo(HaveOccurred())
+		return key
+	}
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+		rec = httptest.NewRecorder()
+		forwarded = nil
+
+		handler = authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithApiKeys("static-key"),
+			authorizer.WithSignedApiKeys(secret),
+			authorizer.WithClaimExpression(`claims.tenant == "acme"`),
+			authorizer.IncludeClaimInContextAs("tenant", "tenant"),
+		)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(rec, req)
+	})
+
+	Context("when the key is valid", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", mint(secret, authorizer.ApiKeyPayload{
+				Label:  "ci",
+				Tenant: "acme",
+				Scopes: []string{"read"},
+				Expiry: time.Now().Add(time.Hour),
+			}))
+		})
+
+		It("authorizes the request with the key's claims", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(forwarded.Context().Value(authorizer.ContextKey("tenant"))).To(Equal("acme"))
+			Expect(authorizer.ApiKeyLabelFromContext(forwarded.Context())).To(Equal("ci"))
+			Expect(authorizer.MechanismFromContext(forwarded.Context())).To(Equal(authorizer.MechanismApiKey))
+			Expect(authorizer.ClaimsFromContext(forwarded.Context())).To(HaveKeyWithValue("scopes", []interface{}{"read"}))
+		})
+	})
+
+	Context("when the key's claims do not satisfy the rules", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", mint(secret, authorizer.ApiKeyPayload{Tenant: "other"}))
+		})
+
+		It("rejects the request", func() {
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when the key has been tampered with", func() {
+		BeforeEach(func() {
+			valid := mint(secret, authorizer.ApiKeyPayload{Tenant: "other"})
+			forged := mint(secret, authorizer.ApiKeyPayload{Tenant: "acme"})
+
+			i, j := strings.LastIndex(valid, "_"), strings.LastIndex(forged, "_")
+			req.Header.Set("X-Api-Key", forged[:j]+valid[i:])
+		})
+
+		It("rejects it like an unknown key", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when the key is signed with another secret", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", mint([]byte("other-secret"), authorizer.ApiKeyPayload{Tenant: "acme"}))
+		})
+
+		It("rejects it like an unknown key", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when the key has expired", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", mint(secret, authorizer.ApiKeyPayload{
+				Tenant: "acme",
+				Expiry: time.Now().Add(-time.Minute),
+			}))
+		})
+
+		It("rejects it like an unknown key", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when the key is malformed", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "ak_not-a-key")
+		})
+
+		It("rejects it like an unknown key", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when a static key is used", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "static-key")
+		})
+
+		It("still checks it", func() {
+			Expect(rec.Code).NotTo(Equal(http.StatusUnauthorized))
+		})
+	})
+})
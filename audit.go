@@ -0,0 +1,399 @@
+package authorizer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MechanismNone           = "none"
+	MechanismApiKey         = "api_key"
+	MechanismBasicAuth      = "basic_auth"
+	MechanismStaticToken    = "static_token"
+	MechanismBearerToken    = "bearer_token"
+	MechanismUpstreamClaims = "upstream_claims"
+	MechanismAnonymous      = "anonymous"
+)
+
+// AuditEvent describes one authorization decision. Path is the route pattern
+// or normalized path, see WithPathNormalizer, and RawPath the path as
+// requested. Err is redacted with RedactSecrets but still matches the
+// underlying error with errors.Is, and Class is its RejectionClass.
+// MappingErr reports claims that were left out of the context of an allowed
+// request because they could not be converted.
+type AuditEvent struct {
+	Time        time.Time
+	Method      string
+	Path        string
+	RawPath     string
+	RemoteAddr  string
+	ClientIP    netip.Addr
+	Allowed     bool
+	Status      int
+	Mechanism   string
+	MatchedRule string
+	Actors      []string
+	Err         error
+	Class       RejectionClass
+	MappingErr  error
+}
+
+func WithAuditHook(hook func(AuditEvent)) handlerOpt {
+	return func(h *handler) {
+		h.AuditHooks = append(h.AuditHooks, hook)
+	}
+}
+
+// WithPathNormalizer reports paths in audit events as normalize returns
+// them, such as /users/:id for /users/12345, to keep their cardinality low.
+// Handlers registered with a Mux report the pattern they were registered
+// under instead.
+func WithPathNormalizer(normalize func(string) string) handlerOpt {
+	return func(h *handler) {
+		h.PathNormalizer = normalize
+	}
+}
+
+func withRoutePattern(pattern string) handlerOpt {
+	return func(h *handler) {
+		// Patterns may start with a method and a host.
+		if i := strings.Index(pattern, " "); i >= 0 {
+			pattern = strings.TrimLeft(pattern[i:], " ")
+		}
+		if i := strings.Index(pattern, "/"); i >= 0 {
+			pattern = pattern[i:]
+		}
+		h.RoutePattern = pattern
+	}
+}
+
+func (h *handler) auditPath(path string) string {
+	switch {
+	case h.RoutePattern != "":
+		return h.RoutePattern
+	case h.PathNormalizer != nil:
+		return h.PathNormalizer(path)
+	}
+	return path
+}
+
+type mechanismContextKey struct{}
+
+type matchedRuleContextKey struct{}
+
+type apiKeyLabelContextKey struct{}
+
+// MechanismFromContext reports how the request was authorized.
+func MechanismFromContext(ctx context.Context) string {
+	mechanism, _ := ctx.Value(mechanismContextKey{}).(string)
+	return mechanism
+}
+
+// Authenticated reports whether the request presented credentials that were
+// verified, as opposed to passing anonymously or with no authorization
+// configured.
+func Authenticated(ctx context.Context) bool {
+	switch MechanismFromContext(ctx) {
+	case "", MechanismNone, MechanismAnonymous:
+		return false
+	default:
+		return true
+	}
+}
+
+// MatchedRuleFromContext reports the name of the authorized claim rule that
+// admitted the request, if any.
+func MatchedRuleFromContext(ctx context.Context) string {
+	rule, _ := ctx.Value(matchedRuleContextKey{}).(string)
+	return rule
+}
+
+// ApiKeyLabelFromContext reports the label of the API key the request
+// presented, if it was added with WithLabeledApiKey.
+func ApiKeyLabelFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(apiKeyLabelContextKey{}).(string)
+	return label
+}
+
+func (h *handler) allow(w http.ResponseWriter, r *http.Request, mechanism, rule string) {
+
+	ctx := withValue(r.Context(), mechanismContextKey{}, mechanism)
+	if rule != "" {
+		ctx = withValue(ctx, matchedRuleContextKey{}, rule)
+	}
+	*r = *r.WithContext(ctx)
+
+	if err := h.veto(r); err != nil {
+		h.reject(w, r, &rejection{ErrRequestVetoed, err})
+		return
+	}
+
+	h.audit(r, AuditEvent{Allowed: true, Mechanism: mechanism, MatchedRule: rule})
+
+	if h.SchemaObserver != nil {
+		h.SchemaObserver.sample(ClaimsFromContext(r.Context()))
+	}
+
+	if h.StripCredentials {
+		h.stripCredentials(r)
+	}
+
+	h.forwardClaims(r)
+	h.decideAllow(r, mechanism)
+
+	h.Handler.ServeHTTP(w, r)
+}
+
+// rejection explains why a request was turned away. Reason is one of the
+// exported rejection sentinels and decides the response status; Cause, when
+// set, is the underlying failure and is logged.
+type rejection struct {
+	Reason error
+	Cause  error
+}
+
+func (e *rejection) Error() string {
+	if e.Cause == nil {
+		return e.Reason.Error()
+	}
+	return e.Reason.Error() + ": " + e.Cause.Error()
+}
+
+func (e *rejection) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Reason}
+	}
+	return []error{e.Reason, e.Cause}
+}
+
+type mappingErrorContextKey struct{}
+
+// contextRejection rejects a request whose claims could not be put in the
+// context.
+func contextRejection(err error) *rejection {
+	if errors.Is(err, ErrClaimMappingFailed) {
+		return &rejection{ErrClaimMappingFailed, err}
+	}
+	if errors.Is(err, ErrMissingMappedClaim) {
+		return &rejection{ErrMissingMappedClaim, err}
+	}
+	return &rejection{ErrCredentialsRejected, err}
+}
+
+func authorizerRejection(err error) *rejection {
+	if missingCredentials(err) {
+		return &rejection{ErrNoCredentials, err}
+	}
+	return &rejection{ErrCredentialsRejected, err}
+}
+
+func missingCredentials(err error) bool {
+	return errors.Is(err, ErrMissingAuthorizationHeader) || errors.Is(err, ErrMissingSessionCookie)
+}
+
+// Status is 401 when the caller has not proven who they are and 403 when
+// they have but are not allowed in. Step-up challenges stay 401 so that the
+// client authenticates again.
+func (e *rejection) Status() int {
+	switch {
+	case e.Reason == ErrInvalidConfiguration, e.Reason == ErrClaimMappingFailed:
+		return http.StatusInternalServerError
+	case e.Reason == ErrApiKeyOutOfScope, e.Reason == ErrRequestVetoed:
+		return http.StatusForbidden
+	case e.Reason == ErrClaimsNotAuthorized && !errors.Is(e.Cause, ErrInsufficientAuthentication):
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// WithRejectionStatus answers rejections for reason, one of the rejection
+// sentinels such as ErrClaimsNotAuthorized, with status instead of the
+// default. Answering 404 rather than 403, for example, hides resources from
+// callers who may not use them.
+func WithRejectionStatus(reason error, status int) handlerOpt {
+	return func(h *handler) {
+		if h.RejectionStatus == nil {
+			h.RejectionStatus = map[error]int{}
+		}
+		h.RejectionStatus[reason] = status
+	}
+}
+
+func (h *handler) status(err *rejection) int {
+	if status, ok := h.RejectionStatus[err.Reason]; ok {
+		return status
+	}
+	return err.Status()
+}
+
+// reject is the single exit for every rejected request: it writes the
+// response, logs the reason and reports the decision to audit hooks.
+func (h *handler) reject(w http.ResponseWriter, r *http.Request, err *rejection) {
+
+	status := h.status(err)
+	redirect := status == http.StatusUnauthorized && h.LoginRedirect != nil && isBrowserNavigation(r)
+
+	if status == http.StatusUnauthorized && !redirect {
+		h.setChallenges(w, err)
+	}
+
+	switch {
+	case redirect:
+		status = http.StatusFound
+		http.Redirect(w, r, h.loginURL(r), status)
+	case h.UnauthorizedResponder != nil:
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.UnauthorizedResponder(recorder, r, err)
+		status = recorder.status
+	default:
+		h.writeErrorResponse(w, r, err, status)
+	}
+
+	cause := err.Cause
+	if cause == nil {
+		cause = err.Reason
+	}
+	h.logError(r, cause, h.rejectionFields(r, status, err)...)
+
+	h.audit(r, AuditEvent{Status: status, Err: err, Class: ClassifyError(err)})
+
+	if len(h.DecisionHooks) > 0 {
+		h.decideDeny(r, &redactedError{h.redact(err.Error()), err})
+	}
+}
+
+// rejectionFields identifies what was rejected without logging secrets: the
+// subject of the claims, the basic auth user and the first characters of
+// api keys long enough to spare them.
+func (h *handler) rejectionFields(r *http.Request, status int, err *rejection) []interface{} {
+
+	fields := []interface{}{Field{"reason", err.Reason.Error()}, Field{"class", ClassifyError(err)}, Field{"status", status}}
+
+	if sub, ok := claimValue(r, subKey).(string); ok && sub != "" {
+		fields = append(fields, Field{"sub", sub})
+	}
+
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		fields = append(fields, Field{"user", user})
+	}
+
+	if key := r.Header.Get("X-Api-Key"); len(key) >= 16 {
+		fields = append(fields, Field{"api_key_prefix", key[:4]})
+	}
+
+	return fields
+}
+
+func (h *handler) audit(r *http.Request, event AuditEvent) {
+
+	if len(h.AuditHooks) == 0 {
+		return
+	}
+
+	event.Time = time.Now()
+	event.Method = r.Method
+	event.Path = h.auditPath(r.URL.Path)
+	event.RawPath = r.URL.Path
+	event.RemoteAddr = r.RemoteAddr
+	event.ClientIP = ClientIP(r, h.TrustedProxies)
+	event.Actors = ActorFromContext(r.Context())
+	event.MappingErr, _ = r.Context().Value(mappingErrorContextKey{}).(error)
+
+	if event.Err != nil {
+		event.Err = &redactedError{h.redact(event.Err.Error()), event.Err}
+	}
+
+	if h.AsyncAudit != nil {
+		h.AsyncAudit.send(event)
+		return
+	}
+
+	h.deliverAudit(event)
+}
+
+func (h *handler) deliverAudit(event AuditEvent) {
+	for _, hook := range h.AuditHooks {
+		hook(event)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.written {
+		w.status = status
+		w.written = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+// WithAsyncAudit delivers audit events to the audit hooks from a background
+// worker, so that a slow hook does not delay requests. Up to buffer events
+// wait for the worker; further events are dropped and onDrop, if set, is
+// called with the number dropped so far. Close on the handler delivers the
+// events still waiting.
+func WithAsyncAudit(buffer int, onDrop func(int)) handlerOpt {
+	return func(h *handler) {
+		h.AsyncAudit = &asyncAudit{
+			Buffer: buffer,
+			OnDrop: onDrop,
+		}
+	}
+}
+
+type asyncAudit struct {
+	Buffer int
+	OnDrop func(int)
+
+	events  chan AuditEvent
+	dropped atomic.Int64
+}
+
+func (a *asyncAudit) start(workers *workers, deliver func(AuditEvent)) {
+
+	a.events = make(chan AuditEvent, a.Buffer)
+
+	workers.Go(func(done <-chan struct{}) {
+		for {
+			select {
+			case event := <-a.events:
+				deliver(event)
+			case <-done:
+				for {
+					select {
+					case event := <-a.events:
+						deliver(event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+func (a *asyncAudit) send(event AuditEvent) {
+	select {
+	case a.events <- event:
+	default:
+		dropped := a.dropped.Add(1)
+		if a.OnDrop != nil {
+			a.OnDrop(int(dropped))
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reverted/authorizer"
+	"github.com/reverted/authorizer/mocks"
+)
+
+var _ = Describe("Audit", func() {
+
+	var (
+		req *http.Request
+		rec *httptest.ResponseRecorder
+
+		mockCtrl       *gomock.Controller
+		mockAuthorizer *mocks.MockAuthorizer
+		mockHandler    *mocks.MockHandler
+
+		events  []authorizer.AuditEvent
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		var err error
+		req, err = http.NewRequest("GET", "http://localhost/some/path", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		rec = httptest.NewRecorder()
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockAuthorizer = mocks.NewMockAuthorizer(mockCtrl)
+		mockHandler = mocks.NewMockHandler(mockCtrl)
+
+		events = nil
+	})
+
+	hook := authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+		events = append(events, event)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(rec, req)
+	})
+
+	Context("when several claim rules match", func() {
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(
+				newLogger(),
+				mockHandler,
+				authorizer.WithAuthorizer(mockAuthorizer),
+				authorizer.WithNamedAuthorizedClaim("admins", "role", "admin"),
+				authorizer.WithNamedAuthorizedClaim("acme", "org", "acme"),
+				hook,
+			)
+
+			mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"role": "admin",
+				"org":  "acme",
+			}))
+			mockHandler.EXPECT().ServeHTTP(rec, req)
+		})
+
+		It("reports the first rule registered", func() {
+			Expect(authorizer.MatchedRuleFromContext(req.Context())).To(Equal("admins"))
+			Expect(authorizer.MechanismFromContext(req.Context())).To(Equal(authorizer.MechanismBearerToken))
+
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Allowed).To(BeTrue())
+			Expect(events[0].MatchedRule).To(Equal("admins"))
+			Expect(events[0].Mechanism).To(Equal(authorizer.MechanismBearerToken))
+			Expect(events[0].Path).To(Equal("/some/path"))
+		})
+	})
+
+	Context("when the matching rule is unnamed", func() {
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(
+				newLogger(),
+				mockHandler,
+				authorizer.WithAuthorizer(mockAuthorizer),
+				authorizer.WithNamedAuthorizedClaim("admins", "role", "admin"),
+				authorizer.WithAuthorizedClaim("org", "acme"),
+				hook,
+			)
+
+			mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"org": "acme",
+			}))
+			mockHandler.EXPECT().ServeHTTP(rec, req)
+		})
+
+		It("reports the rule by key and value", func() {
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].MatchedRule).To(Equal("org=acme"))
+		})
+	})
+
+	Context("when basic auth credentials match", func() {
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(
+				newLogger(),
+				mockHandler,
+				authorizer.WithBasicAuthCredential("user", "pass"),
+				hook,
+			)
+
+			req.SetBasicAuth("user", "pass")
+			mockHandler.EXPECT().ServeHTTP(rec, req)
+		})
+
+		It("reports the mechanism", func() {
+			Expect(authorizer.MechanismFromContext(req.Context())).To(Equal(authorizer.MechanismBasicAuth))
+			Expect(events[0].Mechanism).To(Equal(authorizer.MechanismBasicAuth))
+			Expect(events[0].MatchedRule).To(BeEmpty())
+		})
+	})
+
+	Context("when the request is rejected", func() {
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(
+				newLogger(),
+				mockHandler,
+				authorizer.WithAuthorizer(mockAuthorizer),
+				authorizer.WithNamedAuthorizedClaim("admins", "role", "admin"),
+				hook,
+			)
+
+			mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"role": "viewer",
+			}))
+		})
+
+		It("reports the rejection", func() {
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Allowed).To(BeFalse())
+			Expect(events[0].Status).To(Equal(http.StatusForbidden))
+			Expect(events[0].MatchedRule).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Audit paths", func() {
+
+	var events []authorizer.AuditEvent
+
+	hook := authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+		events = append(events, event)
+	})
+
+	BeforeEach(func() {
+		events = nil
+	})
+
+	serve := func(h http.Handler, path string) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost"+path, nil))
+	}
+
+	It("reports the raw path by default", func() {
+		serve(authorizer.NewHandler(newLogger(), http.NotFoundHandler(), hook), "/users/12345")
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Path).To(Equal("/users/12345"))
+		Expect(events[0].RawPath).To(Equal("/users/12345"))
+	})
+
+	It("reports the normalized path", func() {
+		serve(authorizer.NewHandler(newLogger(), http.NotFoundHandler(), hook,
+			authorizer.WithPathNormalizer(func(path string) string {
+				return regexp.MustCompile(`/[0-9]+`).ReplaceAllString(path, "/:id")
+			}),
+		), "/users/12345")
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Path).To(Equal("/users/:id"))
+		Expect(events[0].RawPath).To(Equal("/users/12345"))
+	})
+
+	It("reports the pattern of a mux route", func() {
+		m := authorizer.NewMux(newLogger(), hook,
+			authorizer.WithPathNormalizer(func(string) string { return "normalized" }),
+		)
+		m.Handle("GET /users/{id}", http.NotFoundHandler())
+		m.Handle("/static/", http.NotFoundHandler())
+
+		serve(m, "/users/12345")
+		serve(m, "/static/app.js")
+
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Path).To(Equal("/users/{id}"))
+		Expect(events[0].RawPath).To(Equal("/users/12345"))
+		Expect(events[1].Path).To(Equal("/static/"))
+		Expect(events[1].RawPath).To(Equal("/static/app.js"))
+	})
+})
+
+var _ = Describe("Rejection reasons", func() {
+
+	var (
+		req *http.Request
+		rec *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		var err error
+		req, err = http.NewRequest("GET", "http://localhost/some/path", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		rec = httptest.NewRecorder()
+	})
+
+	DescribeTable("reports the reason to audit hooks",
+		func(build func(hook func(authorizer.AuditEvent)) http.Handler, prepare func(*http.Request), expected error, status int) {
+			var observed []authorizer.AuditEvent
+
+			h := build(func(event authorizer.AuditEvent) {
+				observed = append(observed, event)
+			})
+
+			prepare(req)
+			h.ServeHTTP(rec, req)
+
+			Expect(rec.Result().StatusCode).To(Equal(status))
+			Expect(observed).To(HaveLen(1))
+			Expect(observed[0].Allowed).To(BeFalse())
+			Expect(observed[0].Status).To(Equal(status))
+			Expect(observed[0].Err).To(MatchError(expected))
+		},
+		Entry("a missing api key",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithApiKeys("key"),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) {},
+			authorizer.ErrApiKeyRequired, http.StatusUnauthorized,
+		),
+		Entry("an api key outside its scope",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithScopedApiKey("key", "/other"),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) { r.Header.Set("X-Api-Key", "key") },
+			authorizer.ErrApiKeyOutOfScope, http.StatusForbidden,
+		),
+		Entry("a missing authorization header",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(authorizer.New()),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) {},
+			authorizer.ErrNoCredentials, http.StatusUnauthorized,
+		),
+		Entry("a rejected token",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(authorizerFunc(func(*http.Request) error {
+						return authorizer.ErrInvalidSignature
+					})),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			authorizer.ErrCredentialsRejected, http.StatusUnauthorized,
+		),
+		Entry("wrong basic auth credentials",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithBasicAuthCredential("user", "pass"),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) { r.SetBasicAuth("user", "wrong") },
+			authorizer.ErrCredentialsRejected, http.StatusUnauthorized,
+		),
+		Entry("no basic auth credentials",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithBasicAuthCredential("user", "pass"),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) {},
+			authorizer.ErrNoCredentials, http.StatusUnauthorized,
+		),
+		Entry("unauthorized claims",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+						"role": "viewer",
+					}))),
+					authorizer.WithAuthorizedClaim("role", "admin"),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) {},
+			authorizer.ErrClaimsNotAuthorized, http.StatusForbidden,
+		),
+		Entry("an invalid configuration",
+			func(hook func(authorizer.AuditEvent)) http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithClaimExpression("claims.role =="),
+					authorizer.WithAuditHook(hook),
+				)
+			},
+			func(r *http.Request) {},
+			authorizer.ErrInvalidConfiguration, http.StatusInternalServerError,
+		),
+	)
+})
+
+var _ = Describe("WithRejectionStatus", func() {
+
+	var (
+		rec     *httptest.ResponseRecorder
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+
+		handler = authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(authorizerFunc(func(r *http.Request) error {
+				if r.Header.Get("Authorization") == "" {
+					return authorizer.ErrMissingAuthorizationHeader
+				}
+				return authorizeWithClaims(map[string]interface{}{"role": "viewer"})(r)
+			})),
+			authorizer.WithAuthorizedClaim("role", "admin"),
+			authorizer.WithRejectionStatus(authorizer.ErrClaimsNotAuthorized, http.StatusNotFound),
+		)
+	})
+
+	It("overrides the status for the reason", func() {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer token")
+
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("leaves other reasons alone", func() {
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
+
+var _ = Describe("WithAsyncAudit", func() {
+
+	var (
+		mu        sync.Mutex
+		delivered []authorizer.AuditEvent
+		drops     []int
+	)
+
+	deliveredCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered)
+	}
+
+	serve := func(h http.Handler) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+	}
+
+	BeforeEach(func() {
+		delivered = nil
+		drops = nil
+	})
+
+	It("does not delay requests on a slow hook and delivers waiting events on Close", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				time.Sleep(50 * time.Millisecond)
+				mu.Lock()
+				delivered = append(delivered, event)
+				mu.Unlock()
+			}),
+			authorizer.WithAsyncAudit(10, nil),
+		)
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			serve(h)
+		}
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+
+		Expect(h.Close()).To(Succeed())
+		Expect(deliveredCount()).To(Equal(5))
+	})
+
+	It("drops and counts events while the buffer is full", func() {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-release
+				mu.Lock()
+				delivered = append(delivered, event)
+				mu.Unlock()
+			}),
+			authorizer.WithAsyncAudit(2, func(dropped int) {
+				drops = append(drops, dropped)
+			}),
+		)
+
+		serve(h)
+		Eventually(started).Should(Receive())
+
+		for i := 0; i < 4; i++ {
+			serve(h)
+		}
+		Expect(drops).To(Equal([]int{1, 2}))
+
+		close(release)
+		Expect(h.Close()).To(Succeed())
+		Expect(deliveredCount()).To(Equal(3))
+	})
+})
+
+type authorizerFunc func(*http.Request) error
+
+func (f authorizerFunc) Authorize(r *http.Request) error {
+	return f(r)
+}
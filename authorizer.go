@@ -2,6 +2,7 @@ package authorizer
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"net/http"
 	"strings"
@@ -12,6 +13,8 @@ const (
 	subKey = "sub"
 	audKey = "aud"
 	expKey = "exp"
+	acrKey = "acr"
+	amrKey = "amr"
 )
 
 var (
@@ -81,6 +84,51 @@ func IncludeClaimAs(from string, to string) opt {
 	}
 }
 
+func AllowCredentialList() opt {
+	return func(a *authorizer) {
+		a.AllowCredentialList = true
+	}
+}
+
+// AllowStringContextKeys also stores included claims under their plain
+// string names, as before ContextKey, for callers still reading them that
+// way. Prefer Claim.
+func AllowStringContextKeys() opt {
+	return func(a *authorizer) {
+		a.StringContextKeys = true
+	}
+}
+
+// WithBase64HeaderToken reads the token, base64url encoded with or without
+// padding, from header when the request has no Authorization header. Values
+// that do not decode are rejected with ErrInvalidAuthorizationHeader.
+func WithBase64HeaderToken(header string) opt {
+	return func(a *authorizer) {
+		a.TokenExtractors = append(a.TokenExtractors, base64HeaderToken(header))
+		a.CredentialHeaders = append(a.CredentialHeaders, header)
+	}
+}
+
+// tokenExtractor finds the token in a request that has no Authorization
+// header. It reports false when the request carries no token for it.
+type tokenExtractor func(r *http.Request) (string, bool, error)
+
+func base64HeaderToken(header string) tokenExtractor {
+	return func(r *http.Request) (string, bool, error) {
+		value := r.Header.Get(header)
+		if value == "" {
+			return "", false, nil
+		}
+
+		token, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil || len(token) == 0 {
+			return "", true, ErrInvalidAuthorizationHeader
+		}
+
+		return string(token), true, nil
+	}
+}
+
 func New(opts ...opt) *authorizer {
 	auth := &authorizer{
 		Notary:       NewNotary(),
@@ -94,49 +142,200 @@ func New(opts ...opt) *authorizer {
 	return auth
 }
 
-type Notary interface {
-	Notarize(string) (map[string]interface{}, error)
-}
-
 type authorizer struct {
 	Notary
-	ClaimMapping map[string]string
+	ClaimMapping        map[string]string
+	AllowCredentialList bool
+	StringContextKeys   bool
+	TokenExtractors     []tokenExtractor
+	CredentialHeaders   []string
+}
+
+func (a *authorizer) credentialHeaders() []string {
+	return a.CredentialHeaders
+}
+
+func (a *authorizer) credentialCookies() []string {
+	return nil
 }
 
 func (a *authorizer) Authorize(r *http.Request) error {
 
 	header := r.Header["Authorization"]
 	if len(header) == 0 {
-		return ErrMissingAuthorizationHeader
+		return a.authorizeExtracted(r)
 	}
 
-	parts := strings.Split(header[0], " ")
+	if a.AllowCredentialList {
+		return a.authorizeCredentialList(r, header[0])
+	}
 
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return ErrInvalidAuthorizationHeader
+	token, err := parseBearer(header[0])
+	if err != nil {
+		return err
 	}
 
-	data, err := a.Notary.Notarize(parts[1])
+	claims, err := a.notarize(token)
 	if err != nil {
 		return err
 	}
 
-	return a.updateContext(r, data)
+	return a.updateContext(r, token, claims)
+}
+
+func (a *authorizer) authorizeExtracted(r *http.Request) error {
+
+	for _, extract := range a.TokenExtractors {
+		token, ok, err := extract(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		claims, err := a.notarize(token)
+		if err != nil {
+			return err
+		}
+
+		return a.updateContext(r, token, claims)
+	}
+
+	return ErrMissingAuthorizationHeader
+}
+
+func (a *authorizer) authorizeCredentialList(r *http.Request, header string) error {
+
+	err := ErrInvalidAuthorizationHeader
+
+	for _, credential := range splitCredentials(header) {
+		token, parseErr := parseBearer(strings.TrimSpace(credential))
+		if parseErr != nil {
+			continue
+		}
+
+		claims, notarizeErr := a.notarize(token)
+		if notarizeErr != nil {
+			err = notarizeErr
+			continue
+		}
+
+		return a.updateContext(r, token, claims)
+	}
+
+	return err
 }
 
-func (a *authorizer) updateContext(r *http.Request, data map[string]interface{}) error {
+func parseBearer(value string) (string, error) {
 
-	ctx := r.Context()
+	parts := strings.Split(value, " ")
+
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return "", ErrInvalidAuthorizationHeader
+	}
+
+	return parts[1], nil
+}
+
+func splitCredentials(header string) []string {
+
+	var credentials []string
+	var quoted, escaped bool
+
+	start := 0
+	for i, c := range header {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			credentials = append(credentials, header[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(credentials, header[start:])
+}
+
+type claimsNotary interface {
+	NotarizeClaims(string) (*Claims, error)
+}
+
+type startupValidator interface {
+	ValidateOnStartup(ctx context.Context) error
+}
+
+func (a *authorizer) ValidateOnStartup(ctx context.Context) error {
+	if notary, ok := a.Notary.(startupValidator); ok {
+		return notary.ValidateOnStartup(ctx)
+	}
+
+	return nil
+}
+
+func (a *authorizer) notarize(token string) (*Claims, error) {
+
+	if notary, ok := a.Notary.(claimsNotary); ok {
+		return notary.NotarizeClaims(token)
+	}
+
+	data, err := a.Notary.Notarize(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{Raw: data}, nil
+}
+
+// updateContext stores the claims, and the token they were verified from so
+// that the handler knows which credential of a list was accepted.
+func (a *authorizer) updateContext(r *http.Request, token string, claims *Claims) error {
+
+	values := make(contextValues, 0, 2+2*len(a.ClaimMapping))
+	values.set(claimsContextKey{}, claims)
+	values.set(acceptedTokenContextKey{}, token)
 
 	for key, claim := range a.ClaimMapping {
-		ctx = context.WithValue(ctx, key, data[claim])
+		value, _ := lookupClaim(claims.Raw, claim)
+		values.set(ContextKey(key), value)
+		if a.StringContextKeys {
+			values.set(key, value)
+		}
 	}
 
-	*r = *r.WithContext(ctx)
+	*r = *r.WithContext(values.context(r.Context()))
+
+	return nil
+}
+
+type claimsContextKey struct{}
+
+type acceptedTokenContextKey struct{}
 
+func ContextWithClaims(ctx context.Context, claims map[string]interface{}) context.Context {
+	return ContextWithTypedClaims(ctx, &Claims{Raw: claims})
+}
+
+func ContextWithTypedClaims(ctx context.Context, claims *Claims) context.Context {
+	return withValue(ctx, claimsContextKey{}, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) map[string]interface{} {
+	if claims := TypedClaimsFromContext(ctx); claims != nil {
+		return claims.Raw
+	}
 	return nil
 }
 
+func TypedClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims
+}
+
 func NoopAuthorizer() *noopAuthorizer {
 	return &noopAuthorizer{}
 }
@@ -146,3 +345,16 @@ type noopAuthorizer struct{}
 func (a *noopAuthorizer) Authorize(r *http.Request) error {
 	return nil
 }
+
+// DenyAllAuthorizer rejects every request that no other credential admits.
+// Unlike the default NoopAuthorizer, a handler with no claims configured then
+// authorizes nothing.
+func DenyAllAuthorizer() *denyAllAuthorizer {
+	return &denyAllAuthorizer{}
+}
+
+type denyAllAuthorizer struct{}
+
+func (a *denyAllAuthorizer) Authorize(r *http.Request) error {
+	return ErrDeniedByDefault
+}
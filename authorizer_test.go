@@ -1,6 +1,7 @@
 package authorizer_test
 
 import (
+	"encoding/base64"
 	"errors"
 	"net/http"
 
@@ -114,10 +115,187 @@ var _ = Describe("Authorizer", func() {
 				})
 
 				It("updates the context with the subject", func() {
-					value := req.Context().Value("some-key")
+					value := req.Context().Value(authorizer.ContextKey("some-key"))
 					Expect(value).To(Equal("some-value"))
 				})
 			})
+
+			Context("when configured to also use string context keys", func() {
+				BeforeEach(func() {
+					authz = authorizer.New(
+						authorizer.WithNotary(mockNotary),
+						authorizer.IncludeSubjectAs("some-key"),
+						authorizer.AllowStringContextKeys(),
+					)
+
+					mockNotary.EXPECT().Notarize("token").Return(map[string]interface{}{
+						"sub": "some-value",
+					}, nil)
+				})
+
+				It("updates the context under both keys", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("some-key"))).To(Equal("some-value"))
+					Expect(req.Context().Value("some-key")).To(Equal("some-value"))
+				})
+			})
+		})
+
+		Context("when configured to include a nested claim", func() {
+			BeforeEach(func() {
+				req.Header.Set("Authorization", "Bearer token")
+
+				authz = authorizer.New(
+					authorizer.WithNotary(mockNotary),
+					authorizer.IncludeClaimAs("metadata.org_id", "org"),
+				)
+
+				mockNotary.EXPECT().Notarize("token").Return(map[string]interface{}{
+					"metadata": map[string]interface{}{"org_id": "acme"},
+				}, nil)
+			})
+
+			It("updates the context with the nested value", func() {
+				Expect(req.Context().Value(authorizer.ContextKey("org"))).To(Equal("acme"))
+			})
+		})
+
+		Context("when configured with a base64 header token", func() {
+			BeforeEach(func() {
+				authz = authorizer.New(
+					authorizer.WithNotary(mockNotary),
+					authorizer.WithBase64HeaderToken("X-Grpc-Web-Authorization"),
+					authorizer.IncludeSubjectAs("some-key"),
+				)
+			})
+
+			Context("when the header is padded", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Grpc-Web-Authorization", base64.URLEncoding.EncodeToString([]byte("some.jwt")))
+					mockNotary.EXPECT().Notarize("some.jwt").Return(map[string]interface{}{"sub": "some-value"}, nil)
+				})
+
+				It("decodes the token", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(req.Context().Value(authorizer.ContextKey("some-key"))).To(Equal("some-value"))
+				})
+			})
+
+			Context("when the header is not padded", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Grpc-Web-Authorization", base64.RawURLEncoding.EncodeToString([]byte("some.jwt")))
+					mockNotary.EXPECT().Notarize("some.jwt").Return(map[string]interface{}{}, nil)
+				})
+
+				It("decodes the token", func() {
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when the header is not valid base64", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Grpc-Web-Authorization", "not base64!")
+				})
+
+				It("errors", func() {
+					Expect(err).To(Equal(authorizer.ErrInvalidAuthorizationHeader))
+				})
+			})
+
+			Context("when the authorization header is also set", func() {
+				BeforeEach(func() {
+					req.Header.Set("Authorization", "Bearer token")
+					req.Header.Set("X-Grpc-Web-Authorization", "not base64!")
+					mockNotary.EXPECT().Notarize("token").Return(map[string]interface{}{}, nil)
+				})
+
+				It("uses the authorization header", func() {
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when neither header is set", func() {
+				It("errors", func() {
+					Expect(err).To(Equal(authorizer.ErrMissingAuthorizationHeader))
+				})
+			})
+		})
+
+		Context("when the header contains a list of credentials", func() {
+			BeforeEach(func() {
+				req.Header.Set("Authorization", "Bearer aaa, Bearer bbb")
+			})
+
+			Context("when credential lists are not allowed", func() {
+				It("errors", func() {
+					Expect(err).To(Equal(authorizer.ErrInvalidAuthorizationHeader))
+				})
+			})
+
+			Context("when credential lists are allowed", func() {
+				BeforeEach(func() {
+					authz = authorizer.New(
+						authorizer.WithNotary(mockNotary),
+						authorizer.AllowCredentialList(),
+						authorizer.IncludeSubjectAs("some-key"),
+					)
+				})
+
+				Context("when the first credential is valid", func() {
+					BeforeEach(func() {
+						mockNotary.EXPECT().Notarize("aaa").Return(map[string]interface{}{"sub": "first"}, nil)
+					})
+
+					It("succeeds without trying the rest", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(req.Context().Value(authorizer.ContextKey("some-key"))).To(Equal("first"))
+					})
+				})
+
+				Context("when only the second credential is valid", func() {
+					BeforeEach(func() {
+						mockNotary.EXPECT().Notarize("aaa").Return(nil, errors.New("nope"))
+						mockNotary.EXPECT().Notarize("bbb").Return(map[string]interface{}{"sub": "second"}, nil)
+					})
+
+					It("succeeds with the second credential", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(req.Context().Value(authorizer.ContextKey("some-key"))).To(Equal("second"))
+					})
+				})
+
+				Context("when no credential is valid", func() {
+					BeforeEach(func() {
+						mockNotary.EXPECT().Notarize("aaa").Return(nil, errors.New("nope"))
+						mockNotary.EXPECT().Notarize("bbb").Return(nil, authorizer.ErrTokenExpired)
+					})
+
+					It("errors", func() {
+						Expect(err).To(HaveOccurred())
+					})
+				})
+
+				Context("when the list contains malformed members", func() {
+					BeforeEach(func() {
+						req.Header.Set("Authorization", `garbage, Basic realm="a, b", Bearer, Bearer bbb`)
+						mockNotary.EXPECT().Notarize("bbb").Return(map[string]interface{}{"sub": "second"}, nil)
+					})
+
+					It("skips them", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(req.Context().Value(authorizer.ContextKey("some-key"))).To(Equal("second"))
+					})
+				})
+
+				Context("when every member is malformed", func() {
+					BeforeEach(func() {
+						req.Header.Set("Authorization", "garbage, Basic abc")
+					})
+
+					It("errors", func() {
+						Expect(err).To(Equal(authorizer.ErrInvalidAuthorizationHeader))
+					})
+				})
+			})
 		})
 	})
 })
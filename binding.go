@@ -0,0 +1,159 @@
+package authorizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrTokenBindingMismatch = errors.New("token binding mismatch")
+
+// defaultBindingTTL bounds bindings for tokens that carry no expiry.
+const defaultBindingTTL = time.Hour
+
+// BindingStrategy derives a client fingerprint from a request.
+type BindingStrategy interface {
+	Fingerprint(r *http.Request) string
+}
+
+type BindingStrategyFunc func(r *http.Request) string
+
+func (f BindingStrategyFunc) Fingerprint(r *http.Request) string {
+	return f(r)
+}
+
+// BindToClientIP fingerprints requests by client address, as reported by
+// ClientIP with the handler's WithTrustedProxies.
+func BindToClientIP() BindingStrategy {
+	return clientIPBinding{}
+}
+
+func BindToUserAgent() BindingStrategy {
+	return BindingStrategyFunc(func(r *http.Request) string {
+		return r.UserAgent()
+	})
+}
+
+// BindToClientIPAndUserAgent fingerprints requests by client address, as
+// BindToClientIP does, and user agent.
+func BindToClientIPAndUserAgent() BindingStrategy {
+	return clientIPBinding{userAgent: true}
+}
+
+type clientIPBinding struct {
+	userAgent bool
+}
+
+// Fingerprint uses the direct peer address; the handler calls fingerprint
+// instead, with the number of proxies it trusts.
+func (b clientIPBinding) Fingerprint(r *http.Request) string {
+	return b.fingerprint(r, 0)
+}
+
+func (b clientIPBinding) fingerprint(r *http.Request, trustProxies int) string {
+	host := r.RemoteAddr
+	if addr := ClientIP(r, trustProxies); addr.IsValid() {
+		host = addr.String()
+	}
+
+	if b.userAgent {
+		return host + "|" + r.UserAgent()
+	}
+	return host
+}
+
+// WithTokenBinding rejects bearer tokens used from a different fingerprint
+// than the one they were first seen with, for as long as the token is valid.
+// Clients behind proxies or changing networks will be locked out, so only use
+// this where client addresses are stable.
+func WithTokenBinding(store BindingStore, strategy BindingStrategy) handlerOpt {
+	return func(h *handler) {
+		if store != nil && strategy == nil {
+			h.configError("WithTokenBinding", fmt.Errorf("%w: a binding store needs a strategy", ErrInvalidConfiguration))
+			return
+		}
+		h.BindingStore = store
+		h.BindingStrategy = strategy
+	}
+}
+
+func (h *handler) checkBinding(r *http.Request) error {
+
+	token, ok := bearerToken(r)
+	if h.BindingStore == nil || !ok {
+		return nil
+	}
+
+	expiry := time.Now().Add(defaultBindingTTL)
+	if claims := TypedClaimsFromContext(r.Context()); claims != nil && !claims.Expiry.IsZero() {
+		expiry = claims.Expiry
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	fingerprint := h.fingerprint(r)
+
+	bound, err := h.BindingStore.Bind(hex.EncodeToString(sum[:]), fingerprint, expiry)
+	if err != nil {
+		return err
+	}
+
+	if bound != fingerprint {
+		return ErrTokenBindingMismatch
+	}
+
+	return nil
+}
+
+func (h *handler) fingerprint(r *http.Request) string {
+	if strategy, ok := h.BindingStrategy.(clientIPBinding); ok {
+		return strategy.fingerprint(r, h.TrustedProxies)
+	}
+	return h.BindingStrategy.Fingerprint(r)
+}
+
+func NewMemoryBindingStore() *memoryBindingStore {
+	return &memoryBindingStore{
+		Now:      time.Now,
+		bindings: map[string]binding{},
+	}
+}
+
+type memoryBindingStore struct {
+	sync.Mutex
+	Now func() time.Time
+
+	bindings  map[string]binding
+	lastPrune time.Time
+}
+
+type binding struct {
+	Fingerprint string
+	Expiry      time.Time
+}
+
+func (s *memoryBindingStore) Bind(key, fingerprint string, expiry time.Time) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.Now()
+
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, b := range s.bindings {
+			if !now.Before(b.Expiry) {
+				delete(s.bindings, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if existing, ok := s.bindings[key]; ok && now.Before(existing.Expiry) {
+		return existing.Fingerprint, nil
+	}
+
+	s.bindings[key] = binding{fingerprint, expiry}
+	return fingerprint, nil
+}
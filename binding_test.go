@@ -0,0 +1,153 @@
+package authorizer_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reverted/authorizer"
+	"github.com/reverted/authorizer/mocks"
+)
+
+var _ = Describe("WithTokenBinding", func() {
+
+	var (
+		events  []authorizer.AuditEvent
+		handler http.Handler
+	)
+
+	serve := func(token, remoteAddr, userAgent string) int {
+		req, err := http.NewRequest("GET", "http://localhost/some/path", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		events = nil
+
+		handler = authorizer.NewHandler(
+			newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(func(r *http.Request) error {
+				*r = *r.WithContext(authorizer.ContextWithTypedClaims(r.Context(), &authorizer.Claims{
+					Expiry: time.Now().Add(time.Minute),
+				}))
+				return nil
+			})),
+			authorizer.WithTokenBinding(authorizer.NewMemoryBindingStore(), authorizer.BindToClientIPAndUserAgent()),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				events = append(events, event)
+			}),
+		)
+	})
+
+	It("accepts the first use of a token", func() {
+		Expect(serve("token", "10.0.0.1:1234", "agent")).To(Equal(http.StatusOK))
+	})
+
+	It("accepts reuse from the same fingerprint", func() {
+		Expect(serve("token", "10.0.0.1:1234", "agent")).To(Equal(http.StatusOK))
+		Expect(serve("token", "10.0.0.1:5678", "agent")).To(Equal(http.StatusOK))
+	})
+
+	It("rejects reuse from a different client", func() {
+		Expect(serve("token", "10.0.0.1:1234", "agent")).To(Equal(http.StatusOK))
+		Expect(serve("token", "10.0.0.2:1234", "agent")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("token", "10.0.0.1:1234", "other agent")).To(Equal(http.StatusUnauthorized))
+
+		Expect(events[len(events)-1].Err).To(MatchError(authorizer.ErrTokenBindingMismatch))
+	})
+
+	It("binds each token separately", func() {
+		Expect(serve("token", "10.0.0.1:1234", "agent")).To(Equal(http.StatusOK))
+		Expect(serve("other", "10.0.0.2:1234", "agent")).To(Equal(http.StatusOK))
+	})
+
+	Context("behind a trusted proxy", func() {
+		serveVia := func(forwardedFor string) int {
+			req := httptest.NewRequest("GET", "http://localhost/some/path", nil)
+			req.RemoteAddr = "10.0.0.100:1234"
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			req.Header.Set("Authorization", "Bearer token")
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(
+				newLogger(),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{}))),
+				authorizer.WithTokenBinding(authorizer.NewMemoryBindingStore(), authorizer.BindToClientIP()),
+				authorizer.WithTrustedProxies(1),
+			)
+		})
+
+		It("binds to the forwarded client address", func() {
+			Expect(serveVia("192.0.2.1")).To(Equal(http.StatusOK))
+			Expect(serveVia("192.0.2.1")).To(Equal(http.StatusOK))
+			Expect(serveVia("192.0.2.2")).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	It("rejects the token when the store fails", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+
+		store := mocks.NewMockBindingStore(mockCtrl)
+		store.EXPECT().Bind(gomock.Any(), "10.0.0.1", gomock.Any()).Return("", errors.New("unavailable"))
+
+		handler = authorizer.NewHandler(
+			newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{}))),
+			authorizer.WithTokenBinding(store, authorizer.BindToClientIP()),
+		)
+
+		Expect(serve("token", "10.0.0.1:1234", "agent")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("needs a strategy", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithTokenBinding(authorizer.NewMemoryBindingStore(), nil),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrInvalidConfiguration))
+	})
+
+	Describe("NewMemoryBindingStore", func() {
+		It("forgets bindings once they expire", func() {
+			store := authorizer.NewMemoryBindingStore()
+			now := time.Now()
+			store.Now = func() time.Time { return now }
+
+			bound, err := store.Bind("key", "first", now.Add(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bound).To(Equal("first"))
+
+			bound, err = store.Bind("key", "second", now.Add(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bound).To(Equal("first"))
+
+			now = now.Add(2 * time.Minute)
+
+			bound, err = store.Bind("key", "second", now.Add(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bound).To(Equal("second"))
+		})
+	})
+})
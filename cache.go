@@ -0,0 +1,95 @@
+package authorizer
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// NewMemoryCache returns an in-process Cache holding at most maxEntries
+// values, evicting the least recently used when full.
+func NewMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		Now:        time.Now,
+		MaxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+type memoryCache struct {
+	sync.Mutex
+	Now        func() time.Time
+	MaxEntries int
+
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type memoryCacheEntry struct {
+	key    string
+	value  []byte
+	expiry time.Time
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+	if !entry.expiry.IsZero() && !c.Now().Before(entry.expiry) {
+		c.remove(element)
+		return nil, ErrCacheMiss
+	}
+
+	c.order.MoveToFront(element)
+	return append([]byte{}, entry.value...), nil
+}
+
+func (c *memoryCache) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	entry := &memoryCacheEntry{key: key, value: append([]byte{}, value...)}
+	if ttl > 0 {
+		entry.expiry = c.Now().Add(ttl)
+	}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.MaxEntries > 0 && c.order.Len() > c.MaxEntries {
+		c.remove(c.order.Back())
+	}
+
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *memoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*memoryCacheEntry).key)
+}
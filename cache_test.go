@@ -0,0 +1,55 @@
+package authorizer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+	"github.com/reverted/authorizer/cachetest"
+)
+
+func TestMemoryCacheConformance(t *testing.T) {
+	cachetest.Run(t, func() authorizer.Cache {
+		return authorizer.NewMemoryCache(100)
+	})
+}
+
+var _ = Describe("NewMemoryCache", func() {
+
+	var ctx = context.Background()
+
+	It("evicts the least recently used value when full", func() {
+		cache := authorizer.NewMemoryCache(2)
+
+		Expect(cache.SetWithTTL(ctx, "a", []byte("a"), 0)).To(Succeed())
+		Expect(cache.SetWithTTL(ctx, "b", []byte("b"), 0)).To(Succeed())
+
+		_, err := cache.Get(ctx, "a")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cache.SetWithTTL(ctx, "c", []byte("c"), 0)).To(Succeed())
+
+		_, err = cache.Get(ctx, "b")
+		Expect(err).To(MatchError(authorizer.ErrCacheMiss))
+
+		_, err = cache.Get(ctx, "a")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("expires values against its clock", func() {
+		cache := authorizer.NewMemoryCache(2)
+		now := time.Now()
+		cache.Now = func() time.Time { return now }
+
+		Expect(cache.SetWithTTL(ctx, "a", []byte("a"), time.Minute)).To(Succeed())
+
+		now = now.Add(time.Minute)
+
+		_, err := cache.Get(ctx, "a")
+		Expect(err).To(MatchError(authorizer.ErrCacheMiss))
+	})
+})
@@ -0,0 +1,149 @@
+// Package cachetest checks that a Cache implementation follows the semantics
+// documented on authorizer.Cache. Third-party implementations can run it
+// from their own tests:
+//
+//	func TestRedisCache(t *testing.T) {
+//		cachetest.Run(t, func() authorizer.Cache { return newRedisCache(t) })
+//	}
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/reverted/authorizer"
+)
+
+// precision is how far implementations may round a ttl up.
+const precision = time.Second
+
+// Run exercises a fresh cache from newCache in each subtest.
+func Run(t *testing.T, newCache func() authorizer.Cache) {
+
+	ctx := context.Background()
+
+	t.Run("missing keys miss", func(t *testing.T) {
+		expectMiss(t, newCache(), "missing")
+	})
+
+	t.Run("values round trip", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "key", "value", 0)
+		expectValue(t, cache, "key", "value")
+	})
+
+	t.Run("set replaces values", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "key", "first", 0)
+		set(t, cache, "key", "second", 0)
+		expectValue(t, cache, "key", "second")
+	})
+
+	t.Run("empty values are stored", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "key", "", 0)
+		expectValue(t, cache, "key", "")
+	})
+
+	t.Run("delete removes values", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "key", "value", 0)
+
+		if err := cache.Delete(ctx, "key"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		expectMiss(t, cache, "key")
+	})
+
+	t.Run("delete of a missing key succeeds", func(t *testing.T) {
+		if err := newCache().Delete(ctx, "missing"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+	})
+
+	t.Run("values do not alias", func(t *testing.T) {
+		cache := newCache()
+
+		value := []byte("value")
+		if err := cache.SetWithTTL(ctx, "key", value, 0); err != nil {
+			t.Fatalf("SetWithTTL: %v", err)
+		}
+		value[0] = 'X'
+
+		got, err := cache.Get(ctx, "key")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		got[0] = 'Y'
+
+		expectValue(t, cache, "key", "value")
+	})
+
+	t.Run("values expire after their ttl", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "key", "value", precision)
+		expectValue(t, cache, "key", "value")
+
+		time.Sleep(2*precision + 100*time.Millisecond)
+		expectMiss(t, cache, "key")
+	})
+
+	t.Run("values without a ttl do not expire", func(t *testing.T) {
+		cache := newCache()
+		set(t, cache, "forever", "value", 0)
+		set(t, cache, "expiring", "value", precision)
+
+		time.Sleep(2*precision + 100*time.Millisecond)
+		expectValue(t, cache, "forever", "value")
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		cache := newCache()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					key := fmt.Sprintf("key-%d", j%10)
+					_ = cache.SetWithTTL(ctx, key, []byte(key), 0)
+					if value, err := cache.Get(ctx, key); err == nil && string(value) != key {
+						t.Errorf("Get(%q) = %q", key, value)
+					}
+					_ = cache.Delete(ctx, key)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func set(t *testing.T, cache authorizer.Cache, key, value string, ttl time.Duration) {
+	t.Helper()
+	if err := cache.SetWithTTL(context.Background(), key, []byte(value), ttl); err != nil {
+		t.Fatalf("SetWithTTL(%q): %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, cache authorizer.Cache, key, expected string) {
+	t.Helper()
+	value, err := cache.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if string(value) != expected {
+		t.Fatalf("Get(%q) = %q, want %q", key, value, expected)
+	}
+}
+
+func expectMiss(t *testing.T, cache authorizer.Cache, key string) {
+	t.Helper()
+	if _, err := cache.Get(context.Background(), key); !errors.Is(err, authorizer.ErrCacheMiss) {
+		t.Fatalf("Get(%q) = %v, want ErrCacheMiss", key, err)
+	}
+}
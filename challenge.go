@@ -0,0 +1,84 @@
+package authorizer
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Challenge is advertised in a WWW-Authenticate header on 401 responses.
+type Challenge struct {
+	Scheme string
+	Realm  string
+}
+
+func (c Challenge) header(invalidToken bool) string {
+	challenge := c.Scheme + ` realm="` + quoteEscaper.Replace(c.Realm) + `"`
+	if invalidToken && strings.EqualFold(c.Scheme, "Bearer") {
+		challenge += `, error="invalid_token"`
+	}
+	return challenge
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// WithChallenge advertises scheme and realm in a WWW-Authenticate header on
+// every 401 response. When basic auth credentials are configured alongside an
+// authorizer, both the Basic and Bearer schemes are advertised in realm.
+func WithChallenge(scheme, realm string) handlerOpt {
+	return func(h *handler) {
+		h.Challenges = append(h.Challenges, Challenge{Scheme: scheme, Realm: realm})
+	}
+}
+
+func (h *handler) challenges() []Challenge {
+
+	if len(h.Challenges) == 0 {
+		return nil
+	}
+
+	challenges := h.Challenges
+	realm := challenges[0].Realm
+
+	_, noop := h.Authorizer.(*noopAuthorizer)
+	if !noop && !h.hasChallenge("Bearer") {
+		challenges = append(challenges, Challenge{Scheme: "Bearer", Realm: realm})
+	}
+	if len(h.BasicAuthCredentials) > 0 && !h.hasChallenge("Basic") {
+		challenges = append(challenges, Challenge{Scheme: "Basic", Realm: realm})
+	}
+
+	return challenges
+}
+
+func (h *handler) hasChallenge(scheme string) bool {
+	for _, challenge := range h.Challenges {
+		if strings.EqualFold(challenge.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// setChallenges leaves challenges already set for the request, such as a
+// step-up challenge, in place.
+func (h *handler) setChallenges(w http.ResponseWriter, err *rejection) {
+
+	if w.Header().Get("WWW-Authenticate") != "" {
+		return
+	}
+
+	// Credentials that were presented but failed verification, including
+	// unclassified authorizer failures, are an invalid_token.
+	var invalidToken bool
+	switch ClassifyError(err) {
+	case RejectionNoCredentials, RejectionPolicyDenied:
+	case RejectionOther:
+		invalidToken = err.Reason == ErrCredentialsRejected && err.Cause != nil
+	default:
+		invalidToken = true
+	}
+
+	for _, challenge := range h.challenges() {
+		w.Header().Add("WWW-Authenticate", challenge.header(invalidToken))
+	}
+}
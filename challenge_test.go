@@ -0,0 +1,115 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithChallenge", func() {
+
+	rejectToken := authorizerFunc(func(*http.Request) error {
+		return authorizer.ErrInvalidSignature
+	})
+
+	DescribeTable("advertises challenges on 401",
+		func(build func() http.Handler, prepare func(*http.Request), status int, challenges []string) {
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			prepare(req)
+
+			rec := httptest.NewRecorder()
+			build().ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(status))
+			Expect(rec.Header().Values("WWW-Authenticate")).To(Equal(challenges))
+		},
+		Entry("a missing bearer token",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(authorizer.New()),
+					authorizer.WithChallenge("Bearer", "api"),
+				)
+			},
+			func(r *http.Request) {},
+			http.StatusUnauthorized, []string{`Bearer realm="api"`},
+		),
+		Entry("a token rejected by the authorizer",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(rejectToken),
+					authorizer.WithChallenge("Bearer", "api"),
+				)
+			},
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			http.StatusUnauthorized, []string{`Bearer realm="api", error="invalid_token"`},
+		),
+		Entry("basic auth credentials",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithBasicAuthCredential("user", "pass"),
+					authorizer.WithChallenge("Basic", "admin"),
+				)
+			},
+			func(r *http.Request) { r.SetBasicAuth("user", "wrong") },
+			http.StatusUnauthorized, []string{`Basic realm="admin"`},
+		),
+		Entry("basic auth credentials alongside an authorizer",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(rejectToken),
+					authorizer.WithBasicAuthCredential("user", "pass"),
+					authorizer.WithChallenge("Bearer", "api"),
+				)
+			},
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			http.StatusUnauthorized, []string{`Bearer realm="api", error="invalid_token"`, `Basic realm="api"`},
+		),
+		Entry("a realm containing quotes",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithBasicAuthCredential("user", "pass"),
+					authorizer.WithChallenge("Basic", `the "admin" area`),
+				)
+			},
+			func(r *http.Request) {},
+			http.StatusUnauthorized, []string{`Basic realm="the \"admin\" area"`},
+		),
+		Entry("a step-up challenge",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+						"acr": "pwd",
+					}))),
+					authorizer.WithRequiredACR("mfa"),
+					authorizer.WithChallenge("Bearer", "api"),
+				)
+			},
+			func(r *http.Request) {},
+			http.StatusUnauthorized, []string{`Bearer error="insufficient_authentication", error_description="step-up authentication required", acr_values="mfa"`},
+		),
+		Entry("an out of scope api key",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithScopedApiKey("key", "/admin"),
+					authorizer.WithChallenge("Bearer", "api"),
+				)
+			},
+			func(r *http.Request) { r.Header.Set("X-Api-Key", "key") },
+			http.StatusForbidden, nil,
+		),
+		Entry("no configured challenge",
+			func() http.Handler {
+				return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+					authorizer.WithAuthorizer(rejectToken),
+				)
+			},
+			func(r *http.Request) {},
+			http.StatusUnauthorized, nil,
+		),
+	)
+})
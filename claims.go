@@ -0,0 +1,131 @@
+package authorizer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	ExpirationKey = "exp"
+	IssuedAtKey   = "iat"
+	NotBeforeKey  = "nbf"
+)
+
+// ContextKey is the key under which values included in the context, such as
+// mapped claims and headers, are stored.
+type ContextKey string
+
+// Claim returns the value included in the context under name or, failing
+// that, the claim of that name. It is the documented way to read identity
+// downstream.
+func Claim(ctx context.Context, name string) (any, bool) {
+	if value := ctx.Value(ContextKey(name)); value != nil {
+		return value, true
+	}
+	return lookupClaim(ClaimsFromContext(ctx), name)
+}
+
+// Subject returns the sub claim, or the value included in the context as
+// sub.
+func Subject(ctx context.Context) (string, bool) {
+	value, _ := Claim(ctx, "sub")
+	subject, ok := value.(string)
+	return subject, ok && subject != ""
+}
+
+func ExpirationFromContext(ctx context.Context) (time.Time, bool) {
+	return timeFromContext(ctx, ExpirationKey)
+}
+
+func IssuedAtFromContext(ctx context.Context) (time.Time, bool) {
+	return timeFromContext(ctx, IssuedAtKey)
+}
+
+func NotBeforeFromContext(ctx context.Context) (time.Time, bool) {
+	return timeFromContext(ctx, NotBeforeKey)
+}
+
+// DeprecatedAudienceFromContext reports the deprecated audience the request's
+// token was accepted for, if any.
+func DeprecatedAudienceFromContext(ctx context.Context) (string, bool) {
+	if claims := TypedClaimsFromContext(ctx); claims != nil && claims.DeprecatedAudience != "" {
+		return claims.DeprecatedAudience, true
+	}
+	return "", false
+}
+
+type tokenContextKey struct{}
+
+// TokenFromContext returns the raw bearer token stored by
+// IncludeTokenInContext.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(Redacted)
+	return token.Reveal(), ok
+}
+
+func timeFromContext(ctx context.Context, key string) (time.Time, bool) {
+	value, ok := ctx.Value(ContextKey(key)).(time.Time)
+	return value, ok
+}
+
+func mappedValue(value interface{}, transform func(interface{}) interface{}) interface{} {
+	if transform == nil || value == nil {
+		return value
+	}
+	return transform(value)
+}
+
+// NumericDateToTime is a transform for IncludeClaimInContextWith that
+// converts a numeric date claim, such as exp, into a time.Time.
+func NumericDateToTime(value any) any {
+	return numericDate(value)
+}
+
+// Lowercase is a transform for IncludeClaimInContextWith that lowercases
+// string claims, such as email.
+func Lowercase(value any) any {
+	if s, ok := value.(string); ok {
+		return strings.ToLower(s)
+	}
+	return nil
+}
+
+// numericDate converts a JWT NumericDate claim, however it was decoded, into
+// a time.Time. It returns nil when the value is not a numeric date.
+func numericDate(value interface{}) interface{} {
+	if t, ok := toTime(value); ok {
+		return t
+	}
+	return nil
+}
+
+func toTime(value interface{}) (time.Time, bool) {
+
+	var seconds float64
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case float64:
+		seconds = v
+	case float32:
+		seconds = float64(v)
+	case int:
+		seconds = float64(v)
+	case int64:
+		seconds = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		seconds = f
+	default:
+		return time.Time{}, false
+	}
+
+	whole := int64(seconds)
+	return time.Unix(whole, int64((seconds-float64(whole))*float64(time.Second))), true
+}
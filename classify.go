@@ -0,0 +1,57 @@
+package authorizer
+
+import "errors"
+
+// RejectionClass is a small, stable classification of rejections for
+// dashboards and alerts, separating clients with stale credentials from
+// tokens that look forged or replayed.
+type RejectionClass string
+
+const (
+	RejectionExpired          RejectionClass = "expired"
+	RejectionNotYetValid      RejectionClass = "not_yet_valid"
+	RejectionInvalidSignature RejectionClass = "invalid_signature"
+	RejectionMalformed        RejectionClass = "malformed"
+	RejectionWrongAudience    RejectionClass = "wrong_audience"
+	RejectionRevoked          RejectionClass = "revoked"
+	RejectionPolicyDenied     RejectionClass = "policy_denied"
+	RejectionNoCredentials    RejectionClass = "no_credentials"
+	RejectionOther            RejectionClass = "other"
+)
+
+// rejectionClasses is checked in order, so that the cause of a rejection
+// decides its class before the more general reason does.
+var rejectionClasses = []struct {
+	class  RejectionClass
+	errors []error
+}{
+	{RejectionExpired, []error{ErrTokenExpired, ErrSessionExpired, ErrReauthenticationRequired}},
+	{RejectionNotYetValid, []error{ErrTokenNotYetValid}},
+	{RejectionRevoked, []error{ErrTokenBindingMismatch}},
+	{RejectionInvalidSignature, []error{ErrInvalidSignature, ErrNoPublicKey, ErrUntrustedEmbeddedKey, ErrInsecureAlgorithm, ErrInvalidSession}},
+	{RejectionWrongAudience, []error{ErrInvalidAudience, ErrInvalidIssuer, ErrIssuerNotAuthorized}},
+	{RejectionMalformed, []error{ErrInvalidToken, ErrInvalidAuthorizationHeader, ErrMissingExpiry, ErrMissingIssuedAt, ErrClaimsTooLarge, ErrDuplicateCredential, ErrMissingMappedClaim}},
+	{RejectionNoCredentials, []error{ErrNoCredentials, ErrMissingAuthorizationHeader, ErrMissingSessionCookie, ErrApiKeyRequired, ErrMissingUpstreamClaims}},
+	{RejectionPolicyDenied, []error{ErrClaimsNotAuthorized, ErrApiKeyOutOfScope, ErrRequestVetoed, ErrInsufficientScope, ErrInsufficientAuthentication, ErrActorNotAllowed, ErrDeniedByDefault}},
+}
+
+// ClassifyError reports the class of a rejection or of an error returned by
+// an authorizer. Errors it does not know, including configuration and key
+// set failures, are RejectionOther.
+func ClassifyError(err error) RejectionClass {
+
+	var expired *TokenExpiredError
+	if errors.As(err, &expired) {
+		return RejectionExpired
+	}
+
+	for _, c := range rejectionClasses {
+		for _, target := range c.errors {
+			if errors.Is(err, target) {
+				return c.class
+			}
+		}
+	}
+
+	return RejectionOther
+}
@@ -0,0 +1,120 @@
+package authorizer_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("ClassifyError", func() {
+
+	DescribeTable("classifies",
+		func(err error, class authorizer.RejectionClass) {
+			Expect(authorizer.ClassifyError(err)).To(Equal(class))
+			Expect(authorizer.ClassifyError(fmt.Errorf("wrapped: %w", err))).To(Equal(class))
+		},
+		Entry("ErrTokenExpired", authorizer.ErrTokenExpired, authorizer.RejectionExpired),
+		Entry("ErrSessionExpired", authorizer.ErrSessionExpired, authorizer.RejectionExpired),
+		Entry("TokenExpiredError", &authorizer.TokenExpiredError{Expiry: time.Now()}, authorizer.RejectionExpired),
+		Entry("ErrTokenNotYetValid", authorizer.ErrTokenNotYetValid, authorizer.RejectionNotYetValid),
+
+		Entry("ErrInvalidSignature", authorizer.ErrInvalidSignature, authorizer.RejectionInvalidSignature),
+		Entry("ErrNoPublicKey", authorizer.ErrNoPublicKey, authorizer.RejectionInvalidSignature),
+		Entry("ErrUntrustedEmbeddedKey", authorizer.ErrUntrustedEmbeddedKey, authorizer.RejectionInvalidSignature),
+		Entry("ErrInsecureAlgorithm", authorizer.ErrInsecureAlgorithm, authorizer.RejectionInvalidSignature),
+		Entry("ErrInvalidSession", authorizer.ErrInvalidSession, authorizer.RejectionInvalidSignature),
+
+		Entry("ErrInvalidToken", authorizer.ErrInvalidToken, authorizer.RejectionMalformed),
+		Entry("ErrInvalidAuthorizationHeader", authorizer.ErrInvalidAuthorizationHeader, authorizer.RejectionMalformed),
+		Entry("ErrMissingExpiry", authorizer.ErrMissingExpiry, authorizer.RejectionMalformed),
+		Entry("ErrMissingIssuedAt", authorizer.ErrMissingIssuedAt, authorizer.RejectionMalformed),
+		Entry("ErrClaimsTooLarge", authorizer.ErrClaimsTooLarge, authorizer.RejectionMalformed),
+		Entry("ErrDuplicateCredential", authorizer.ErrDuplicateCredential, authorizer.RejectionMalformed),
+
+		Entry("ErrInvalidAudience", authorizer.ErrInvalidAudience, authorizer.RejectionWrongAudience),
+		Entry("ErrInvalidIssuer", authorizer.ErrInvalidIssuer, authorizer.RejectionWrongAudience),
+		Entry("ErrIssuerNotAuthorized", authorizer.ErrIssuerNotAuthorized, authorizer.RejectionWrongAudience),
+
+		Entry("ErrTokenBindingMismatch", authorizer.ErrTokenBindingMismatch, authorizer.RejectionRevoked),
+
+		Entry("ErrClaimsNotAuthorized", authorizer.ErrClaimsNotAuthorized, authorizer.RejectionPolicyDenied),
+		Entry("ErrApiKeyOutOfScope", authorizer.ErrApiKeyOutOfScope, authorizer.RejectionPolicyDenied),
+		Entry("ErrRequestVetoed", authorizer.ErrRequestVetoed, authorizer.RejectionPolicyDenied),
+		Entry("ErrInsufficientScope", authorizer.ErrInsufficientScope, authorizer.RejectionPolicyDenied),
+		Entry("ErrInsufficientAuthentication", authorizer.ErrInsufficientAuthentication, authorizer.RejectionPolicyDenied),
+		Entry("ErrActorNotAllowed", authorizer.ErrActorNotAllowed, authorizer.RejectionPolicyDenied),
+		Entry("ErrDeniedByDefault", authorizer.ErrDeniedByDefault, authorizer.RejectionPolicyDenied),
+
+		Entry("ErrNoCredentials", authorizer.ErrNoCredentials, authorizer.RejectionNoCredentials),
+		Entry("ErrMissingAuthorizationHeader", authorizer.ErrMissingAuthorizationHeader, authorizer.RejectionNoCredentials),
+		Entry("ErrMissingSessionCookie", authorizer.ErrMissingSessionCookie, authorizer.RejectionNoCredentials),
+		Entry("ErrApiKeyRequired", authorizer.ErrApiKeyRequired, authorizer.RejectionNoCredentials),
+		Entry("ErrMissingUpstreamClaims", authorizer.ErrMissingUpstreamClaims, authorizer.RejectionNoCredentials),
+
+		Entry("ErrCredentialsRejected", authorizer.ErrCredentialsRejected, authorizer.RejectionOther),
+		Entry("ErrInvalidConfiguration", authorizer.ErrInvalidConfiguration, authorizer.RejectionOther),
+		Entry("ErrClaimMappingFailed", authorizer.ErrClaimMappingFailed, authorizer.RejectionOther),
+		Entry("ErrKeySetUnavailable", authorizer.ErrKeySetUnavailable, authorizer.RejectionOther),
+		Entry("ErrKeyFetchThrottled", authorizer.ErrKeyFetchThrottled, authorizer.RejectionOther),
+		Entry("ErrNoKeysFound", authorizer.ErrNoKeysFound, authorizer.RejectionOther),
+		Entry("ErrNoKeySetURI", authorizer.ErrNoKeySetURI, authorizer.RejectionOther),
+		Entry("ErrNoUsableKeys", authorizer.ErrNoUsableKeys, authorizer.RejectionOther),
+		Entry("ErrNoTargetSet", authorizer.ErrNoTargetSet, authorizer.RejectionOther),
+		Entry("ErrNoAudienceSet", authorizer.ErrNoAudienceSet, authorizer.RejectionOther),
+		Entry("ErrDeprecatedAudience", authorizer.ErrDeprecatedAudience, authorizer.RejectionOther),
+		Entry("ErrNoAuthorizerSet", authorizer.ErrNoAuthorizerSet, authorizer.RejectionOther),
+		Entry("ErrEmptyCredential", authorizer.ErrEmptyCredential, authorizer.RejectionOther),
+		Entry("ErrEmptyClaimKey", authorizer.ErrEmptyClaimKey, authorizer.RejectionOther),
+		Entry("ErrClaimMappingCollision", authorizer.ErrClaimMappingCollision, authorizer.RejectionOther),
+		Entry("ErrUnsupportedHeader", authorizer.ErrUnsupportedHeader, authorizer.RejectionOther),
+		Entry("ErrInvalidClaimPattern", authorizer.ErrInvalidClaimPattern, authorizer.RejectionOther),
+		Entry("ErrInvalidApiKeyDigest", authorizer.ErrInvalidApiKeyDigest, authorizer.RejectionOther),
+		Entry("ErrInvalidPasswordHash", authorizer.ErrInvalidPasswordHash, authorizer.RejectionOther),
+		Entry("ErrContradictoryConfiguration", authorizer.ErrContradictoryConfiguration, authorizer.RejectionOther),
+		Entry("ErrRedactedSecret", authorizer.ErrRedactedSecret, authorizer.RejectionOther),
+		Entry("ErrMissingSecret", authorizer.ErrMissingSecret, authorizer.RejectionOther),
+		Entry("ErrIncompletePolicy", authorizer.ErrIncompletePolicy, authorizer.RejectionOther),
+		Entry("ErrInvalidPolicyDuration", authorizer.ErrInvalidPolicyDuration, authorizer.RejectionOther),
+		Entry("ErrCacheMiss", authorizer.ErrCacheMiss, authorizer.RejectionOther),
+		Entry("an unknown error", errors.New("nope"), authorizer.RejectionOther),
+		Entry("no error", nil, authorizer.RejectionOther),
+	)
+
+	DescribeTable("classifies rejections in audit events",
+		func(auth authorizerFunc, class authorizer.RejectionClass) {
+			var event authorizer.AuditEvent
+
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(auth),
+				authorizer.WithAuthorizedSubjects("some-user"),
+				authorizer.WithAuditHook(func(e authorizer.AuditEvent) {
+					event = e
+				}),
+			)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+
+			Expect(event.Allowed).To(BeFalse())
+			Expect(event.Class).To(Equal(class))
+		},
+		Entry("by the cause",
+			authorizerFunc(func(*http.Request) error { return authorizer.ErrInvalidSignature }),
+			authorizer.RejectionInvalidSignature,
+		),
+		Entry("by the reason",
+			authorizerFunc(authorizeWithClaims(map[string]interface{}{"sub": "other-user"})),
+			authorizer.RejectionPolicyDenied,
+		),
+		Entry("missing credentials",
+			authorizerFunc(func(*http.Request) error { return authorizer.ErrMissingAuthorizationHeader }),
+			authorizer.RejectionNoCredentials,
+		),
+	)
+})
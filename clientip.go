@@ -0,0 +1,55 @@
+package authorizer
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP derives the client address of r. With trustProxies set to zero it
+// is the address of the peer, r.RemoteAddr. Otherwise the peer and the last
+// trustProxies-1 X-Forwarded-For entries are taken to be trusted proxies, and
+// the entry before them is the client. Entries left of that could have been
+// written by the client and are ignored. The result is not valid when the
+// chosen address cannot be parsed.
+func ClientIP(r *http.Request, trustProxies int) netip.Addr {
+
+	hops := []string{r.RemoteAddr}
+
+	if trustProxies > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(header, ",")...)
+		}
+		hops = append(forwarded, hops...)
+	}
+
+	i := len(hops) - 1 - trustProxies
+	if i < 0 {
+		i = 0
+	}
+
+	return parseClientIP(hops[i])
+}
+
+// parseClientIP accepts an address with or without a port, and IPv6
+// addresses with or without brackets.
+func parseClientIP(value string) netip.Addr {
+
+	value = strings.TrimSpace(value)
+
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
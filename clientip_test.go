@@ -0,0 +1,79 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("ClientIP", func() {
+
+	request := func(remoteAddr string, forwardedFor ...string) *http.Request {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.RemoteAddr = remoteAddr
+		for _, header := range forwardedFor {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		return req
+	}
+
+	DescribeTable("derives the client address",
+		func(req *http.Request, trustProxies int, expected string) {
+			Expect(authorizer.ClientIP(req, trustProxies)).To(Equal(netip.MustParseAddr(expected)))
+		},
+		Entry("IPv4 with a port", request("192.0.2.1:1234"), 0, "192.0.2.1"),
+		Entry("IPv4 without a port", request("192.0.2.1"), 0, "192.0.2.1"),
+		Entry("IPv6 with a port", request("[2001:db8::1]:1234"), 0, "2001:db8::1"),
+		Entry("IPv6 in brackets", request("[2001:db8::1]"), 0, "2001:db8::1"),
+		Entry("IPv6 without brackets", request("2001:db8::1"), 0, "2001:db8::1"),
+		Entry("IPv4 mapped IPv6", request("[::ffff:192.0.2.1]:1234"), 0, "192.0.2.1"),
+		Entry("the peer when no proxy is trusted", request("192.0.2.1:1234", "198.51.100.1"), 0, "192.0.2.1"),
+		Entry("the forwarded address behind one proxy", request("192.0.2.1:1234", "198.51.100.1"), 1, "198.51.100.1"),
+		Entry("the last entry behind one proxy", request("192.0.2.1:1234", "203.0.113.9, 198.51.100.1"), 1, "198.51.100.1"),
+		Entry("an earlier entry behind two proxies", request("192.0.2.1:1234", "203.0.113.9, 198.51.100.1"), 2, "203.0.113.9"),
+		Entry("entries across repeated headers", request("192.0.2.1:1234", "203.0.113.9", "198.51.100.1"), 2, "203.0.113.9"),
+		Entry("forwarded IPv6 with a port", request("192.0.2.1:1234", "[2001:db8::2]:443"), 1, "2001:db8::2"),
+		Entry("the leftmost entry when every hop is trusted", request("192.0.2.1:1234", "198.51.100.1"), 5, "198.51.100.1"),
+	)
+
+	DescribeTable("ignores addresses the client can spoof",
+		func(req *http.Request, trustProxies int, expected string) {
+			Expect(authorizer.ClientIP(req, trustProxies)).To(Equal(netip.MustParseAddr(expected)))
+		},
+		Entry("without trusted proxies", request("192.0.2.1:1234", "10.0.0.1"), 0, "192.0.2.1"),
+		Entry("prepended before the trusted proxy", request("192.0.2.1:1234", "10.0.0.1, 198.51.100.1"), 1, "198.51.100.1"),
+	)
+
+	DescribeTable("reports malformed addresses as invalid",
+		func(req *http.Request, trustProxies int) {
+			Expect(authorizer.ClientIP(req, trustProxies).IsValid()).To(BeFalse())
+		},
+		Entry("an empty remote address", request(""), 0),
+		Entry("a host name", request("localhost:1234"), 0),
+		Entry("an unclosed bracket", request("[2001:db8::1"), 0),
+		Entry("a bad port", request("192.0.2.1:port"), 0),
+		Entry("a malformed forwarded entry", request("192.0.2.1:1234", "not-an-ip"), 1),
+		Entry("an empty forwarded entry", request("192.0.2.1:1234", "198.51.100.1,"), 1),
+	)
+
+	It("is reported in audit events", func() {
+		var event authorizer.AuditEvent
+
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithTrustedProxies(1),
+			authorizer.WithAuditHook(func(e authorizer.AuditEvent) {
+				event = e
+			}),
+		)
+		h.ServeHTTP(httptest.NewRecorder(), request("192.0.2.1:1234", "10.0.0.1, 198.51.100.1"))
+
+		Expect(event.RemoteAddr).To(Equal("192.0.2.1:1234"))
+		Expect(event.ClientIP).To(Equal(netip.MustParseAddr("198.51.100.1")))
+	})
+})
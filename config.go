@@ -0,0 +1,92 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEmptyCredential       = errors.New("empty credential")
+	ErrClaimMappingCollision = errors.New("claim mapping collision")
+)
+
+// ConfigError is implemented by every error Validate reports, and names the
+// option that could not be applied.
+type ConfigError interface {
+	error
+	Option() string
+}
+
+type optionError struct {
+	option string
+	err    error
+}
+
+func (e *optionError) Error() string {
+	return e.option + ": " + e.err.Error()
+}
+
+func (e *optionError) Unwrap() error {
+	return e.err
+}
+
+func (e *optionError) Option() string {
+	return e.option
+}
+
+func (h *handler) configError(option string, err error) {
+	h.ConfigErrors = append(h.ConfigErrors, &optionError{option, err})
+}
+
+// mapContextKey maps the claim from to the context key to, unless something
+// else is already mapped there.
+func (h *handler) mapContextKey(option, from, to string) bool {
+	if existing, ok := h.ClaimMapping[to]; ok && existing != from {
+		h.configError(option, fmt.Errorf("%w: claims %q and %q both map to %q", ErrClaimMappingCollision, existing, from, to))
+		return false
+	}
+	if existing, ok := h.HeaderMapping[to]; ok {
+		h.configError(option, fmt.Errorf("%w: header %q and claim %q both map to %q", ErrClaimMappingCollision, existing, from, to))
+		return false
+	}
+	h.ClaimMapping[to] = from
+	return true
+}
+
+// FormatConfigErrors renders the errors joined in err, such as those returned
+// by Validate, as a bulleted list for startup logs.
+func FormatConfigErrors(err error) string {
+
+	var b strings.Builder
+	for _, e := range flattenErrors(err) {
+		b.WriteString("  - ")
+		b.WriteString(e.Error())
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func flattenErrors(err error) []error {
+
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(ConfigError); ok {
+		return []error{err}
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(e)...)
+	}
+
+	return errs
+}
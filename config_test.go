@@ -0,0 +1,69 @@
+package authorizer_test
+
+import (
+	"errors"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Configuration errors", func() {
+
+	var err error
+
+	BeforeEach(func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithBasicAuthCredential("user", ""),
+			authorizer.WithApiKeys("key", ""),
+			authorizer.IncludeClaimInContextAs("sub", "user"),
+			authorizer.IncludeClaimInContextAs("email", "user"),
+			authorizer.IncludeHeaderInContextAs("x5c", "chain"),
+			authorizer.WithClaimExpression("claims.role =="),
+			authorizer.StrictConfiguration(),
+		)
+
+		err = h.Validate()
+	})
+
+	It("reports every failure", func() {
+		Expect(err).To(MatchError(authorizer.ErrEmptyCredential))
+		Expect(err).To(MatchError(authorizer.ErrClaimMappingCollision))
+		Expect(err).To(MatchError(authorizer.ErrUnsupportedHeader))
+		Expect(err).To(MatchError(authorizer.ErrContradictoryConfiguration))
+		Expect(err).To(MatchError(ContainSubstring("position 14")))
+	})
+
+	It("names the offending option of each failure", func() {
+		var options []string
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			var configErr authorizer.ConfigError
+			Expect(errors.As(e, &configErr)).To(BeTrue())
+			options = append(options, configErr.Option())
+		}
+
+		Expect(options).To(Equal([]string{
+			"WithBasicAuthCredential",
+			"WithApiKeys",
+			"IncludeClaimInContextAs",
+			"IncludeHeaderInContextAs",
+			"WithClaimExpression",
+			"StrictConfiguration",
+		}))
+	})
+
+	It("renders them as a list", func() {
+		Expect(authorizer.FormatConfigErrors(err)).To(HavePrefix(
+			"  - WithBasicAuthCredential: empty credential\n" +
+				"  - WithApiKeys: empty credential\n" +
+				`  - IncludeClaimInContextAs: claim mapping collision: claims "sub" and "email" both map to "user"` + "\n" +
+				`  - IncludeHeaderInContextAs: unsupported header: "x5c"` + "\n",
+		))
+	})
+
+	It("renders nothing without errors", func() {
+		Expect(authorizer.FormatConfigErrors(nil)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,110 @@
+package authorizer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// contextValues collects the values updateContext adds to a request so that
+// they are stored in one context node instead of one per key. Later values
+// shadow earlier ones with the same key, as with nested context.WithValue.
+type contextValues []contextValue
+
+type contextValue struct {
+	key, value interface{}
+}
+
+func (v *contextValues) set(key, value interface{}) {
+	*v = append(*v, contextValue{key, value})
+}
+
+func (v contextValues) context(parent context.Context) context.Context {
+	if len(v) == 0 {
+		return parent
+	}
+	outer, _ := parent.Value(valuesContextKey{}).(*valuesContext)
+	return &valuesContext{Context: parent, values: v, outer: outer}
+}
+
+// withValue is context.WithValue for values owned by the authorizer, which
+// must all be stored this way so that Detach finds them.
+func withValue(parent context.Context, key, value interface{}) context.Context {
+	c := &valuesContext{Context: parent}
+	c.outer, _ = parent.Value(valuesContextKey{}).(*valuesContext)
+	c.single[0] = contextValue{key, value}
+	c.values = c.single[:]
+	return c
+}
+
+// Detach returns a context holding every value the authorizer stored in ctx,
+// such as claims, mechanism and token, without its deadline, cancellation or
+// any other values. Pass it to goroutines that outlive the request, such as
+// those serving a hijacked connection.
+func Detach(ctx context.Context) context.Context {
+
+	var nodes []*valuesContext
+	for c, _ := ctx.Value(valuesContextKey{}).(*valuesContext); c != nil; c = c.outer {
+		nodes = append(nodes, c)
+	}
+
+	var values contextValues
+	for i := len(nodes) - 1; i >= 0; i-- {
+		values = append(values, nodes[i].values...)
+	}
+
+	return values.context(context.Background())
+}
+
+type valuesContextKey struct{}
+
+// valuesContext links to the enclosing valuesContext, if any, so that Detach
+// can collect the authorizer's values without those of other middleware.
+type valuesContext struct {
+	context.Context
+	values contextValues
+	outer  *valuesContext
+
+	// single holds the value set by withValue, saving an allocation.
+	single [1]contextValue
+}
+
+func (c *valuesContext) Value(key interface{}) interface{} {
+	if _, ok := key.(valuesContextKey); ok {
+		return c
+	}
+	for i := len(c.values) - 1; i >= 0; i-- {
+		if c.values[i].key == key {
+			return c.values[i].value
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// String mirrors the standard value context, printing only values that are
+// strings or Stringers.
+func (c *valuesContext) String() string {
+	var b strings.Builder
+	fmt.Fprint(&b, c.Context)
+	b.WriteString(".WithValues(")
+	for i, v := range c.values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%v, %s", v.key, stringify(v.value))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func stringify(v interface{}) string {
+	switch s := v.(type) {
+	case fmt.Stringer:
+		return s.String()
+	case string:
+		return s
+	case nil:
+		return "<nil>"
+	}
+	return "<not Stringer>"
+}
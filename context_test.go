@@ -0,0 +1,94 @@
+package authorizer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Detach", func() {
+
+	type foreignKey struct{}
+
+	var (
+		requestCtx context.Context
+		detached   context.Context
+	)
+
+	BeforeEach(func() {
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), foreignKey{}, "foreign"))
+
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestCtx = r.Context()
+				detached = authorizer.Detach(r.Context())
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"sub":   "subject",
+				"email": "user@example.com",
+				"act":   map[string]interface{}{"sub": "actor"},
+			}))),
+			authorizer.IncludeClaimInContextAs("email", "user"),
+			authorizer.IncludeTokenInContext(),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer token")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		cancel()
+	})
+
+	It("is not cancelled with the request", func() {
+		Expect(requestCtx.Err()).To(MatchError(context.Canceled))
+		Expect(detached.Err()).NotTo(HaveOccurred())
+		Expect(detached.Done()).To(BeNil())
+	})
+
+	It("keeps the values stored by the handler", func() {
+		subject, ok := authorizer.Subject(detached)
+		Expect(ok).To(BeTrue())
+		Expect(subject).To(Equal("subject"))
+
+		user, ok := authorizer.Claim(detached, "user")
+		Expect(ok).To(BeTrue())
+		Expect(user).To(Equal("user@example.com"))
+
+		token, ok := authorizer.TokenFromContext(detached)
+		Expect(ok).To(BeTrue())
+		Expect(token).To(Equal("token"))
+
+		Expect(authorizer.MechanismFromContext(detached)).To(Equal(authorizer.MechanismBearerToken))
+		Expect(authorizer.ActorFromContext(detached)).To(Equal([]string{"actor"}))
+	})
+
+	It("leaves out values stored by others", func() {
+		Expect(requestCtx.Value(foreignKey{})).To(Equal("foreign"))
+		Expect(detached.Value(foreignKey{})).To(BeNil())
+	})
+
+	It("keeps api key values", func() {
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				detached = authorizer.Detach(r.Context())
+			}),
+			authorizer.WithLabeledApiKey("ci", "secret"),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(authorizer.MechanismFromContext(detached)).To(Equal(authorizer.MechanismApiKey))
+		Expect(authorizer.ApiKeyLabelFromContext(detached)).To(Equal("ci"))
+	})
+
+	It("is empty without authorizer values", func() {
+		Expect(authorizer.Detach(context.Background()).Value(authorizer.ContextKey("sub"))).To(BeNil())
+	})
+})
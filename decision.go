@@ -0,0 +1,66 @@
+package authorizer
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+)
+
+type decisionHooks struct {
+	onAllow func(*http.Request, string, map[string]any)
+	onDeny  func(*http.Request, error)
+}
+
+// WithDecisionHooks calls onAllow, before the next handler, for every request
+// that is forwarded, with how access was granted and a copy of the claims, and
+// onDeny for every rejected request, with the rejection. via is one of
+// "api_key", "basic_auth", "static_token", "claims" for tokens and upstream
+// claims, or "open" for anonymous requests, excluded paths and preflight
+// requests. Either hook may be nil. The hooks get a clone of the request, and
+// panics in them are logged, so neither can change the decision.
+func WithDecisionHooks(onAllow func(r *http.Request, via string, claims map[string]any), onDeny func(r *http.Request, reason error)) handlerOpt {
+	return func(h *handler) {
+		h.DecisionHooks = append(h.DecisionHooks, decisionHooks{onAllow, onDeny})
+	}
+}
+
+func (h *handler) decideAllow(r *http.Request, mechanism string) {
+	for _, hooks := range h.DecisionHooks {
+		if hooks.onAllow != nil {
+			h.callDecisionHook(r, func() {
+				hooks.onAllow(r.Clone(r.Context()), decisionVia(mechanism), maps.Clone(ClaimsFromContext(r.Context())))
+			})
+		}
+	}
+}
+
+func (h *handler) decideDeny(r *http.Request, err error) {
+	for _, hooks := range h.DecisionHooks {
+		if hooks.onDeny != nil {
+			h.callDecisionHook(r, func() {
+				hooks.onDeny(r.Clone(r.Context()), err)
+			})
+		}
+	}
+}
+
+func decisionVia(mechanism string) string {
+	switch mechanism {
+	case MechanismApiKey, MechanismBasicAuth, MechanismStaticToken:
+		return mechanism
+	case MechanismBearerToken, MechanismUpstreamClaims:
+		return "claims"
+	}
+	return "open"
+}
+
+func (h *handler) callDecisionHook(r *http.Request, fn func()) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			h.logError(r, fmt.Errorf("decision hook panicked: %v", p))
+		}
+	}()
+
+	fn()
+}
@@ -0,0 +1,144 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithDecisionHooks", func() {
+
+	var (
+		calls  []string
+		via    string
+		claims map[string]any
+		reason error
+		rec    *httptest.ResponseRecorder
+	)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "next")
+		Expect(authorizer.ClaimsFromContext(r.Context())).NotTo(HaveKey("added"))
+	})
+
+	onAllow := func(r *http.Request, mechanism string, c map[string]any) {
+		calls = append(calls, "allow")
+		via, claims = mechanism, c
+		if c != nil {
+			c["added"] = true
+		}
+	}
+
+	onDeny := func(r *http.Request, err error) {
+		calls = append(calls, "deny")
+		reason = err
+	}
+
+	BeforeEach(func() {
+		calls, via, claims, reason = nil, "", nil, nil
+		rec = httptest.NewRecorder()
+	})
+
+	It("reports allowed requests before the next handler", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{"sub": "subject"}))),
+			authorizer.WithDecisionHooks(onAllow, onDeny),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(calls).To(Equal([]string{"allow", "next"}))
+		Expect(via).To(Equal("claims"))
+		Expect(claims).To(HaveKeyWithValue("sub", "subject"))
+	})
+
+	It("reports the api key mechanism", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithApiKeys("secret"),
+			authorizer.WithDecisionHooks(onAllow, onDeny),
+		)
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		h.ServeHTTP(rec, req)
+
+		Expect(calls).To(Equal([]string{"allow", "next"}))
+		Expect(via).To(Equal("api_key"))
+	})
+
+	It("reports excluded paths", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithApiKeys("secret"),
+			authorizer.WithExcludedPaths("/health"),
+			authorizer.WithDecisionHooks(onAllow, onDeny),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost/health", nil))
+
+		Expect(calls).To(Equal([]string{"allow", "next"}))
+		Expect(via).To(Equal("open"))
+	})
+
+	It("reports static credentials", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithBasicAuthCredential("user", "pass"),
+			authorizer.WithAuthorizedTokens("some-token"),
+			authorizer.WithDecisionHooks(onAllow, onDeny),
+		)
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.SetBasicAuth("user", "pass")
+		h.ServeHTTP(rec, req)
+
+		Expect(via).To(Equal("basic_auth"))
+
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer some-token")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(via).To(Equal("static_token"))
+	})
+
+	It("does not let a hook change the request", func() {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "next")
+			Expect(r.Header.Get("X-Added")).To(BeEmpty())
+			Expect(r.URL.Path).To(Equal("/"))
+		}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{}))),
+			authorizer.WithDecisionHooks(func(r *http.Request, _ string, _ map[string]any) {
+				r.Header.Set("X-Added", "true")
+				r.URL.Path = "/admin"
+			}, nil),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal([]string{"next"}))
+	})
+
+	It("reports rejected requests", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithApiKeys("secret"),
+			authorizer.WithDecisionHooks(onAllow, onDeny),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(calls).To(Equal([]string{"deny"}))
+		Expect(reason).To(MatchError(authorizer.ErrApiKeyRequired))
+	})
+
+	It("does not let a panicking hook change the decision", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{}))),
+			authorizer.WithDecisionHooks(func(*http.Request, string, map[string]any) {
+				panic("boom")
+			}, nil),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal([]string{"next"}))
+	})
+})
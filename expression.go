@@ -0,0 +1,515 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseClaimExpression compiles a boolean expression over the token's claims,
+// for example:
+//
+//	claims.org_id == "acme" && ("admin" in claims.roles || claims.plan == "enterprise")
+//
+// Operands are claims.<path> (or claims["key"] for keys that are not plain
+// identifiers), string and number literals, true, false and null. Supported
+// operators are ==, !=, in (membership in a list claim), !, && and ||, with
+// parentheses for grouping.
+func ParseClaimExpression(expr string) (*ClaimExpression, error) {
+
+	tokens, err := lexExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{source: expr, tokens: tokens}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+
+	return &ClaimExpression{expr, node}, nil
+}
+
+type ClaimExpression struct {
+	Source string
+	node   expressionNode
+}
+
+func (e *ClaimExpression) Evaluate(claims map[string]interface{}) bool {
+	return truthy(e.node.eval(claims))
+}
+
+func (e *ClaimExpression) String() string {
+	return e.Source
+}
+
+// claimNames lists the top-level claims the expression refers to.
+func (e *ClaimExpression) claimNames() []string {
+
+	var names []string
+
+	var walk func(node expressionNode)
+	walk = func(node expressionNode) {
+		switch n := node.(type) {
+		case pathNode:
+			names = append(names, n.path[0])
+		case equalsNode:
+			walk(n.left)
+			walk(n.right)
+		case inNode:
+			walk(n.left)
+			walk(n.right)
+		case notNode:
+			walk(n.operand)
+		case andNode:
+			walk(n.left)
+			walk(n.right)
+		case orNode:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+
+	walk(e.node)
+	return names
+}
+
+type ExpressionSyntaxError struct {
+	Expression string
+	Position   int
+	Message    string
+}
+
+func (e *ExpressionSyntaxError) Error() string {
+	return fmt.Sprintf("claim expression %q: position %d: %s", e.Expression, e.Position, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lexExpression(expr string) ([]token, error) {
+
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expr) && rune(expr[end]) != c {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, &ExpressionSyntaxError{expr, i, "unterminated string"}
+			}
+
+			literal := expr[i : end+1]
+			if c == '\'' {
+				inner := strings.ReplaceAll(literal[1:len(literal)-1], `\'`, `'`)
+				literal = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+			}
+
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				return nil, &ExpressionSyntaxError{expr, i, "invalid string literal"}
+			}
+
+			tokens = append(tokens, token{tokenString, expr[i : end+1], value, i})
+			i = end + 1
+
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(expr) && unicode.IsDigit(rune(expr[i+1]))):
+			end := i + 1
+			for end < len(expr) && (unicode.IsDigit(rune(expr[end])) || expr[end] == '.') {
+				end++
+			}
+
+			value, err := strconv.ParseFloat(expr[i:end], 64)
+			if err != nil {
+				return nil, &ExpressionSyntaxError{expr, i, "invalid number " + strconv.Quote(expr[i:end])}
+			}
+
+			tokens = append(tokens, token{tokenNumber, expr[i:end], value, i})
+			i = end
+
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(expr) && (unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end])) || expr[end] == '_') {
+				end++
+			}
+
+			tokens = append(tokens, token{tokenIdent, expr[i:end], nil, i})
+			i = end
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "&&", "||", "!", "(", ")", ".", "[", "]"} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+
+			if op == "" {
+				return nil, &ExpressionSyntaxError{expr, i, "unexpected character " + strconv.QuoteRune(c)}
+			}
+
+			tokens = append(tokens, token{tokenOperator, op, nil, i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+type expressionParser struct {
+	source string
+	tokens []token
+	pos    int
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *expressionParser) accept(kind tokenKind, text string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) errorf(tok token, format string, args ...interface{}) error {
+	return &ExpressionSyntaxError{p.source, tok.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *expressionParser) parseOr() (expressionNode, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(tokenOperator, "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expressionNode, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(tokenOperator, "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+
+	if p.accept(tokenOperator, "!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (expressionNode, error) {
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := p.peek(); {
+	case tok.kind == tokenOperator && (tok.text == "==" || tok.text == "!="):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return equalsNode{left, right, tok.text == "!="}, nil
+
+	case tok.kind == tokenIdent && tok.text == "in":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{left, right}, nil
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseOperand() (expressionNode, error) {
+
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenString, tokenNumber:
+		return literalNode{tok.value}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		case "claims":
+			return p.parsePath(tok)
+		}
+		return nil, p.errorf(tok, "unknown identifier %s, claims must be referenced as claims.<name>", tok)
+
+	case tokenOperator:
+		if tok.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.peek(); !p.accept(tokenOperator, ")") {
+				return nil, p.errorf(closing, "expected \")\" to close \"(\" at position %d, found %s", tok.pos, closing)
+			}
+			return node, nil
+		}
+	}
+
+	return nil, p.errorf(tok, "expected an operand, found %s", tok)
+}
+
+func (p *expressionParser) parsePath(start token) (expressionNode, error) {
+
+	var path []string
+
+	for {
+		switch {
+		case p.accept(tokenOperator, "."):
+			tok := p.next()
+			if tok.kind != tokenIdent {
+				return nil, p.errorf(tok, "expected a claim name after \".\", found %s", tok)
+			}
+			path = append(path, tok.text)
+
+		case p.accept(tokenOperator, "["):
+			tok := p.next()
+			if tok.kind != tokenString {
+				return nil, p.errorf(tok, "expected a quoted claim name after \"[\", found %s", tok)
+			}
+			if closing := p.peek(); !p.accept(tokenOperator, "]") {
+				return nil, p.errorf(closing, "expected \"]\", found %s", closing)
+			}
+			path = append(path, tok.value.(string))
+
+		default:
+			if len(path) == 0 {
+				return nil, p.errorf(start, "expected a claim name after claims")
+			}
+			return pathNode{path}, nil
+		}
+	}
+}
+
+type expressionNode interface {
+	eval(claims map[string]interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) interface{} {
+	return n.value
+}
+
+type pathNode struct {
+	path []string
+}
+
+func (n pathNode) eval(claims map[string]interface{}) interface{} {
+
+	var value interface{} = claims
+
+	for _, key := range n.path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	return value
+}
+
+type equalsNode struct {
+	left, right expressionNode
+	negate      bool
+}
+
+func (n equalsNode) eval(claims map[string]interface{}) interface{} {
+	return expressionEquals(n.left.eval(claims), n.right.eval(claims)) != n.negate
+}
+
+type inNode struct {
+	left, right expressionNode
+}
+
+func (n inNode) eval(claims map[string]interface{}) interface{} {
+
+	needle := n.left.eval(claims)
+
+	switch haystack := n.right.eval(claims).(type) {
+	case []interface{}:
+		for _, item := range haystack {
+			if expressionEquals(needle, item) {
+				return true
+			}
+		}
+	case []string:
+		for _, item := range haystack {
+			if expressionEquals(needle, item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+func (n notNode) eval(claims map[string]interface{}) interface{} {
+	return !truthy(n.operand.eval(claims))
+}
+
+type andNode struct {
+	left, right expressionNode
+}
+
+func (n andNode) eval(claims map[string]interface{}) interface{} {
+	return truthy(n.left.eval(claims)) && truthy(n.right.eval(claims))
+}
+
+type orNode struct {
+	left, right expressionNode
+}
+
+func (n orNode) eval(claims map[string]interface{}) interface{} {
+	return truthy(n.left.eval(claims)) || truthy(n.right.eval(claims))
+}
+
+func truthy(value interface{}) bool {
+	b, ok := value.(bool)
+	return ok && b
+}
+
+func expressionEquals(a, b interface{}) bool {
+
+	if x, ok := expressionNumber(a); ok {
+		y, ok := expressionNumber(b)
+		return ok && x == y
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		s, ok := b.(string)
+		return ok && a == s
+	case bool:
+		v, ok := b.(bool)
+		return ok && a == v
+	}
+
+	return false
+}
+
+func expressionNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,129 @@
+package authorizer_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("ClaimExpression", func() {
+
+	var claims map[string]interface{}
+
+	BeforeEach(func() {
+		claims = map[string]interface{}{
+			"sub":      "some-user",
+			"org_id":   "acme",
+			"plan":     "free",
+			"roles":    []interface{}{"reader", "admin"},
+			"groups":   []string{"eng"},
+			"level":    float64(3),
+			"verified": true,
+			"metadata": map[string]interface{}{
+				"region": "eu",
+			},
+			"https://example.com/tenant": "t1",
+		}
+	})
+
+	DescribeTable("Evaluate",
+		func(expr string, expected bool) {
+			compiled, err := authorizer.ParseClaimExpression(expr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compiled.Evaluate(claims)).To(Equal(expected))
+		},
+
+		Entry("string equality", `claims.org_id == "acme"`, true),
+		Entry("string inequality", `claims.org_id == "other"`, false),
+		Entry("not equals", `claims.org_id != "other"`, true),
+		Entry("single quoted strings", `claims.org_id == 'acme'`, true),
+		Entry("escaped quotes", `claims.sub != "some \"user\""`, true),
+		Entry("number equality", `claims.level == 3`, true),
+		Entry("number inequality", `claims.level == 4`, false),
+		Entry("negative numbers", `claims.level != -3`, true),
+		Entry("decimal numbers", `claims.level == 3.0`, true),
+		Entry("boolean equality", `claims.verified == true`, true),
+		Entry("bare boolean claim", `claims.verified`, true),
+		Entry("bare non-boolean claim", `claims.sub`, false),
+		Entry("missing claim equals null", `claims.missing == null`, true),
+		Entry("missing claim compared to a string", `claims.missing == "x"`, false),
+		Entry("type mismatch", `claims.level == "3"`, false),
+		Entry("membership", `"admin" in claims.roles`, true),
+		Entry("non-membership", `"owner" in claims.roles`, false),
+		Entry("membership in string list", `"eng" in claims.groups`, true),
+		Entry("membership in a non-list", `"acme" in claims.org_id`, false),
+		Entry("membership in a missing claim", `"admin" in claims.missing`, false),
+		Entry("claim membership", `claims.plan in claims.roles`, false),
+		Entry("nested claims", `claims.metadata.region == "eu"`, true),
+		Entry("nested path through a non-object", `claims.sub.region == "eu"`, false),
+		Entry("bracketed claim names", `claims["https://example.com/tenant"] == "t1"`, true),
+		Entry("mixed paths", `claims["metadata"].region == "eu"`, true),
+		Entry("and", `claims.org_id == "acme" && claims.plan == "free"`, true),
+		Entry("and short circuit", `claims.org_id == "other" && claims.plan == "free"`, false),
+		Entry("or", `claims.org_id == "other" || claims.plan == "free"`, true),
+		Entry("or both false", `claims.org_id == "other" || claims.plan == "pro"`, false),
+		Entry("not", `!(claims.org_id == "other")`, true),
+		Entry("double not", `!!claims.verified`, true),
+		Entry("not on membership", `!("owner" in claims.roles)`, true),
+		Entry("and binds tighter than or", `claims.plan == "pro" && claims.org_id == "x" || claims.verified`, true),
+		Entry("parentheses override precedence", `claims.plan == "pro" && (claims.org_id == "x" || claims.verified)`, false),
+		Entry("the documented example", `claims.org_id == "acme" && ("admin" in claims.roles || claims.plan == "enterprise")`, true),
+		Entry("literal true", `true`, true),
+		Entry("literal false", `false`, false),
+		Entry("whitespace", "  claims.org_id\t==\n\"acme\"  ", true),
+	)
+
+	DescribeTable("Evaluate without claims",
+		func(expr string) {
+			compiled, err := authorizer.ParseClaimExpression(expr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compiled.Evaluate(nil)).To(BeFalse())
+		},
+
+		Entry("equality", `claims.org_id == "acme"`),
+		Entry("membership", `"admin" in claims.roles`),
+		Entry("nested claims", `claims.metadata.region == "eu"`),
+	)
+
+	DescribeTable("ParseClaimExpression syntax errors",
+		func(expr string, position int, message string) {
+			_, err := authorizer.ParseClaimExpression(expr)
+			Expect(err).To(HaveOccurred())
+
+			var syntaxErr *authorizer.ExpressionSyntaxError
+			Expect(errors.As(err, &syntaxErr)).To(BeTrue())
+			Expect(syntaxErr.Expression).To(Equal(expr))
+			Expect(syntaxErr.Position).To(Equal(position))
+			Expect(syntaxErr.Message).To(ContainSubstring(message))
+			Expect(err.Error()).To(ContainSubstring(message))
+		},
+
+		Entry("empty expression", ``, 0, "expected an operand"),
+		Entry("unterminated string", `claims.sub == "abc`, 14, "unterminated string"),
+		Entry("unexpected character", `claims.sub == #`, 14, "unexpected character"),
+		Entry("single equals", `claims.sub = "abc"`, 11, "unexpected character"),
+		Entry("unknown identifier", `token.sub == "abc"`, 0, "unknown identifier"),
+		Entry("bare claims", `claims == "abc"`, 0, "expected a claim name"),
+		Entry("missing claim name", `claims. == "abc"`, 8, "expected a claim name"),
+		Entry("unquoted bracket", `claims[sub] == "abc"`, 7, "expected a quoted claim name"),
+		Entry("unclosed bracket", `claims["sub" == "abc"`, 13, `expected "]"`),
+		Entry("unclosed parenthesis", `(claims.sub == "abc"`, 20, `expected ")"`),
+		Entry("missing right operand", `claims.sub ==`, 13, "expected an operand"),
+		Entry("dangling and", `claims.verified &&`, 18, "expected an operand"),
+		Entry("trailing tokens", `claims.verified claims.sub`, 16, "unexpected"),
+		Entry("stray closing parenthesis", `claims.verified)`, 15, "unexpected"),
+		Entry("invalid number", `claims.level == 1.2.3`, 16, "invalid number"),
+	)
+
+	Describe("String", func() {
+		It("returns the source expression", func() {
+			compiled, err := authorizer.ParseClaimExpression(`claims.verified`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compiled.String()).To(Equal(`claims.verified`))
+		})
+	})
+})
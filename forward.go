@@ -0,0 +1,72 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type forwardedClaim struct {
+	Claim, Header string
+}
+
+// ForwardClaimAsHeader sets header on authorized requests to the value of
+// claim, so that an upstream behind a reverse proxy can read it. Strings are
+// forwarded as is and other values JSON encoded; strings containing line
+// breaks are not forwarded. Any value the client sent for header is removed
+// first, on every forwarded request.
+func ForwardClaimAsHeader(claim, header string) handlerOpt {
+	return func(h *handler) {
+		if claim == "" {
+			h.configError("ForwardClaimAsHeader", ErrEmptyClaimKey)
+			return
+		}
+		if header == "" {
+			h.configError("ForwardClaimAsHeader", ErrUnsupportedHeader)
+			return
+		}
+		h.ForwardedClaims = append(h.ForwardedClaims, forwardedClaim{claim, http.CanonicalHeaderKey(header)})
+	}
+}
+
+func (h *handler) forwardClaims(r *http.Request) {
+
+	if len(h.ForwardedClaims) == 0 {
+		return
+	}
+
+	r.Header = r.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+
+	for _, forwarded := range h.ForwardedClaims {
+		r.Header.Del(forwarded.Header)
+	}
+
+	claims := ClaimsFromContext(r.Context())
+
+	for _, forwarded := range h.ForwardedClaims {
+		value, ok := lookupClaim(claims, forwarded.Claim)
+		if !ok || value == nil {
+			continue
+		}
+		if header, ok := headerValue(value); ok {
+			r.Header.Set(forwarded.Header, header)
+		}
+	}
+}
+
+func headerValue(value interface{}) (string, bool) {
+
+	if s, ok := value.(string); ok {
+		return s, !strings.ContainsAny(s, "\r\n\x00")
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
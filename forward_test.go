@@ -0,0 +1,93 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("ForwardClaimAsHeader", func() {
+
+	var (
+		forwarded http.Header
+		rec       *httptest.ResponseRecorder
+		req       *http.Request
+		h         http.Handler
+	)
+
+	BeforeEach(func() {
+		forwarded = nil
+		rec = httptest.NewRecorder()
+
+		req = httptest.NewRequest("GET", "http://localhost/api", nil)
+		req.Header.Set("X-User-Id", "spoofed-user")
+		req.Header.Add("X-User-Email", "spoofed@example.com")
+		req.Header.Add("X-User-Email", "other@example.com")
+		req.Header.Set("X-User-Roles", "admin")
+
+		h = authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"sub":   "some-user",
+				"email": "some-user@example.com",
+				"roles": []interface{}{"reader", "writer"},
+				"org":   map[string]interface{}{"id": float64(7)},
+				"name":  "some\r\nX-Injected: yes",
+			}))),
+			authorizer.WithExcludedPaths("/health"),
+			authorizer.ForwardClaimAsHeader("sub", "X-User-Id"),
+			authorizer.ForwardClaimAsHeader("email", "x-user-email"),
+			authorizer.ForwardClaimAsHeader("roles", "X-User-Roles"),
+			authorizer.ForwardClaimAsHeader("org.id", "X-Org-Id"),
+			authorizer.ForwardClaimAsHeader("name", "X-User-Name"),
+			authorizer.ForwardClaimAsHeader("missing", "X-Missing"),
+		)
+	})
+
+	It("replaces client values with the claims", func() {
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Values("X-User-Id")).To(Equal([]string{"some-user"}))
+		Expect(forwarded.Values("X-User-Email")).To(Equal([]string{"some-user@example.com"}))
+	})
+
+	It("JSON encodes non-string claims", func() {
+		h.ServeHTTP(rec, req)
+
+		Expect(forwarded.Get("X-User-Roles")).To(Equal(`["reader","writer"]`))
+		Expect(forwarded.Get("X-Org-Id")).To(Equal("7"))
+	})
+
+	It("does not forward missing claims or line breaks", func() {
+		h.ServeHTTP(rec, req)
+
+		Expect(forwarded).NotTo(HaveKey("X-Missing"))
+		Expect(forwarded).NotTo(HaveKey("X-User-Name"))
+		Expect(forwarded).NotTo(HaveKey("X-Injected"))
+	})
+
+	It("removes client values from excluded paths", func() {
+		req.URL.Path = "/health"
+		h.ServeHTTP(rec, req)
+
+		Expect(forwarded).NotTo(HaveKey("X-User-Id"))
+		Expect(forwarded).NotTo(HaveKey("X-User-Email"))
+	})
+
+	It("rejects an empty claim or header", func() {
+		Expect(authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.ForwardClaimAsHeader("", "X-User-Id"),
+		).Validate()).To(MatchError(authorizer.ErrEmptyClaimKey))
+
+		Expect(authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.ForwardClaimAsHeader("sub", ""),
+		).Validate()).To(MatchError(authorizer.ErrUnsupportedHeader))
+	})
+})
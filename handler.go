@@ -1,17 +1,50 @@
 package authorizer
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-type Logger interface {
-	Error(a ...interface{})
-}
+var (
+	ErrApiKeyRequired       = errors.New("api key required")
+	ErrApiKeyOutOfScope     = errors.New("api key out of scope")
+	ErrNoCredentials        = errors.New("no credentials")
+	ErrCredentialsRejected  = errors.New("credentials rejected")
+	ErrClaimsNotAuthorized  = errors.New("claims not authorized")
+	ErrInvalidConfiguration = errors.New("invalid configuration")
 
-type Authorizer interface {
-	Authorize(r *http.Request) error
-}
+	ErrNoAuthorizerSet       = errors.New("no authorizer set")
+	ErrDeniedByDefault       = errors.New("denied by default")
+	ErrMissingUpstreamClaims = errors.New("missing upstream claims")
+	ErrClaimsTooLarge        = errors.New("claims too large")
+	ErrEmptyClaimKey         = errors.New("empty claim key")
+	ErrUnsupportedHeader     = errors.New("unsupported header")
+	ErrInvalidClaimPattern   = errors.New("invalid claim pattern")
+	ErrDuplicateCredential   = errors.New("duplicate credential header")
+	ErrClaimMappingFailed    = errors.New("claim mapping failed")
+	ErrMissingMappedClaim    = errors.New("missing mapped claim")
+
+	ErrInsufficientAuthentication = errors.New("insufficient authentication")
+	ErrInvalidApiKeyDigest        = errors.New("invalid api key digest")
+	ErrInvalidPasswordHash        = errors.New("invalid password hash")
+)
 
 type handlerOpt func(h *handler)
 
@@ -23,21 +56,67 @@ func WithAuthorizer(authorizer Authorizer) handlerOpt {
 
 func WithBasicAuthCredential(user, pass string) handlerOpt {
 	return func(h *handler) {
-		h.BasicAuthCredentials = append(h.BasicAuthCredentials, BasicAuthCredential{user, pass})
+		if user == "" || pass == "" {
+			h.configError("WithBasicAuthCredential", ErrEmptyCredential)
+			return
+		}
+		h.BasicAuthCredentials = append(h.BasicAuthCredentials, BasicAuthCredential{Username: user, Password: pass})
+	}
+}
+
+// WithBasicAuthCredentialHash authorizes user with the password whose bcrypt
+// hash is given, so that the password itself need not be configured.
+func WithBasicAuthCredentialHash(user, bcryptHash string) handlerOpt {
+	return func(h *handler) {
+		if user == "" || bcryptHash == "" {
+			h.configError("WithBasicAuthCredentialHash", ErrEmptyCredential)
+			return
+		}
+		if _, err := bcrypt.Cost([]byte(bcryptHash)); err != nil {
+			h.configError("WithBasicAuthCredentialHash", fmt.Errorf("%w: not a bcrypt hash", ErrInvalidPasswordHash))
+			return
+		}
+		h.BasicAuthCredentials = append(h.BasicAuthCredentials, BasicAuthCredential{Username: user, PasswordHash: bcryptHash})
 	}
 }
 
 func WithAuthorizedTokens(values ...string) handlerOpt {
 	return func(h *handler) {
 		for _, value := range values {
+			if value == "" {
+				h.configError("WithAuthorizedTokens", ErrEmptyCredential)
+				continue
+			}
 			h.AuthorizedTokens = append(h.AuthorizedTokens, AuthorizedToken{value})
 		}
 	}
 }
 
-func WithAuthorizedClaim(key, value string) handlerOpt {
+// WithAuthorizedClaim authorizes requests whose claim key equals value.
+// Numbers match by value whatever type they were decoded as.
+func WithAuthorizedClaim(key string, value interface{}) handlerOpt {
+	return func(h *handler) {
+		h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: key, Value: value})
+	}
+}
+
+func WithNamedAuthorizedClaim(name, key string, value interface{}) handlerOpt {
+	return func(h *handler) {
+		h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: key, Value: value, Name: name})
+	}
+}
+
+// WithAuthorizedClaimPattern authorizes requests whose claim key is a string
+// matching the regular expression pattern. Claims of any other type never
+// match.
+func WithAuthorizedClaimPattern(key, pattern string) handlerOpt {
 	return func(h *handler) {
-		h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{key, value})
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			h.configError("WithAuthorizedClaimPattern", fmt.Errorf("%w: %v", ErrInvalidClaimPattern, err))
+			return
+		}
+		h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: key, Pattern: compiled})
 	}
 }
 
@@ -47,154 +126,1913 @@ func WithAuthorizedClaims(values ...AuthorizedClaim) handlerOpt {
 	}
 }
 
+// WithAuthorizedClaimsMap requires every claim in values to match. Unlike the
+// authorized claims list, where any one entry admits the request, required
+// claims are all mandatory; when both are configured a request must satisfy
+// every required claim and at least one authorized claim.
+func WithAuthorizedClaimsMap(values map[string]interface{}) handlerOpt {
+	return func(h *handler) {
+		for key, value := range values {
+			if key == "" {
+				h.configError("WithAuthorizedClaimsMap", ErrEmptyClaimKey)
+				continue
+			}
+			h.RequiredClaims = append(h.RequiredClaims, RequiredClaim{Key: key, Value: value})
+		}
+	}
+}
+
+// WithAuthorizedRole authorizes requests whose claimKey claim, an array of
+// roles or a single role, holds any of roles. claimKey may be a dot separated
+// path into nested claims, such as realm_access.roles.
+func WithAuthorizedRole(claimKey string, roles ...string) handlerOpt {
+	return func(h *handler) {
+		if claimKey == "" {
+			h.configError("WithAuthorizedRole", ErrEmptyClaimKey)
+			return
+		}
+		for _, role := range roles {
+			h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: claimKey, Value: role})
+		}
+	}
+}
+
+// WithRequiredClaims requires every one of claims to match, like
+// WithAuthorizedClaimsMap. Required claims are checked first; the authorized
+// claims, if any, must then also have a match.
+func WithRequiredClaims(claims ...AuthorizedClaim) handlerOpt {
+	return func(h *handler) {
+		for _, claim := range claims {
+			if claim.Key == "" {
+				h.configError("WithRequiredClaims", ErrEmptyClaimKey)
+				continue
+			}
+			h.RequiredClaims = append(h.RequiredClaims, RequiredClaim{Key: claim.Key, Value: claim.Value, Pattern: claim.Pattern})
+		}
+	}
+}
+
+// WithAuthorizedClaimValues requires the claim key to equal one of values.
+// Like WithAuthorizedClaimsMap it is mandatory, and numbers match whatever
+// type they were decoded as.
+func WithAuthorizedClaimValues(key string, values ...any) handlerOpt {
+	return func(h *handler) {
+		if key == "" {
+			h.configError("WithAuthorizedClaimValues", ErrEmptyClaimKey)
+			return
+		}
+		h.RequiredClaims = append(h.RequiredClaims, RequiredClaim{Key: key, Values: values})
+	}
+}
+
 func WithAuthorizedSubjects(values ...string) handlerOpt {
 	return func(h *handler) {
 		for _, value := range values {
-			h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{"sub", value})
+			h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: "sub", Value: value})
 		}
 	}
 }
 
-func WithApiKeys(values ...string) handlerOpt {
+// WithAuthorizedAudiences authorizes requests whose aud claim, a single
+// audience or an array of them, holds any of values.
+func WithAuthorizedAudiences(values ...string) handlerOpt {
 	return func(h *handler) {
 		for _, value := range values {
-			h.ApiKeys = append(h.ApiKeys, ApiKey{value})
+			h.AuthorizedClaims = append(h.AuthorizedClaims, AuthorizedClaim{Key: audKey, Value: value})
 		}
 	}
 }
 
-func NewHandler(
-	logger Logger,
-	next http.Handler,
-	opts ...handlerOpt,
-) *handler {
-	handler := &handler{
-		Logger:     logger,
-		Authorizer: NoopAuthorizer(),
-		Handler:    next,
+func WithApiKeys(values ...string) handlerOpt {
+	return func(h *handler) {
+		for _, value := range values {
+			if value == "" {
+				h.configError("WithApiKeys", ErrEmptyCredential)
+				continue
+			}
+			h.ApiKeys = append(h.ApiKeys, ApiKey{Value: value})
+		}
 	}
+}
 
-	for _, opt := range opts {
-		opt(handler)
+func WithScopedApiKey(value string, pathPrefixes ...string) handlerOpt {
+	return func(h *handler) {
+		if value == "" {
+			h.configError("WithScopedApiKey", ErrEmptyCredential)
+			return
+		}
+		h.ApiKeys = append(h.ApiKeys, ApiKey{Value: value, PathPrefixes: pathPrefixes})
 	}
-
-	return handler
 }
 
-type handler struct {
-	Logger
-	Authorizer
-	http.Handler
+// WithApiKeyClaims authorizes key and gives requests carrying it claims,
+// which are handled like token claims. If key is already configured, the
+// claims are attached to it.
+func WithApiKeyClaims(key string, claims map[string]any) handlerOpt {
+	return func(h *handler) {
+		if key == "" {
+			h.configError("WithApiKeyClaims", ErrEmptyCredential)
+			return
+		}
+		for i := range h.ApiKeys {
+			if h.ApiKeys[i].Value == key {
+				h.ApiKeys[i].Claims = claims
+				return
+			}
+		}
+		h.ApiKeys = append(h.ApiKeys, ApiKey{Value: key, Claims: claims})
+	}
+}
 
-	BasicAuthCredentials []BasicAuthCredential
-	AuthorizedTokens     []AuthorizedToken
-	AuthorizedClaims     []AuthorizedClaim
-	ApiKeys              []ApiKey
+// WithHashedApiKeys authorizes API keys whose SHA-256 digests, hex encoded,
+// are given, so that the keys themselves need not be configured.
+func WithHashedApiKeys(hexDigests ...string) handlerOpt {
+	return func(h *handler) {
+		for _, hexDigest := range hexDigests {
+			digest, err := hex.DecodeString(hexDigest)
+			if err == nil && len(digest) != sha256.Size {
+				err = fmt.Errorf("got %d bytes, want %d", len(digest), sha256.Size)
+			}
+			if err != nil {
+				h.configError("WithHashedApiKeys", fmt.Errorf("%w: %w", ErrInvalidApiKeyDigest, err))
+				continue
+			}
+			h.ApiKeys = append(h.ApiKeys, ApiKey{Digest: digest})
+		}
+	}
 }
 
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// WithApiKeyValidator consults validator for API keys that match none of the
+// configured keys. The claims it returns for a valid key are handled like
+// token claims, unless an authorizer also runs, in which case the token's
+// claims take their place.
+func WithApiKeyValidator(validator func(ctx context.Context, key string) (map[string]any, bool)) handlerOpt {
+	return func(h *handler) {
+		h.ApiKeyValidator = validator
+	}
+}
 
-	if len(h.ApiKeys) == 0 {
-		h.Serve(w, r)
-		return
+// WithBasicAuthValidator consults validator for basic auth credentials that
+// match none of the configured credentials. The claims it returns for valid
+// credentials are mapped into the context and checked against the required
+// and authorized claims like token claims.
+func WithBasicAuthValidator(validator func(ctx context.Context, user, pass string) (map[string]any, bool)) handlerOpt {
+	return func(h *handler) {
+		h.BasicAuthValidator = validator
 	}
+}
 
-	for _, key := range h.ApiKeys {
-		if key.Matches(r) {
-			h.Serve(w, r)
+// WithLabeledApiKey authorizes value like WithApiKeys and makes label
+// available to the wrapped handler through ApiKeyLabelFromContext.
+func WithLabeledApiKey(label, value string) handlerOpt {
+	return func(h *handler) {
+		if value == "" {
+			h.configError("WithLabeledApiKey", ErrEmptyCredential)
 			return
 		}
+		h.ApiKeys = append(h.ApiKeys, ApiKey{Value: value, Label: label})
 	}
+}
 
-	w.WriteHeader(http.StatusUnauthorized)
+// RequireApiKeyAndToken demands both an API key and either a static token or
+// a bearer token accepted by the authorizer. Basic auth credentials no longer
+// stand in for the token.
+func RequireApiKeyAndToken() handlerOpt {
+	return func(h *handler) {
+		h.RequireApiKeyAndToken = true
+	}
 }
 
-func (h *handler) Serve(w http.ResponseWriter, r *http.Request) {
+// StrictApiKeyHeader rejects requests carrying more than one X-Api-Key
+// header, or more than one Authorization header unless the authorizer
+// accepts credential lists, even when the values are identical. By default
+// the first value is used.
+func StrictApiKeyHeader() handlerOpt {
+	return func(h *handler) {
+		h.StrictApiKeyHeader = true
+	}
+}
 
-	for _, cred := range h.BasicAuthCredentials {
-		if cred.Matches(r) {
-			h.Handler.ServeHTTP(w, r)
-			return
-		}
+// RequireExplicitAuthorizer makes a handler that requires claims or tokens
+// but was given no authorizer fail validation, rather than falling back to
+// the NoopAuthorizer.
+func RequireExplicitAuthorizer() handlerOpt {
+	return func(h *handler) {
+		h.RequireExplicitAuthorizer = true
 	}
+}
 
-	for _, claim := range h.AuthorizedTokens {
-		if claim.Matches(r) {
-			h.Handler.ServeHTTP(w, r)
-			return
+// AllowAnonymous forwards requests that carry no credentials at all, without
+// claims and without checking any claim requirements. Requests with invalid
+// credentials are still rejected. Authenticated tells the two apart.
+func AllowAnonymous() handlerOpt {
+	return func(h *handler) {
+		h.AllowAnonymous = true
+	}
+}
+
+// StripCredentials removes the Authorization and X-Api-Key headers, and any
+// header or cookie the authorizer reads credentials from, such as those of
+// WithBase64HeaderToken and NewSessionAuthorizer, from authorized requests
+// before forwarding them, so that a proxied upstream never sees them. Claims
+// and everything else put in the context remain.
+func StripCredentials() handlerOpt {
+	return func(h *handler) {
+		h.StripCredentials = true
+	}
+}
+
+// WithTrustedProxies sets how many proxies in front of the handler are
+// trusted to append to X-Forwarded-For when ClientIP derives the client
+// address reported in audit events.
+func WithTrustedProxies(hops int) handlerOpt {
+	return func(h *handler) {
+		h.TrustedProxies = hops
+	}
+}
+
+// WithExcludedPaths forwards requests for the given paths without any
+// authorization. A pattern matches its exact path or, when it ends in "/*",
+// any path beneath it.
+func WithExcludedPaths(patterns ...string) handlerOpt {
+	return func(h *handler) {
+		h.ExcludedPaths = append(h.ExcludedPaths, patterns...)
+	}
+}
+
+// AllowPreflight forwards CORS preflight requests, which browsers send
+// without credentials, without any authorization so that CORS middleware
+// downstream can answer them. Other OPTIONS requests are authorized as usual.
+func AllowPreflight() handlerOpt {
+	return func(h *handler) {
+		h.AllowPreflight = true
+	}
+}
+
+// WithClaimsFromContext trusts claims that upstream middleware has already
+// verified and stored in the request context under key, either as a
+// map[string]interface{} or a *Claims. Requests carrying them skip the
+// authorizer; requests without them are authorized as usual.
+func WithClaimsFromContext(key interface{}) handlerOpt {
+	return func(h *handler) {
+		h.UpstreamClaimsKey = key
+	}
+}
+
+// RequireClaimsFromContext is like WithClaimsFromContext but rejects requests
+// that arrive without upstream claims.
+func RequireClaimsFromContext(key interface{}) handlerOpt {
+	return func(h *handler) {
+		h.UpstreamClaimsKey = key
+		h.RequireUpstreamClaims = true
+	}
+}
+
+func IncludeClaimInContext(key string) handlerOpt {
+	return IncludeClaimInContextAs(key, key)
+}
+
+func IncludeClaimInContextAs(from string, to string) handlerOpt {
+	return func(h *handler) {
+		if from != "" && to != "" {
+			if h.mapContextKey("IncludeClaimInContextAs", from, to) {
+				delete(h.ClaimTransforms, to)
+			}
 		}
 	}
+}
 
-	if err := h.Authorizer.Authorize(r); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		h.Logger.Error(err)
-		return
+// IncludeClaimInContextWith stores the claim from in the context under to,
+// converted by transform. When transform returns nil the value is left out
+// of the context, as for FailOnContextMappingError. Lowercase and
+// NumericDateToTime cover the common cases.
+func IncludeClaimInContextWith(from string, to string, transform func(any) any) handlerOpt {
+	return func(h *handler) {
+		if from != "" && to != "" && h.mapContextKey("IncludeClaimInContextWith", from, to) {
+			if transform != nil {
+				h.ClaimTransforms[to] = transform
+			} else {
+				delete(h.ClaimTransforms, to)
+			}
+		}
 	}
+}
 
-	for _, claim := range h.AuthorizedClaims {
-		if claim.Matches(r) {
-			h.Handler.ServeHTTP(w, r)
+// IncludeHeaderInContextAs stores a protected header value of the verified
+// token, one of kid, alg, typ or cty, in the context under key.
+func IncludeHeaderInContextAs(header string, key string) handlerOpt {
+	return func(h *handler) {
+		for _, exposed := range exposedHeaders {
+			if header != exposed {
+				continue
+			}
+			if claim, ok := h.ClaimMapping[key]; ok {
+				h.configError("IncludeHeaderInContextAs", fmt.Errorf("%w: claim %q and header %q both map to %q", ErrClaimMappingCollision, claim, header, key))
+				return
+			}
+			h.HeaderMapping[key] = header
 			return
 		}
+		h.configError("IncludeHeaderInContextAs", fmt.Errorf("%w: %q", ErrUnsupportedHeader, header))
 	}
+}
 
-	hasCreds := len(h.BasicAuthCredentials) > 0
-	hasTokens := len(h.AuthorizedTokens) > 0
-	hasClaims := len(h.AuthorizedClaims) > 0
+func IncludeExpirationInContext() handlerOpt {
+	return IncludeExpirationInContextAs(ExpirationKey)
+}
 
-	if hasCreds || hasTokens || hasClaims {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+func IncludeExpirationInContextAs(key string) handlerOpt {
+	return includeTimeInContextAs("IncludeExpirationInContextAs", ExpirationKey, key)
+}
+
+func IncludeIssuedAtInContext() handlerOpt {
+	return IncludeIssuedAtInContextAs(IssuedAtKey)
+}
+
+func IncludeIssuedAtInContextAs(key string) handlerOpt {
+	return includeTimeInContextAs("IncludeIssuedAtInContextAs", IssuedAtKey, key)
+}
+
+func IncludeNotBeforeInContext() handlerOpt {
+	return IncludeNotBeforeInContextAs(NotBeforeKey)
+}
+
+func IncludeNotBeforeInContextAs(key string) handlerOpt {
+	return includeTimeInContextAs("IncludeNotBeforeInContextAs", NotBeforeKey, key)
+}
+
+func includeTimeInContextAs(option, from, to string) handlerOpt {
+	return func(h *handler) {
+		if to != "" && h.mapContextKey(option, from, to) {
+			h.ClaimTransforms[to] = numericDate
+		}
 	}
+}
 
-	h.Handler.ServeHTTP(w, r)
+// FailOnContextMappingError rejects requests with 500 when a claim cannot be
+// converted for the context, such as a non-numeric exp included with
+// IncludeExpirationInContext. By default the failed mapping is left out of
+// the context, logged and reported in the audit event.
+func FailOnContextMappingError() handlerOpt {
+	return func(h *handler) {
+		h.FailOnContextMappingError = true
+	}
 }
 
-type BasicAuthCredential struct {
-	Username, Password string
+// RequireMappedClaims rejects requests whose claims lack, or have null for,
+// a claim included in the context, instead of storing nil for it. They are
+// answered with 401 unless WithRejectionStatus sets another status for
+// ErrMissingMappedClaim.
+func RequireMappedClaims() handlerOpt {
+	return func(h *handler) {
+		h.RequireMappedClaims = true
+	}
 }
 
-func (c BasicAuthCredential) Matches(r *http.Request) bool {
-	user, pass, ok := r.BasicAuth()
-	return ok && c.Username == user && c.Password == pass
+// IncludeAllClaimsInContext stores every top-level claim in the context
+// under its own name.
+func IncludeAllClaimsInContext() handlerOpt {
+	return func(h *handler) {
+		h.IncludeAllClaims = true
+	}
 }
 
-type AuthorizedToken struct {
-	Value string
+// WithStringContextKeys also stores values included in the context under
+// their plain string names, as before ContextKey, for downstream handlers
+// still reading them that way. Prefer Claim.
+func WithStringContextKeys() handlerOpt {
+	return func(h *handler) {
+		h.StringContextKeys = true
+	}
 }
 
-func (t AuthorizedToken) Matches(r *http.Request) bool {
-	header := r.Header.Get("Authorization")
-	if header == "" {
-		return false
+// WithMaxClaimsSize caps the serialized size of the claims carried into the
+// request context. Oversized claims are cut down to the claims the handler
+// explicitly uses, unless RejectOversizedClaims is set.
+func WithMaxClaimsSize(bytes int) handlerOpt {
+	return func(h *handler) {
+		h.MaxClaimsSize = bytes
 	}
+}
 
-	parts := strings.Split(header, " ")
+func RejectOversizedClaims() handlerOpt {
+	return func(h *handler) {
+		h.RejectOversizedClaims = true
+	}
+}
 
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return false
+// IncludeTokenInContext stores the verified bearer token in the context,
+// wrapped in Redacted; for a credential list, the token that was accepted.
+// Read it with TokenFromContext.
+func IncludeTokenInContext() handlerOpt {
+	return func(h *handler) {
+		h.IncludeToken = true
 	}
+}
 
-	return parts[1] == t.Value
+func IncludeTokenTTLInContext(key string) handlerOpt {
+	return func(h *handler) {
+		h.TokenTTLKey = key
+	}
 }
 
-type AuthorizedClaim struct {
-	Key, Value string
+// WithAudiencePolicy applies the claim requirements and context mappings in
+// opts, on top of the base policy, to tokens minted for aud. Options that a
+// policy cannot apply, such as credentials, are configuration errors.
+func WithAudiencePolicy(aud string, opts ...handlerOpt) handlerOpt {
+	return func(h *handler) {
+		scratch := &handler{
+			ClaimMapping:    map[string]string{},
+			ClaimTransforms: map[string]func(interface{}) interface{}{},
+		}
+
+		for _, opt := range opts {
+			opt(scratch)
+		}
+
+		h.ConfigErrors = append(h.ConfigErrors, scratch.ConfigErrors...)
+
+		policy := AudiencePolicy{
+			Audience:          aud,
+			AuthorizedClaims:  scratch.AuthorizedClaims,
+			ClaimMapping:      scratch.ClaimMapping,
+			ClaimTransforms:   scratch.ClaimTransforms,
+			RequiredClaims:    scratch.RequiredClaims,
+			RequiredScopes:    scratch.RequiredScopes,
+			AuthorizedIssuers: scratch.AuthorizedIssuers,
+			RequiredACR:       scratch.RequiredACR,
+			RequiredAMR:       scratch.RequiredAMR,
+			AllowedActors:     scratch.AllowedActors,
+			ClaimExpressions:  scratch.ClaimExpressions,
+			PathValueClaims:   scratch.PathValueClaims,
+			MaxSessionAge:     scratch.MaxSessionAge,
+		}
+
+		if ignored := ignoredPolicyOptions(scratch); len(ignored) > 0 {
+			h.configError("WithAudiencePolicy", fmt.Errorf("%w: the policy for %q cannot apply %s", ErrInvalidConfiguration, aud, strings.Join(ignored, ", ")))
+			return
+		}
+
+		h.AudiencePolicies = append(h.AudiencePolicies, policy)
+	}
 }
 
-func (c AuthorizedClaim) Matches(r *http.Request) bool {
-	return r.Context().Value(c.Key) == c.Value
+// ignoredPolicyOptions names the fields that options set on scratch beyond those
+// the policy holds, so that they are not silently dropped. It clears the
+// fields the policy holds, so scratch must not be used afterwards.
+func ignoredPolicyOptions(scratch *handler) []string {
+
+	scratch.ConfigErrors = nil
+	scratch.AuthorizedClaims = nil
+	scratch.ClaimMapping = nil
+	scratch.ClaimTransforms = nil
+	scratch.RequiredClaims = nil
+	scratch.RequiredScopes = nil
+	scratch.AuthorizedIssuers = nil
+	scratch.RequiredACR = nil
+	scratch.RequiredAMR = nil
+	scratch.AllowedActors = nil
+	scratch.ClaimExpressions = nil
+	scratch.PathValueClaims = nil
+	scratch.MaxSessionAge = 0
+
+	var ignored []string
+	v := reflect.ValueOf(scratch).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			ignored = append(ignored, v.Type().Field(i).Name)
+		}
+	}
+	return ignored
 }
 
-type ApiKey struct {
-	Value string
+// requirements is a handler holding only the policy's claim requirements,
+// for checkRequirements.
+func (p *AudiencePolicy) requirements() *handler {
+	return &handler{
+		RequiredClaims:    p.RequiredClaims,
+		RequiredScopes:    p.RequiredScopes,
+		AuthorizedIssuers: p.AuthorizedIssuers,
+		RequiredACR:       p.RequiredACR,
+		RequiredAMR:       p.RequiredAMR,
+		AllowedActors:     p.AllowedActors,
+		ClaimExpressions:  p.ClaimExpressions,
+		PathValueClaims:   p.PathValueClaims,
+		MaxSessionAge:     p.MaxSessionAge,
+	}
 }
 
-func (k ApiKey) Matches(r *http.Request) bool {
-	header := r.Header.Get("X-Api-Key")
-	if header == "" {
-		return false
+// FailOpenOnAuthorizerOutage admits tokens that verified successfully within
+// window when the authorizer fails because the key set is unavailable. Such
+// requests are flagged with DegradedAuthFromContext. Only use this for
+// low-risk services.
+func FailOpenOnAuthorizerOutage(window time.Duration) handlerOpt {
+	return func(h *handler) {
+		h.FailOpenWindow = window
+	}
+}
+
+// WithVerifiedTokenCache stores the tokens remembered for
+// FailOpenOnAuthorizerOutage in cache, so that replicas can share them.
+func WithVerifiedTokenCache(cache Cache) handlerOpt {
+	return func(h *handler) {
+		h.VerifiedTokens = cache
+	}
+}
+
+func WithClaimExpression(expr string) handlerOpt {
+	return func(h *handler) {
+		compiled, err := ParseClaimExpression(expr)
+		if err != nil {
+			h.configError("WithClaimExpression", err)
+			return
+		}
+		h.ClaimExpressions = append(h.ClaimExpressions, compiled)
+	}
+}
+
+// WithRequiredACR and WithRequiredAMR demand step-up authentication: the
+// token's acr must be one of the given values, or its amr must contain one of
+// the given methods. When both are configured either one suffices.
+func WithRequiredACR(values ...string) handlerOpt {
+	return func(h *handler) {
+		h.RequiredACR = append(h.RequiredACR, values...)
+	}
+}
+
+func WithRequiredAMR(values ...string) handlerOpt {
+	return func(h *handler) {
+		h.RequiredAMR = append(h.RequiredAMR, values...)
+	}
+}
+
+// WithUnauthorizedResponder replaces the ErrorResponse written for rejected
+// requests. The responder receives the rejection, which matches one
+// of the rejection sentinels such as ErrClaimsNotAuthorized with errors.Is,
+// and the underlying authorizer error when there is one.
+func WithUnauthorizedResponder(responder func(w http.ResponseWriter, r *http.Request, err error)) handlerOpt {
+	return func(h *handler) {
+		h.UnauthorizedResponder = responder
+	}
+}
+
+func WithExpiryRetryHint(threshold time.Duration) handlerOpt {
+	return func(h *handler) {
+		h.RetryHintThreshold = threshold
+	}
+}
+
+// WithLoginRedirect sends unauthenticated browser navigations to loginURL,
+// with the requested path in a return_to parameter, instead of answering
+// 401. Other clients still get 401.
+func WithLoginRedirect(loginURL string) handlerOpt {
+	return func(h *handler) {
+		parsed, err := url.Parse(loginURL)
+		if err != nil {
+			h.configError("WithLoginRedirect", err)
+			return
+		}
+		h.LoginRedirect = parsed
+	}
+}
+
+// NewHandler wraps next with authorization. Handlers that run background
+// work, such as WithTokenProvider, should be closed when no longer needed:
+//
+//	h := authorizer.NewHandler(logger, next, opts...)
+//	defer h.Close()
+func NewHandler(
+	logger Logger,
+	next http.Handler,
+	opts ...handlerOpt,
+) *handler {
+	handler := &handler{
+		Logger:          logger,
+		Authorizer:      NoopAuthorizer(),
+		Handler:         next,
+		ClaimMapping:    map[string]string{},
+		ClaimTransforms: map[string]func(interface{}) interface{}{},
+		HeaderMapping:   map[string]string{},
+	}
+
+	for _, opt := range opts {
+		opt(handler)
+	}
+
+	for _, key := range handler.ApiKeys {
+		handler.apiKeyDigests = append(handler.apiKeyDigests, key.digest())
+	}
+
+	_, noop := handler.Authorizer.(*noopAuthorizer)
+	noTokens := noop && len(handler.AuthorizedTokens) == 0 && handler.TokenProvider == nil
+	if handler.RequireApiKeyAndToken && (noTokens || (len(handler.ApiKeys) == 0 && handler.ApiKeyValidator == nil && handler.ApiKeySecret == nil)) {
+		handler.configError("RequireApiKeyAndToken", fmt.Errorf("%w: both api keys and static tokens or an authorizer are needed", ErrContradictoryConfiguration))
+	}
+
+	if handler.RequireExplicitAuthorizer && noop && ((handler.UpstreamClaimsKey == nil && handler.requiresClaims()) || len(handler.AuthorizedTokens) > 0 || handler.TokenProvider != nil) {
+		handler.configError("RequireExplicitAuthorizer", ErrNoAuthorizerSet)
+	}
+
+	if handler.JSONErrors && handler.UnauthorizedResponder != nil {
+		handler.configError("WithJSONErrors", fmt.Errorf("%w: an unauthorized responder replaces the error response", ErrContradictoryConfiguration))
+	}
+
+	if handler.StrictConfiguration {
+		handler.ConfigErrors = append(handler.ConfigErrors, handler.contradictions()...)
+	}
+
+	if handler.FailOpenWindow > 0 && handler.VerifiedTokens == nil {
+		handler.VerifiedTokens = NewMemoryCache(defaultVerifiedTokens)
+	}
+
+	if handler.TokenProvider != nil {
+		handler.TokenProvider.start(logger, &handler.workers)
+	}
+
+	if handler.SchemaObserver != nil {
+		handler.SchemaObserver.start(&handler.workers)
+	}
+
+	if handler.AsyncAudit != nil {
+		handler.AsyncAudit.start(&handler.workers, handler.deliverAudit)
+	}
+
+	return handler
+}
+
+type handler struct {
+	Logger
+	Authorizer
+	http.Handler
+
+	BasicAuthCredentials  []BasicAuthCredential
+	AuthorizedTokens      []AuthorizedToken
+	AuthorizedClaims      []AuthorizedClaim
+	RequiredClaims        []RequiredClaim
+	ApiKeys               []ApiKey
+	ApiKeyValidator       func(context.Context, string) (map[string]any, bool)
+	ApiKeySecret          []byte
+	BasicAuthValidator    func(context.Context, string, string) (map[string]any, bool)
+	ExcludedPaths         []string
+	RetryHintThreshold    time.Duration
+	ClaimMapping          map[string]string
+	ClaimTransforms       map[string]func(interface{}) interface{}
+	HeaderMapping         map[string]string
+	AudiencePolicies      []AudiencePolicy
+	TokenTTLKey           string
+	FailOpenWindow        time.Duration
+	VerifiedTokens        Cache
+	ClaimExpressions      []*ClaimExpression
+	Vetoes                []func(*http.Request, map[string]any) error
+	UpstreamClaimsKey     interface{}
+	TokenProvider         *tokenProvider
+	SchemaObserver        *schemaObserver
+	AsyncAudit            *asyncAudit
+	MaxClaimsSize         int
+	RequiredACR           []string
+	RequiredAMR           []string
+	AuditHooks            []func(AuditEvent)
+	LoginRedirect         *url.URL
+	Challenges            []Challenge
+	UnauthorizedResponder func(http.ResponseWriter, *http.Request, error)
+	JSONErrors            bool
+	RejectionStatus       map[error]int
+	BindingStore          BindingStore
+	BindingStrategy       BindingStrategy
+	ConfigErrors          []error
+
+	RequireUpstreamClaims bool
+	RejectOversizedClaims bool
+	IncludeAllClaims      bool
+	StringContextKeys     bool
+	IncludeToken          bool
+	AllowedActors         []string
+	RequiredScopes        []string
+	AuthorizedIssuers     []string
+	PathValueClaims       []pathValueClaim
+	StrictConfiguration   bool
+	AllowPreflight        bool
+	RequireApiKeyAndToken bool
+	StrictApiKeyHeader    bool
+
+	RequireExplicitAuthorizer bool
+	AllowAnonymous            bool
+	StripCredentials          bool
+	TrustedProxies            int
+	ForwardedClaims           []forwardedClaim
+
+	DecisionHooks             []decisionHooks
+	MaxSessionAge             time.Duration
+	RoutePattern              string
+	PathNormalizer            func(string) string
+	FailOnContextMappingError bool
+	RequireMappedClaims       bool
+	PolicySecretEnvs          map[string]string
+
+	workers       workers
+	deprecated    rateLimitedLog
+	apiKeyDigests [][sha256.Size]byte
+}
+
+// Validate reports options that could not be applied. A handler that fails
+// validation rejects every request.
+func (h *handler) Validate() error {
+	return errors.Join(h.ConfigErrors...)
+}
+
+// ValidateOnStartup checks the handler's configuration end to end, including
+// fetching the authorizer's keys, and reports everything that is wrong.
+func (h *handler) ValidateOnStartup(ctx context.Context) error {
+
+	errs := []error{h.Validate()}
+
+	if _, ok := h.Authorizer.(*noopAuthorizer); ok {
+		if h.UpstreamClaimsKey == nil && h.requiresClaims() {
+			errs = append(errs, ErrNoAuthorizerSet)
+		}
+	} else if validator, ok := h.Authorizer.(startupValidator); ok {
+		errs = append(errs, validator.ValidateOnStartup(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// requiresClaims reports whether any option matches against token claims.
+func (h *handler) requiresClaims() bool {
+	return len(h.AuthorizedClaims) > 0 || len(h.RequiredClaims) > 0 || len(h.RequiredScopes) > 0 || len(h.AuthorizedIssuers) > 0 ||
+		len(h.PathValueClaims) > 0 || len(h.AudiencePolicies) > 0 || len(h.ClaimExpressions) > 0 || h.MaxSessionAge > 0
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if err := h.Validate(); err != nil {
+		h.reject(w, r, &rejection{ErrInvalidConfiguration, err})
+		return
+	}
+
+	if h.excluded(r.URL.Path) || h.preflight(r) {
+		h.forwardClaims(r)
+		h.decideAllow(r, MechanismNone)
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	if err := h.checkDuplicateCredentials(r); err != nil {
+		h.reject(w, r, &rejection{ErrCredentialsRejected, err})
+		return
+	}
+
+	if len(h.ApiKeys) == 0 && h.ApiKeyValidator == nil && h.ApiKeySecret == nil {
+		h.Serve(w, r)
+		return
+	}
+
+	header := r.Header.Get("X-Api-Key")
+	digest := sha256.Sum256([]byte(header))
+
+	for i, key := range h.ApiKeys {
+		if header != "" && subtle.ConstantTimeCompare(digest[:], h.apiKeyDigests[i][:]) == 1 {
+			if !key.Allows(r.URL.Path) {
+				h.reject(w, r, &rejection{Reason: ErrApiKeyOutOfScope})
+				return
+			}
+			ctx := withValue(r.Context(), mechanismContextKey{}, MechanismApiKey)
+			if key.Label != "" {
+				ctx = withValue(ctx, apiKeyLabelContextKey{}, key.Label)
+			}
+			if key.Claims != nil {
+				ctx = ContextWithClaims(ctx, key.Claims)
+			}
+			*r = *r.WithContext(ctx)
+			h.Serve(w, r)
+			return
+		}
+	}
+
+	if header != "" {
+		if claims, ok := h.validateApiKey(r.Context(), header); ok {
+			ctx := withValue(r.Context(), mechanismContextKey{}, MechanismApiKey)
+			if label, ok := claims["label"].(string); ok && label != "" && h.ApiKeySecret != nil {
+				ctx = withValue(ctx, apiKeyLabelContextKey{}, label)
+			}
+			*r = *r.WithContext(ContextWithClaims(ctx, claims))
+			h.Serve(w, r)
+			return
+		}
+	}
+
+	h.reject(w, r, &rejection{Reason: ErrApiKeyRequired})
+}
+
+func (h *handler) checkDuplicateCredentials(r *http.Request) error {
+
+	if !h.StrictApiKeyHeader {
+		return nil
+	}
+
+	headers := []string{"X-Api-Key"}
+	if a, ok := h.Authorizer.(*authorizer); !ok || !a.AllowCredentialList {
+		headers = append(headers, "Authorization")
+	}
+
+	for _, header := range headers {
+		if values := r.Header.Values(header); len(values) > 1 {
+			h.logWarning(r, ErrDuplicateCredential, Field{"header", header}, Field{"count", len(values)})
+			return fmt.Errorf("%w: %s", ErrDuplicateCredential, header)
+		}
+	}
+
+	return nil
+}
+
+// Serve authorizes the request and forwards it. Basic auth credentials and
+// static tokens are tried first, then the authorizer. Every required claim
+// must then match; authorized claims are evaluated in the order they were
+// registered and the first match wins.
+func (h *handler) Serve(w http.ResponseWriter, r *http.Request) {
+
+	if !h.RequireApiKeyAndToken {
+		for _, cred := range h.BasicAuthCredentials {
+			if cred.Matches(r) {
+				h.allow(w, r, MechanismBasicAuth, "")
+				return
+			}
+		}
+
+		if user, pass, ok := r.BasicAuth(); ok && h.BasicAuthValidator != nil {
+			if claims, valid := h.BasicAuthValidator(r.Context(), user, pass); valid {
+				*r = *r.WithContext(ContextWithClaims(r.Context(), claims))
+				if rule, ok := h.admit(w, r, MechanismBasicAuth); ok {
+					h.allow(w, r, MechanismBasicAuth, rule)
+				}
+				return
+			}
+		}
+	}
+
+	for _, claim := range h.authorizedTokens() {
+		if claim.Matches(r) {
+			h.allow(w, r, MechanismStaticToken, "")
+			return
+		}
+	}
+
+	mechanism := MechanismBearerToken
+
+	if claims, ok := h.upstreamClaims(r); ok {
+		*r = *r.WithContext(ContextWithTypedClaims(r.Context(), claims))
+		mechanism = MechanismUpstreamClaims
+	} else if h.RequireUpstreamClaims {
+		h.reject(w, r, &rejection{ErrNoCredentials, ErrMissingUpstreamClaims})
+		return
+	} else if err := h.Authorizer.Authorize(r); err == nil {
+		h.rememberVerified(r)
+	} else if h.AllowAnonymous && missingCredentials(err) {
+		h.allow(w, r, MechanismAnonymous, "")
+		return
+	} else if !h.failOpen(r, err) {
+		h.setRetryHint(w, err)
+		h.reject(w, r, authorizerRejection(err))
+		return
+	}
+
+	if aud, ok := DeprecatedAudienceFromContext(r.Context()); ok {
+		h.warnDeprecatedAudience(r, aud)
+	}
+
+	if mechanism == MechanismBearerToken {
+		if err := h.checkBinding(r); err != nil {
+			h.reject(w, r, &rejection{ErrCredentialsRejected, err})
+			return
+		}
+	}
+
+	if _, ok := h.Authorizer.(*noopAuthorizer); ok && mechanism == MechanismBearerToken {
+		mechanism = MechanismNone
+		if MechanismFromContext(r.Context()) == MechanismApiKey {
+			mechanism = MechanismApiKey
+		}
+	}
+
+	rule, ok := h.admit(w, r, mechanism)
+	if !ok {
+		return
+	}
+
+	if rule != "" {
+		h.allow(w, r, mechanism, rule)
+		return
+	}
+
+	hasCreds := (len(h.BasicAuthCredentials) > 0 || h.BasicAuthValidator != nil) && !h.RequireApiKeyAndToken
+	hasTokens := len(h.AuthorizedTokens) > 0 || h.TokenProvider != nil
+
+	switch {
+	case (hasCreds || hasTokens) && r.Header.Get("Authorization") != "":
+		h.reject(w, r, &rejection{Reason: ErrCredentialsRejected})
+		return
+	case hasCreds || hasTokens:
+		h.reject(w, r, &rejection{Reason: ErrNoCredentials})
+		return
+	}
+
+	h.allow(w, r, mechanism, "")
+}
+
+// admit maps the claims verified by mechanism into the context and checks
+// them against the requirements and authorized claims of the handler and of
+// the audience policy. It reports the rule that matched, if any, or false
+// once the request has been rejected.
+func (h *handler) admit(w http.ResponseWriter, r *http.Request, mechanism string) (string, bool) {
+
+	policy := h.audiencePolicy(r)
+
+	if err := h.updateContext(r, policy); err != nil {
+		h.reject(w, r, contextRejection(err))
+		return "", false
+	}
+
+	if !h.checkRequirements(w, r, h, mechanism) {
+		return "", false
+	}
+
+	if policy != nil && !h.checkRequirements(w, r, policy.requirements(), mechanism) {
+		return "", false
+	}
+
+	claims := h.AuthorizedClaims
+	if policy != nil {
+		claims = append(append([]AuthorizedClaim{}, claims...), policy.AuthorizedClaims...)
+	}
+
+	for _, claim := range claims {
+		if claim.Matches(r) {
+			return claim.RuleName(), true
+		}
+	}
+
+	if len(claims) > 0 {
+		h.reject(w, r, h.claimsRejection(r, nil))
+		return "", false
+	}
+
+	return "", true
+}
+
+// checkRequirements rejects the request unless its claims, verified by
+// mechanism, meet the requirements held by req, the handler itself or an
+// audience policy.
+func (h *handler) checkRequirements(w http.ResponseWriter, r *http.Request, req *handler, mechanism string) bool {
+
+	if err := req.checkActor(r); err != nil {
+		h.reject(w, r, h.claimsRejection(r, err))
+		return false
+	}
+
+	for _, expr := range req.ClaimExpressions {
+		if !expr.Evaluate(ClaimsFromContext(r.Context())) {
+			h.reject(w, r, h.claimsRejection(r, nil))
+			return false
+		}
+	}
+
+	if !req.sufficientAuthentication(r) {
+		req.setStepUpChallenge(w)
+		h.reject(w, r, &rejection{ErrClaimsNotAuthorized, ErrInsufficientAuthentication})
+		return false
+	}
+
+	if err := req.checkSessionAge(r, mechanism); err != nil {
+		req.setReauthenticationChallenge(w)
+		h.reject(w, r, &rejection{ErrReauthenticationRequired, err})
+		return false
+	}
+
+	for _, claim := range req.RequiredClaims {
+		if !claim.Matches(r) {
+			h.reject(w, r, h.claimsRejection(r, nil))
+			return false
+		}
+	}
+
+	if err := req.checkScopes(r); err != nil {
+		h.reject(w, r, h.claimsRejection(r, err))
+		return false
+	}
+
+	if err := req.checkIssuer(r); err != nil {
+		h.reject(w, r, h.claimsRejection(r, err))
+		return false
+	}
+
+	for _, claim := range req.PathValueClaims {
+		if err := claim.check(r); err != nil {
+			h.reject(w, r, h.claimsRejection(r, err))
+			return false
+		}
+	}
+
+	return true
+}
+
+// claimsRejection answers claims that are not authorized with 403 only once
+// a credential was verified. Otherwise the request is rejected, with 401, as
+// lacking or presenting bad credentials, such as a wrong basic auth password.
+func (h *handler) claimsRejection(r *http.Request, cause error) *rejection {
+
+	if MechanismFromContext(r.Context()) == MechanismApiKey || TypedClaimsFromContext(r.Context()) != nil {
+		return &rejection{ErrClaimsNotAuthorized, cause}
+	}
+
+	if cause == nil {
+		cause = ErrClaimsNotAuthorized
+	} else {
+		cause = fmt.Errorf("%w: %w", ErrClaimsNotAuthorized, cause)
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		return &rejection{ErrNoCredentials, cause}
+	}
+	return &rejection{ErrCredentialsRejected, cause}
+}
+
+func (h *handler) preflight(r *http.Request) bool {
+	return h.AllowPreflight && r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (h *handler) excluded(urlPath string) bool {
+
+	if len(h.ExcludedPaths) == 0 {
+		return false
+	}
+
+	cleaned := path.Clean("/" + urlPath)
+
+	for _, pattern := range h.ExcludedPaths {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(cleaned, prefix+"/") {
+				return true
+			}
+		} else if cleaned == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close stops any background work started by the handler's options and waits
+// for it to finish. It is safe to call more than once. The handler's
+// authorizer is not closed, since it may be shared.
+func (h *handler) Close() error {
+	return h.workers.Close()
+}
+
+func (h *handler) authorizedTokens() []AuthorizedToken {
+	if h.TokenProvider == nil {
+		return h.AuthorizedTokens
+	}
+	return append(append([]AuthorizedToken{}, h.AuthorizedTokens...), h.TokenProvider.Tokens()...)
+}
+
+// defaultVerifiedTokens bounds the in-memory cache of recently verified
+// tokens used by FailOpenOnAuthorizerOutage.
+const defaultVerifiedTokens = 10000
+
+type verifiedToken struct {
+	At     time.Time
+	Claims *Claims
+}
+
+func verifiedTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "verified:" + hex.EncodeToString(sum[:])
+}
+
+// credentialCarrier is implemented by authorizers that read credentials
+// from headers or cookies other than Authorization.
+type credentialCarrier interface {
+	credentialHeaders() []string
+	credentialCookies() []string
+}
+
+func (h *handler) stripCredentials(r *http.Request) {
+
+	r.Header = r.Header.Clone()
+	r.Header.Del("Authorization")
+	r.Header.Del("X-Api-Key")
+
+	carrier, ok := h.Authorizer.(credentialCarrier)
+	if !ok {
+		return
+	}
+
+	for _, header := range carrier.credentialHeaders() {
+		r.Header.Del(header)
+	}
+
+	if names := carrier.credentialCookies(); len(names) > 0 {
+		var kept []string
+		for _, cookie := range r.Cookies() {
+			if !slices.Contains(names, cookie.Name) {
+				kept = append(kept, cookie.String())
+			}
+		}
+
+		r.Header.Del("Cookie")
+		if len(kept) > 0 {
+			r.Header.Set("Cookie", strings.Join(kept, "; "))
+		}
+	}
+}
+
+type contextUpdater interface {
+	updateContext(*http.Request, string, *Claims) error
+}
+
+type degradedAuthContextKey struct{}
+
+func DegradedAuthFromContext(ctx context.Context) bool {
+	degraded, _ := ctx.Value(degradedAuthContextKey{}).(bool)
+	return degraded
+}
+
+func (h *handler) rememberVerified(r *http.Request) {
+
+	token, ok := bearerToken(r)
+	if h.FailOpenWindow <= 0 || !ok {
+		return
+	}
+
+	data, err := json.Marshal(verifiedToken{time.Now(), TypedClaimsFromContext(r.Context())})
+	if err != nil {
+		return
+	}
+
+	if err = h.VerifiedTokens.SetWithTTL(r.Context(), verifiedTokenKey(token), data, h.FailOpenWindow); err != nil {
+		h.logError(r, err)
+	}
+}
+
+func (h *handler) failOpen(r *http.Request, err error) bool {
+
+	tokens := bearerTokens(r)
+	if h.FailOpenWindow <= 0 || len(tokens) == 0 {
+		return false
+	}
+
+	if !errors.Is(err, ErrKeySetUnavailable) {
+		for _, token := range tokens {
+			h.VerifiedTokens.Delete(r.Context(), verifiedTokenKey(token))
+		}
+		return false
+	}
+
+	for _, token := range tokens {
+		claims, ok := h.recallVerified(r, token)
+		if !ok {
+			continue
+		}
+
+		if updater, ok := h.Authorizer.(contextUpdater); ok {
+			updater.updateContext(r, token, claims)
+		} else {
+			*r = *r.WithContext(ContextWithTypedClaims(r.Context(), claims))
+		}
+
+		*r = *r.WithContext(withValue(r.Context(), degradedAuthContextKey{}, true))
+
+		h.logError(r, err, Field{"degraded_auth", true})
+
+		return true
+	}
+
+	return false
+}
+
+// recallVerified returns the claims of token if it was verified within the
+// fail open window and has not expired since.
+func (h *handler) recallVerified(r *http.Request, token string) (*Claims, bool) {
+
+	data, err := h.VerifiedTokens.Get(r.Context(), verifiedTokenKey(token))
+	if err != nil {
+		return nil, false
+	}
+
+	var verified verifiedToken
+	if json.Unmarshal(data, &verified) != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+
+	if now.Sub(verified.At) > h.FailOpenWindow {
+		return nil, false
+	}
+
+	claims := verified.Claims
+	if claims == nil {
+		claims = &Claims{}
+	}
+
+	if !claims.Expiry.IsZero() && now.After(claims.Expiry) {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func (h *handler) upstreamClaims(r *http.Request) (*Claims, bool) {
+
+	if h.UpstreamClaimsKey == nil {
+		return nil, false
+	}
+
+	switch claims := r.Context().Value(h.UpstreamClaimsKey).(type) {
+	case *Claims:
+		return claims, claims != nil
+	case map[string]interface{}:
+		return &Claims{Raw: claims}, claims != nil
+	}
+
+	return nil, false
+}
+
+func (h *handler) audiencePolicy(r *http.Request) *AudiencePolicy {
+
+	aud := ClaimsFromContext(r.Context())[audKey]
+	if aud == nil {
+		return nil
+	}
+
+	for i, policy := range h.AudiencePolicies {
+		if claimMatches(aud, policy.Audience) {
+			return &h.AudiencePolicies[i]
+		}
+	}
+
+	return nil
+}
+
+func (h *handler) updateContext(r *http.Request, policy *AudiencePolicy) error {
+
+	ctx := r.Context()
+	claims := ClaimsFromContext(ctx)
+
+	if size := claimsSize(claims); h.MaxClaimsSize > 0 && size > h.MaxClaimsSize {
+		h.logWarning(r, ErrClaimsTooLarge, Field{"claims_size", size})
+
+		if h.RejectOversizedClaims {
+			return ErrClaimsTooLarge
+		}
+
+		claims = h.requestedClaims(claims, policy)
+
+		typed := *TypedClaimsFromContext(ctx)
+		typed.Raw = claims
+		ctx = ContextWithTypedClaims(ctx, &typed)
+	}
+
+	size := len(h.ClaimMapping) + len(h.HeaderMapping) + 3
+	if h.IncludeAllClaims {
+		size += len(claims)
+	}
+	if policy != nil {
+		size += len(policy.ClaimMapping)
+	}
+
+	if h.StringContextKeys {
+		size *= 2
+	}
+
+	values := make(contextValues, 0, size)
+	setKey := func(key string, value interface{}) {
+		values.set(ContextKey(key), value)
+		if h.StringContextKeys {
+			values.set(key, value)
+		}
+	}
+
+	var (
+		mappingErrs []error
+		missing     []string
+	)
+	mapClaim := func(key, claim string, transform func(interface{}) interface{}) {
+		value, _ := lookupClaim(claims, claim)
+		if value == nil && h.RequireMappedClaims {
+			missing = append(missing, claim)
+			return
+		}
+		mapped := mappedValue(value, transform)
+		if value != nil && mapped == nil {
+			mappingErrs = append(mappingErrs, fmt.Errorf("%w: claim %q into %q", ErrClaimMappingFailed, claim, key))
+			return
+		}
+		setKey(key, mapped)
+	}
+
+	if h.IncludeAllClaims {
+		for key, value := range claims {
+			setKey(key, value)
+		}
+	}
+
+	for key, claim := range h.ClaimMapping {
+		mapClaim(key, claim, h.ClaimTransforms[key])
+	}
+
+	if policy != nil {
+		for key, claim := range policy.ClaimMapping {
+			mapClaim(key, claim, policy.ClaimTransforms[key])
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %s", ErrMissingMappedClaim, strings.Join(missing, ", "))
+	}
+
+	if err := errors.Join(mappingErrs...); err != nil {
+		if h.FailOnContextMappingError {
+			return err
+		}
+		h.logWarning(r, err)
+		values.set(mappingErrorContextKey{}, err)
+	}
+
+	typed := TypedClaimsFromContext(ctx)
+
+	if typed != nil {
+		for key, header := range h.HeaderMapping {
+			if value, ok := typed.Header[header]; ok {
+				setKey(key, value)
+			}
+		}
+	}
+
+	if actors := actorChain(claims); len(actors) > 0 {
+		values.set(actorContextKey{}, actors)
+	}
+
+	if token, ok := bearerToken(r); h.IncludeToken && ok {
+		values.set(tokenContextKey{}, Redacted{token})
+	}
+
+	if h.TokenTTLKey != "" && typed != nil && typed.TimeToExpiry > 0 {
+		setKey(h.TokenTTLKey, typed.TimeToExpiry)
+	}
+
+	*r = *r.WithContext(values.context(ctx))
+
+	return nil
+}
+
+// requestedClaims keeps only the claims the handler maps into the context or
+// matches against.
+func (h *handler) requestedClaims(claims map[string]interface{}, policy *AudiencePolicy) map[string]interface{} {
+
+	var names []string
+
+	for _, claim := range h.ClaimMapping {
+		names = append(names, claim)
+	}
+	for _, claim := range h.AuthorizedClaims {
+		names = append(names, claim.Key)
+	}
+	for _, claim := range h.ForwardedClaims {
+		names = append(names, claim.Claim)
+	}
+	names = append(names, h.requiredClaimNames()...)
+
+	if policy != nil {
+		for _, claim := range policy.ClaimMapping {
+			names = append(names, claim)
+		}
+		for _, claim := range policy.AuthorizedClaims {
+			names = append(names, claim.Key)
+		}
+		names = append(names, policy.requirements().requiredClaimNames()...)
+	}
+
+	requested := map[string]interface{}{}
+	for _, name := range names {
+		if value, ok := claims[name]; ok {
+			requested[name] = value
+		} else if root, _, ok := strings.Cut(name, "."); ok && claims[root] != nil {
+			requested[root] = claims[root]
+		}
+	}
+
+	return requested
+}
+
+// requiredClaimNames lists the claims checkRequirements reads.
+func (h *handler) requiredClaimNames() []string {
+
+	var names []string
+
+	for _, claim := range h.RequiredClaims {
+		names = append(names, claim.Key)
+	}
+	if len(h.RequiredScopes) > 0 {
+		names = append(names, scopeKey, scpKey)
+	}
+	if len(h.AuthorizedIssuers) > 0 {
+		names = append(names, issKey)
+	}
+	if len(h.RequiredACR) > 0 || len(h.RequiredAMR) > 0 {
+		names = append(names, acrKey, amrKey)
+	}
+	if len(h.AllowedActors) > 0 {
+		names = append(names, actKey)
+	}
+	if h.MaxSessionAge > 0 {
+		names = append(names, authTimeKey, IssuedAtKey)
+	}
+	for _, claim := range h.PathValueClaims {
+		names = append(names, claim.Claim)
+	}
+	for _, expr := range h.ClaimExpressions {
+		names = append(names, expr.claimNames()...)
+	}
+
+	return names
+}
+
+func claimsSize(claims map[string]interface{}) int {
+	if claims == nil {
+		return 0
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
+
+// deprecatedAudienceLogInterval limits deprecated audience warnings to one
+// per interval per handler.
+const deprecatedAudienceLogInterval = time.Minute
+
+type rateLimitedLog struct {
+	sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (h *handler) warnDeprecatedAudience(r *http.Request, aud string) {
+	h.deprecated.Lock()
+
+	now := time.Now()
+	if now.Sub(h.deprecated.last) < deprecatedAudienceLogInterval {
+		h.deprecated.suppressed++
+		h.deprecated.Unlock()
+		return
+	}
+
+	suppressed := h.deprecated.suppressed
+	h.deprecated.last = now
+	h.deprecated.suppressed = 0
+	h.deprecated.Unlock()
+
+	h.logWarning(r, ErrDeprecatedAudience, Field{"audience", aud}, Field{"suppressed", suppressed})
+}
+
+func (h *handler) logError(r *http.Request, err error, fields ...interface{}) {
+	h.Logger.Error(h.logArgs(r, err, fields)...)
+}
+
+func (h *handler) logWarning(r *http.Request, err error, fields ...interface{}) {
+	logWarning(h.Logger, h.logArgs(r, err, fields)...)
+}
+
+func (h *handler) logArgs(r *http.Request, err error, fields []interface{}) []interface{} {
+	args := append([]interface{}{&redactedError{h.redact(err.Error()), err}}, fields...)
+	return append(args, requestFields(r)...)
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redact applies RedactSecrets and also removes the credentials configured on
+// the handler.
+func (h *handler) redact(value string) string {
+
+	var secrets []string
+	for _, key := range h.ApiKeys {
+		secrets = append(secrets, key.Value)
+	}
+	for _, token := range h.authorizedTokens() {
+		secrets = append(secrets, token.Value)
+	}
+	for _, cred := range h.BasicAuthCredentials {
+		secrets = append(secrets, cred.Password)
+	}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, redacted)
+		}
+	}
+
+	return RedactSecrets(value)
+}
+
+func (h *handler) sufficientAuthentication(r *http.Request) bool {
+
+	if len(h.RequiredACR) == 0 && len(h.RequiredAMR) == 0 {
+		return true
+	}
+
+	acr := claimValue(r, acrKey)
+	for _, value := range h.RequiredACR {
+		if claimMatches(acr, value) {
+			return true
+		}
+	}
+
+	amr := claimValue(r, amrKey)
+	for _, value := range h.RequiredAMR {
+		if claimMatches(amr, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *handler) setStepUpChallenge(w http.ResponseWriter) {
+
+	challenge := `Bearer error="insufficient_authentication", error_description="step-up authentication required"`
+	if len(h.RequiredACR) > 0 {
+		challenge += `, acr_values="` + strings.Join(h.RequiredACR, " ") + `"`
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// isBrowserNavigation reports whether r looks like a browser loading a page
+// rather than an API client: a GET for HTML without any credentials.
+func isBrowserNavigation(r *http.Request) bool {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+		return false
+	}
+
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" && mode != "navigate" {
+		return false
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (h *handler) loginURL(r *http.Request) string {
+
+	target := *h.LoginRedirect
+
+	query := target.Query()
+	query.Set("return_to", returnTo(r.URL))
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+// returnTo limits the post-login destination to a path on this origin, so
+// that the login page cannot be used as an open redirect.
+func returnTo(u *url.URL) string {
+
+	value := u.RequestURI()
+
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") || strings.HasPrefix(value, "/\\") {
+		return "/"
+	}
+
+	return value
+}
+
+func (h *handler) setRetryHint(w http.ResponseWriter, err error) {
+
+	var expired *TokenExpiredError
+	if h.RetryHintThreshold <= 0 || !errors.As(err, &expired) {
+		return
+	}
+
+	if time.Since(expired.Expiry.Add(expired.Leeway)) > h.RetryHintThreshold {
+		return
+	}
+
+	w.Header().Set("Retry-After", "1")
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token expired"`)
+}
+
+// BasicAuthCredential matches requests presenting Username and Password,
+// or, when PasswordHash is set, a password with that bcrypt hash.
+type BasicAuthCredential struct {
+	Username, Password string
+	PasswordHash       string
+}
+
+func (c BasicAuthCredential) Matches(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userMatches := secretEqual(c.Username, user)
+
+	var passMatches bool
+	if c.PasswordHash != "" {
+		passMatches = bcrypt.CompareHashAndPassword([]byte(c.PasswordHash), []byte(pass)) == nil
+	} else {
+		passMatches = secretEqual(c.Password, pass)
+	}
+
+	return userMatches && passMatches
+}
+
+type AuthorizedToken struct {
+	Value string
+}
+
+func (t AuthorizedToken) Matches(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && secretEqual(token, t.Value)
+}
+
+// secretEqual compares digests of a and b in constant time, so that neither
+// the content nor the length of a configured secret leaks through timing.
+func secretEqual(a, b string) bool {
+	x := sha256.Sum256([]byte(a))
+	y := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
+
+// bearerToken returns the token the authorizer accepted or, before or
+// without one, the token of a single bearer credential.
+func bearerToken(r *http.Request) (string, bool) {
+	if token, ok := r.Context().Value(acceptedTokenContextKey{}).(string); ok {
+		return token, true
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+
+	token, err := parseBearer(header)
+	return token, err == nil
+}
+
+// bearerTokens returns the tokens of every bearer credential presented,
+// which may be a list, as AllowCredentialList accepts.
+func bearerTokens(r *http.Request) []string {
+	if token, ok := bearerToken(r); ok {
+		return []string{token}
+	}
+
+	var tokens []string
+	for _, credential := range splitCredentials(r.Header.Get("Authorization")) {
+		if token, err := parseBearer(strings.TrimSpace(credential)); err == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// AuthorizedClaim matches when the claim equals Value or, if Pattern is set,
+// is a string matching Pattern.
+type AuthorizedClaim struct {
+	Key     string
+	Value   interface{}
+	Pattern *regexp.Regexp
+	Name    string
+}
+
+// RuleName identifies the rule in audit events and MatchedRuleFromContext,
+// defaulting to key=value for unnamed rules.
+func (c AuthorizedClaim) RuleName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.Pattern != nil {
+		return fmt.Sprintf("%s~%s", c.Key, c.Pattern)
+	}
+	return fmt.Sprintf("%s=%v", c.Key, c.Value)
+}
+
+func (c AuthorizedClaim) Matches(r *http.Request) bool {
+	if c.Pattern != nil {
+		claim, ok := claimValue(r, c.Key).(string)
+		return ok && c.Pattern.MatchString(claim)
+	}
+	return claimMatches(claimValue(r, c.Key), c.Value)
+}
+
+// RequiredClaim matches when the claim equals Value or, if Values is set,
+// any one of Values. If Pattern is set the claim must be a string matching it.
+type RequiredClaim struct {
+	Key     string
+	Value   interface{}
+	Values  []interface{}
+	Pattern *regexp.Regexp
+}
+
+func (c RequiredClaim) Matches(r *http.Request) bool {
+
+	if c.Pattern != nil {
+		claim, ok := claimValue(r, c.Key).(string)
+		return ok && c.Pattern.MatchString(claim)
+	}
+
+	claim := claimValue(r, c.Key)
+
+	if c.Values == nil {
+		return claimMatches(claim, c.Value)
+	}
+
+	for _, value := range c.Values {
+		if claimMatches(claim, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func claimValue(r *http.Request, key string) interface{} {
+	if claims := ClaimsFromContext(r.Context()); claims != nil {
+		if value, ok := lookupClaim(claims, key); ok {
+			return value
+		}
+	}
+
+	if value := r.Context().Value(ContextKey(key)); value != nil {
+		return value
+	}
+
+	// Custom authorizers may still store claims under plain string keys.
+	return r.Context().Value(key)
+}
+
+// lookupClaim finds key among the claims or, failing that, follows it as a
+// dot separated path into nested claims.
+func lookupClaim(claims map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := claims[key]; ok {
+		return value, true
+	}
+
+	if !strings.Contains(key, ".") {
+		return nil, false
+	}
+
+	value := pathNode{strings.Split(key, ".")}.eval(claims)
+	return value, value != nil
+}
+
+// claimMatches reports whether claim equals value or, if claim is an array,
+// holds an item equal to value.
+func claimMatches(claim interface{}, value interface{}) bool {
+
+	if claimEquals(claim, value) {
+		return true
+	}
+
+	items := reflect.ValueOf(claim)
+	if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+		return false
+	}
+
+	for i := 0; i < items.Len(); i++ {
+		if claimEquals(items.Index(i).Interface(), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimEquals compares arrays item by item and maps key by key, so that a
+// []interface{} decoded from JSON equals a configured []string. Numbers
+// compare by value and other types fall back to reflect.DeepEqual.
+func claimEquals(a, b interface{}) bool {
+
+	if expressionEquals(a, b) {
+		return true
+	}
+
+	x, y := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !x.IsValid() || !y.IsValid() {
+		return false
+	}
+
+	switch x.Kind() {
+	case reflect.Slice, reflect.Array:
+		if y.Kind() != reflect.Slice && y.Kind() != reflect.Array || x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !claimEquals(x.Index(i).Interface(), y.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Map:
+		if y.Kind() != reflect.Map || x.Len() != y.Len() {
+			return false
+		}
+		if x.Type().Key().Kind() != reflect.String || y.Type().Key().Kind() != reflect.String {
+			return reflect.DeepEqual(a, b)
+		}
+		for iter := x.MapRange(); iter.Next(); {
+			key := reflect.ValueOf(iter.Key().String()).Convert(y.Type().Key())
+			item := y.MapIndex(key)
+			if !item.IsValid() || !claimEquals(iter.Value().Interface(), item.Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+type AudiencePolicy struct {
+	Audience          string
+	AuthorizedClaims  []AuthorizedClaim
+	ClaimMapping      map[string]string
+	ClaimTransforms   map[string]func(interface{}) interface{}
+	RequiredClaims    []RequiredClaim
+	RequiredScopes    []string
+	AuthorizedIssuers []string
+	RequiredACR       []string
+	RequiredAMR       []string
+	AllowedActors     []string
+	ClaimExpressions  []*ClaimExpression
+	PathValueClaims   []pathValueClaim
+	MaxSessionAge     time.Duration
+}
+
+// ApiKey is matched against the X-Api-Key header. Digest, the SHA-256 of the
+// key, may be given instead of Value. Claims, if set, are handled like token
+// claims.
+type ApiKey struct {
+	Value        string
+	Digest       []byte
+	Label        string
+	PathPrefixes []string
+	Claims       map[string]any
+}
+
+func (k ApiKey) digest() [sha256.Size]byte {
+	var digest [sha256.Size]byte
+	if len(k.Digest) == 0 {
+		return sha256.Sum256([]byte(k.Value))
+	}
+	copy(digest[:], k.Digest)
+	return digest
+}
+
+func (k ApiKey) Matches(r *http.Request) bool {
+	header := r.Header.Get("X-Api-Key")
+	if header == "" {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(header))
+	expected := k.digest()
+	return subtle.ConstantTimeCompare(digest[:], expected[:]) == 1
+}
+
+func (k ApiKey) Allows(urlPath string) bool {
+	if len(k.PathPrefixes) == 0 {
+		return true
+	}
+
+	cleaned := path.Clean("/" + urlPath)
+
+	for _, prefix := range k.PathPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/") || prefix == "" {
+			return true
+		}
 	}
 
-	return header == k.Value
+	return false
 }
@@ -2,19 +2,39 @@ package authorizer_test
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/golang/mock/gomock"
+	"github.com/onsi/gomega/ghttp"
 	"github.com/reverted/authorizer"
 	"github.com/reverted/authorizer/mocks"
 )
 
+type Validator interface {
+	Validate() error
+}
+
 var _ = Describe("Handler", func() {
 
 	var (
@@ -44,9 +64,37 @@ var _ = Describe("Handler", func() {
 		)
 	})
 
+	Describe("ValidateOnStartup", func() {
+		Context("when claims are required without an authorizer", func() {
+			It("fails", func() {
+				h := authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizedClaim("key", "value"),
+				)
+
+				Expect(h.ValidateOnStartup(context.Background())).To(MatchError(authorizer.ErrNoAuthorizerSet))
+			})
+		})
+
+		Context("when the authorizer supports startup validation", func() {
+			It("includes its errors", func() {
+				h := authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(authorizer.NewNotary()))),
+				)
+
+				err := h.ValidateOnStartup(context.Background())
+				Expect(err).To(MatchError(authorizer.ErrNoAudienceSet))
+				Expect(err).To(MatchError(authorizer.ErrNoTargetSet))
+			})
+		})
+	})
+
 	Describe("ServeHTTP", func() {
 		BeforeEach(func() {
-			req, err = http.NewRequest("GET", "http://localhost", nil)
+			req, err = http.NewRequest("GET", "http://localhost/some/path", nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			rec = httptest.NewRecorder()
@@ -111,13 +159,34 @@ var _ = Describe("Handler", func() {
 		})
 
 		Context("when the authorizer fails", func() {
+			var log *logger
+
 			BeforeEach(func() {
+				log = newLogger()
+
+				handler = authorizer.NewHandler(
+					log,
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+				)
+
+				req.Header.Set("Authorization", "bearer secret-token")
+				req.Header.Set("X-Request-Id", "some-request-id")
 				mockAuthorizer.EXPECT().Authorize(req).Return(errors.New("nope"))
 			})
 
 			It("responds with Unauthorized", func() {
 				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
 			})
+
+			It("logs the error with the request details", func() {
+				Expect(log.lines).To(HaveLen(1))
+				Expect(log.lines[0]).To(ContainSubstring("nope"))
+				Expect(log.lines[0]).To(ContainSubstring("method=GET"))
+				Expect(log.lines[0]).To(ContainSubstring("path=/some/path"))
+				Expect(log.lines[0]).To(ContainSubstring("request_id=some-request-id"))
+				Expect(log.lines[0]).NotTo(ContainSubstring("secret-token"))
+			})
 		})
 
 		Context("when the authorizer succeeds", func() {
@@ -136,6 +205,43 @@ var _ = Describe("Handler", func() {
 				})
 			})
 
+			Context("when the claim is a list containing the authorized value", func() {
+				BeforeEach(func() {
+					ctx := context.WithValue(context.Background(), "key", []string{"other-value", "value"})
+					*req = *req.WithContext(ctx)
+
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("succeeds", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the claim is a decoded JSON list containing the authorized value", func() {
+				BeforeEach(func() {
+					ctx := context.WithValue(context.Background(), "key", []interface{}{"value"})
+					*req = *req.WithContext(ctx)
+
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("succeeds", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the claim is a list without the authorized value", func() {
+				BeforeEach(func() {
+					ctx := context.WithValue(context.Background(), "key", []interface{}{"other-value"})
+					*req = *req.WithContext(ctx)
+				})
+
+				It("responds with Unauthorized", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+
 			Context("when the authorized claims match", func() {
 				BeforeEach(func() {
 					ctx := context.WithValue(context.Background(), "key", "value")
@@ -189,15 +295,2990 @@ var _ = Describe("Handler", func() {
 				})
 			})
 		})
-	})
-})
 
-func newLogger() *logger {
-	return &logger{}
-}
+		Context("when configured with api keys", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithApiKeys("full-key"),
+					authorizer.WithScopedApiKey("partner-key", "/webhooks/partner", "/status/"),
+				)
+			})
+
+			Context("when the api key is missing", func() {
+				It("responds with Unauthorized", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
 
-type logger struct{}
+			Context("when an unscoped api key is used", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Api-Key", "full-key")
+					req.URL.Path = "/api/users"
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
 
-func (l *logger) Error(args ...interface{}) {
-	fmt.Fprintln(GinkgoWriter, args...)
+				It("succeeds on any path", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when a scoped api key is used", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Api-Key", "partner-key")
+				})
+
+				Context("within its path prefix", func() {
+					BeforeEach(func() {
+						req.URL.Path = "/webhooks/partner/events"
+						mockHandler.EXPECT().ServeHTTP(rec, req)
+					})
+
+					It("succeeds", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("on exactly its path prefix", func() {
+					BeforeEach(func() {
+						req.URL.Path = "/status"
+						mockHandler.EXPECT().ServeHTTP(rec, req)
+					})
+
+					It("succeeds", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("outside its path prefix", func() {
+					BeforeEach(func() {
+						req.URL.Path = "/api/users"
+					})
+
+					It("responds with Forbidden", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+
+				Context("on a path that only shares a string prefix", func() {
+					BeforeEach(func() {
+						req.URL.Path = "/webhooks/partner-other"
+					})
+
+					It("responds with Forbidden", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+
+				Context("on a path that escapes its prefix", func() {
+					BeforeEach(func() {
+						req.URL.Path = "/webhooks/partner/../../api/users"
+					})
+
+					It("responds with Forbidden", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+			})
+		})
+
+		Context("when configured with audience policies", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.IncludeClaimInContextAs("iss", "issuer"),
+					authorizer.WithAudiencePolicy("api.example.com",
+						authorizer.IncludeClaimInContextAs("sub", "user"),
+					),
+					authorizer.WithAudiencePolicy("admin.example.com",
+						authorizer.WithAuthorizedClaim("role", "admin"),
+						authorizer.IncludeClaimInContextAs("sub", "admin"),
+					),
+				)
+			})
+
+			Context("when the token is for the api audience", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"iss": "some-issuer",
+						"sub": "some-user",
+						"aud": "api.example.com",
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("applies the api mappings on top of the base", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(req.Context().Value(authorizer.ContextKey("issuer"))).To(Equal("some-issuer"))
+					Expect(req.Context().Value(authorizer.ContextKey("user"))).To(Equal("some-user"))
+					Expect(req.Context().Value(authorizer.ContextKey("admin"))).To(BeNil())
+				})
+			})
+
+			Context("when the token is for the admin audience", func() {
+				Context("when the token has the admin role", func() {
+					BeforeEach(func() {
+						mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+							"sub":  "some-admin",
+							"aud":  []interface{}{"other.example.com", "admin.example.com"},
+							"role": "admin",
+						}))
+						mockHandler.EXPECT().ServeHTTP(rec, req)
+					})
+
+					It("applies the admin mappings", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+						Expect(req.Context().Value(authorizer.ContextKey("admin"))).To(Equal("some-admin"))
+						Expect(req.Context().Value(authorizer.ContextKey("user"))).To(BeNil())
+					})
+				})
+
+				Context("when the token lacks the admin role", func() {
+					BeforeEach(func() {
+						mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+							"sub": "some-admin",
+							"aud": "admin.example.com",
+						}))
+					})
+
+					It("responds with Forbidden", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+			})
+
+			Context("when the token is for neither audience", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"iss": "some-issuer",
+						"sub": "some-user",
+						"aud": "other.example.com",
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("falls back to the base policy", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(req.Context().Value(authorizer.ContextKey("issuer"))).To(Equal("some-issuer"))
+					Expect(req.Context().Value(authorizer.ContextKey("user"))).To(BeNil())
+					Expect(req.Context().Value(authorizer.ContextKey("admin"))).To(BeNil())
+				})
+			})
+		})
+
+		Context("when configured with a claim expression", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithClaimExpression(`claims.org_id == "acme" && ("admin" in claims.roles || claims.plan == "enterprise")`),
+				)
+			})
+
+			Context("when the claims satisfy the expression", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"org_id": "acme",
+						"roles":  []interface{}{"admin"},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("succeeds", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the claims do not satisfy the expression", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"org_id": "acme",
+						"plan":   "free",
+					}))
+				})
+
+				It("responds with Forbidden", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when the expression is invalid", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						newLogger(),
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithClaimExpression(`claims.org_id = "acme"`),
+					)
+				})
+
+				It("fails validation", func() {
+					Expect(handler.(Validator).Validate()).To(MatchError(ContainSubstring("position 14")))
+				})
+
+				It("rejects every request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusInternalServerError))
+				})
+			})
+		})
+
+		Context("when configured to take claims from the context", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithClaimsFromContext("upstream"),
+					authorizer.WithAuthorizedClaim("key", "value"),
+					authorizer.IncludeClaimInContext("sub"),
+				)
+			})
+
+			Context("when upstream claims match the claim rules", func() {
+				BeforeEach(func() {
+					*req = *req.WithContext(context.WithValue(req.Context(), "upstream", map[string]interface{}{
+						"key": "value",
+						"sub": "subject",
+					}))
+
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("skips the authorizer and forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(req.Context().Value(authorizer.ContextKey("sub"))).To(Equal("subject"))
+				})
+			})
+
+			Context("when typed upstream claims fail the claim rules", func() {
+				BeforeEach(func() {
+					*req = *req.WithContext(context.WithValue(req.Context(), "upstream", &authorizer.Claims{
+						Raw: map[string]interface{}{"key": "other"},
+					}))
+				})
+
+				It("skips the authorizer and rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when there are no upstream claims", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"key": "value",
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("falls back to the authorizer", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when upstream claims are required but missing", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						newLogger(),
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.RequireClaimsFromContext("upstream"),
+					)
+				})
+
+				It("rejects the request without calling the authorizer", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+		})
+
+		Context("when configured with a maximum claims size", func() {
+			var (
+				log    *logger
+				groups []interface{}
+			)
+
+			BeforeEach(func() {
+				log = newLogger()
+
+				groups = nil
+				for i := 0; i < 4000; i++ {
+					groups = append(groups, fmt.Sprintf("group-%d", i))
+				}
+
+				mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+					"sub":    "subject",
+					"key":    "value",
+					"groups": groups,
+				}))
+			})
+
+			Context("when oversized claims are truncated", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						log,
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithAuthorizedClaim("key", "value"),
+						authorizer.IncludeClaimInContext("sub"),
+						authorizer.IncludeAllClaimsInContext(),
+						authorizer.WithMaxClaimsSize(1024),
+					)
+
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("keeps only the requested claims", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(authorizer.ClaimsFromContext(req.Context())).To(Equal(map[string]interface{}{
+						"sub": "subject",
+						"key": "value",
+					}))
+					Expect(req.Context().Value(authorizer.ContextKey("sub"))).To(Equal("subject"))
+					Expect(req.Context().Value(authorizer.ContextKey("groups"))).To(BeNil())
+				})
+
+				It("logs the measured size", func() {
+					Expect(log.lines).To(ContainElement(ContainSubstring("claims too large claims_size=")))
+				})
+			})
+
+			Context("when oversized claims are rejected", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						log,
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithAuthorizedClaim("key", "value"),
+						authorizer.WithMaxClaimsSize(1024),
+						authorizer.RejectOversizedClaims(),
+					)
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(log.lines).To(ContainElement(ContainSubstring("claims too large claims_size=")))
+				})
+			})
+
+			Context("when the claims are within the limit", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						log,
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.IncludeAllClaimsInContext(),
+						authorizer.WithMaxClaimsSize(1024*1024),
+						authorizer.RejectOversizedClaims(),
+					)
+
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("includes every claim", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("groups"))).To(Equal(groups))
+					Expect(log.lines).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when step-up authentication is required", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithRequiredACR("urn:mfa"),
+					authorizer.WithRequiredAMR("mfa", "otp"),
+				)
+			})
+
+			Context("when the token has the required acr", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"acr": "urn:mfa",
+						"amr": []interface{}{"pwd"},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the token's amr contains a required method", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"acr": "urn:basic",
+						"amr": []interface{}{"pwd", "otp"},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the token has neither", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"acr": "urn:basic",
+						"amr": []interface{}{"pwd"},
+					}))
+				})
+
+				It("asks for step-up authentication", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Header().Get("WWW-Authenticate")).To(Equal(
+						`Bearer error="insufficient_authentication", error_description="step-up authentication required", acr_values="urn:mfa"`,
+					))
+				})
+			})
+
+			Context("when the token carries no acr or amr", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+		})
+
+		Context("when configured with a required claims map", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithAuthorizedClaimsMap(map[string]interface{}{
+						"aud":   "api",
+						"level": 3,
+					}),
+					authorizer.WithAuthorizedClaim("role", "admin"),
+					authorizer.WithAuthorizedClaim("role", "owner"),
+				)
+			})
+
+			Context("when the map and an authorized claim match", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"aud":   []interface{}{"api", "web"},
+						"level": float64(3),
+						"role":  "owner",
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the map matches but no authorized claim does", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"aud":   "api",
+						"level": float64(3),
+						"role":  "viewer",
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when an authorized claim matches but the map does not", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"aud":  "api",
+						"role": "admin",
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when the map has an empty key", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						newLogger(),
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithAuthorizedClaimsMap(map[string]interface{}{"": "x"}),
+					)
+				})
+
+				It("fails validation", func() {
+					Expect(handler.(Validator).Validate()).To(MatchError(authorizer.ErrEmptyClaimKey))
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusInternalServerError))
+				})
+			})
+		})
+
+		Context("when configured with nested claim paths", func() {
+			var forwarded *http.Request
+
+			BeforeEach(func() {
+				forwarded = nil
+
+				handler = authorizer.NewHandler(
+					newLogger(),
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						forwarded = r
+					}),
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithAuthorizedClaim("metadata.org_id", "acme"),
+					authorizer.IncludeClaimInContextAs("metadata.org_id", "org"),
+					authorizer.IncludeClaimInContextAs("metadata.plan.tier", "tier"),
+				)
+			})
+
+			Context("when the nested claim matches", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"metadata": map[string]interface{}{
+							"org_id": "acme",
+							"plan":   map[string]interface{}{"tier": "gold"},
+						},
+					}))
+				})
+
+				It("forwards the request with the nested values in the context", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(forwarded.Context().Value(authorizer.ContextKey("org"))).To(Equal("acme"))
+					Expect(forwarded.Context().Value(authorizer.ContextKey("tier"))).To(Equal("gold"))
+				})
+			})
+
+			Context("when an intermediate key is missing", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"metadata": "acme",
+					}))
+				})
+
+				It("treats the claim as missing", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when a literal key contains the dots", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"metadata.org_id": "acme",
+						"metadata":        map[string]interface{}{"org_id": "globex"},
+					}))
+				})
+
+				It("prefers the literal key", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					Expect(forwarded.Context().Value(authorizer.ContextKey("org"))).To(Equal("acme"))
+					Expect(forwarded.Context().Value(authorizer.ContextKey("tier"))).To(BeNil())
+				})
+			})
+		})
+
+		Context("when configured with authorized roles", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithAuthorizedRole("realm_access.roles", "admin", "operator"),
+					authorizer.WithAuthorizedRole("https://example.com/roles", "auditor"),
+				)
+			})
+
+			Context("when a nested role array holds a role", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"realm_access": map[string]interface{}{
+							"roles": []interface{}{"offline_access", "operator"},
+						},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when a namespaced role array holds a role", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"https://example.com/roles": []interface{}{"auditor"},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the role claim is a plain string", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"realm_access": map[string]interface{}{"roles": "admin"},
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when no role matches", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"realm_access": map[string]interface{}{
+							"roles": []interface{}{"offline_access"},
+						},
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when the role claim is missing", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"realm_access": "admin",
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+		})
+
+		Context("when configured with required claims", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithRequiredClaims(
+						authorizer.AuthorizedClaim{Key: "env", Value: "prod"},
+						authorizer.AuthorizedClaim{Key: "role", Value: "operator"},
+					),
+				)
+			})
+
+			Context("when every required claim matches", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"env":  "prod",
+						"role": "operator",
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when only some required claims match", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"env":  "staging",
+						"role": "operator",
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when combined with authorized claims", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						newLogger(),
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithRequiredClaims(authorizer.AuthorizedClaim{Key: "env", Value: "prod"}),
+						authorizer.WithAuthorizedClaim("team", "payments"),
+						authorizer.WithAuthorizedClaim("team", "billing"),
+					)
+				})
+
+				Context("when the required claims and one authorized claim match", func() {
+					BeforeEach(func() {
+						mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+							"env":  "prod",
+							"team": "billing",
+						}))
+						mockHandler.EXPECT().ServeHTTP(rec, req)
+					})
+
+					It("forwards the request", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("when an authorized claim matches but a required claim does not", func() {
+					BeforeEach(func() {
+						mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+							"env":  "staging",
+							"team": "billing",
+						}))
+					})
+
+					It("rejects the request", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+
+				Context("when the required claims match but no authorized claim does", func() {
+					BeforeEach(func() {
+						mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+							"env":  "prod",
+							"team": "support",
+						}))
+					})
+
+					It("rejects the request", func() {
+						Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+					})
+				})
+			})
+
+			Context("when a required claim has an empty key", func() {
+				BeforeEach(func() {
+					handler = authorizer.NewHandler(
+						newLogger(),
+						mockHandler,
+						authorizer.WithAuthorizer(mockAuthorizer),
+						authorizer.WithRequiredClaims(authorizer.AuthorizedClaim{Value: "prod"}),
+					)
+				})
+
+				It("fails validation", func() {
+					Expect(handler.(Validator).Validate()).To(MatchError(authorizer.ErrEmptyClaimKey))
+				})
+			})
+		})
+
+		Context("when configured with a set of claim values", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithAuthorizedClaimValues("tenant", "acme", "globex", "initech"),
+					authorizer.WithAuthorizedClaimValues("tier", 1, 2),
+				)
+			})
+
+			Context("when the claims are among the values", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"tenant": "globex",
+						"tier":   float64(1),
+					}))
+					mockHandler.EXPECT().ServeHTTP(rec, req)
+				})
+
+				It("forwards the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when a claim is not among the values", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"tenant": "globex",
+						"tier":   float64(3),
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when a claim is missing", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"tier": float64(2),
+					}))
+				})
+
+				It("rejects the request", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+		})
+
+		Context("when configured with a login redirect", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithLoginRedirect("https://login.example.com/login?app=admin"),
+				)
+
+				mockAuthorizer.EXPECT().Authorize(req).Return(authorizer.ErrMissingAuthorizationHeader)
+			})
+
+			Context("when a browser navigates without credentials", func() {
+				BeforeEach(func() {
+					req.URL.RawQuery = "tab=users"
+					req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+				})
+
+				It("redirects to the login page", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusFound))
+
+					location, err := url.Parse(rec.Header().Get("Location"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(location.Host).To(Equal("login.example.com"))
+					Expect(location.Query().Get("app")).To(Equal("admin"))
+					Expect(location.Query().Get("return_to")).To(Equal("/some/path?tab=users"))
+				})
+			})
+
+			Context("when an api client is rejected", func() {
+				BeforeEach(func() {
+					req.Header.Set("Accept", "application/json")
+				})
+
+				It("responds with 401", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Header().Get("Location")).To(BeEmpty())
+				})
+			})
+
+			Context("when a browser fetches a resource in the background", func() {
+				BeforeEach(func() {
+					req.Header.Set("Accept", "text/html")
+					req.Header.Set("Sec-Fetch-Mode", "cors")
+				})
+
+				It("responds with 401", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+
+			Context("when the requested path points at another origin", func() {
+				BeforeEach(func() {
+					req.URL.Path = "//evil.example.com/phish"
+					req.Header.Set("Accept", "text/html")
+				})
+
+				It("does not return to it", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusFound))
+
+					location, err := url.Parse(rec.Header().Get("Location"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(location.Query().Get("return_to")).To(Equal("/"))
+				})
+			})
+		})
+
+		Context("when tokens are accepted for a deprecated audience", func() {
+			var log *logger
+
+			BeforeEach(func() {
+				log = newLogger()
+
+				handler = authorizer.NewHandler(
+					log,
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+				)
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).DoAndReturn(func(r *http.Request) error {
+					*r = *r.WithContext(authorizer.ContextWithTypedClaims(r.Context(), &authorizer.Claims{
+						DeprecatedAudience: "api://old",
+					}))
+					return nil
+				}).Times(3)
+				mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(3)
+			})
+
+			It("logs a rate limited warning", func() {
+				for i := 0; i < 2; i++ {
+					r, err := http.NewRequest("GET", "http://localhost/some/path", nil)
+					Expect(err).NotTo(HaveOccurred())
+					handler.ServeHTTP(httptest.NewRecorder(), r)
+				}
+
+				Expect(log.lines).To(HaveLen(1))
+				Expect(log.lines[0]).To(ContainSubstring("deprecated audience audience=api://old"))
+			})
+		})
+
+		Context("when configured with an unauthorized responder", func() {
+			var responded error
+
+			BeforeEach(func() {
+				responded = nil
+
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithApiKeys("key"),
+					authorizer.WithAuthorizedClaim("role", "admin"),
+					authorizer.WithUnauthorizedResponder(func(w http.ResponseWriter, r *http.Request, err error) {
+						responded = err
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusUnauthorized)
+						fmt.Fprint(w, `{"error":"unauthorized"}`)
+					}),
+				)
+			})
+
+			Context("when the api key is rejected", func() {
+				It("calls the responder", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Body.String()).To(Equal(`{"error":"unauthorized"}`))
+					Expect(responded).To(MatchError(authorizer.ErrApiKeyRequired))
+				})
+			})
+
+			Context("when the authorizer fails", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Api-Key", "key")
+					mockAuthorizer.EXPECT().Authorize(req).Return(authorizer.ErrInvalidSignature)
+				})
+
+				It("calls the responder with the authorizer error", func() {
+					Expect(rec.Body.String()).To(Equal(`{"error":"unauthorized"}`))
+					Expect(responded).To(MatchError(authorizer.ErrCredentialsRejected))
+					Expect(responded).To(MatchError(authorizer.ErrInvalidSignature))
+				})
+			})
+
+			Context("when the claims do not match", func() {
+				BeforeEach(func() {
+					req.Header.Set("X-Api-Key", "key")
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"role": "viewer",
+					}))
+				})
+
+				It("calls the responder", func() {
+					Expect(rec.Body.String()).To(Equal(`{"error":"unauthorized"}`))
+					Expect(responded).To(MatchError(authorizer.ErrClaimsNotAuthorized))
+				})
+			})
+		})
+
+		Context("when configured to include the token ttl", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.IncludeTokenTTLInContext("ttl"),
+				)
+
+				mockHandler.EXPECT().ServeHTTP(rec, req)
+			})
+
+			Context("when the claims carry a time to expiry", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(func(r *http.Request) error {
+						*r = *r.WithContext(authorizer.ContextWithTypedClaims(r.Context(), &authorizer.Claims{
+							TimeToExpiry: 5 * time.Minute,
+						}))
+						return nil
+					})
+				})
+
+				It("includes the ttl in the context", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("ttl"))).To(Equal(5 * time.Minute))
+				})
+			})
+
+			Context("when the claims carry no time to expiry", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{}))
+				})
+
+				It("does not include a ttl", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("ttl"))).To(BeNil())
+				})
+			})
+		})
+
+		Context("when configured to include token dates", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.IncludeExpirationInContext(),
+					authorizer.IncludeIssuedAtInContext(),
+					authorizer.IncludeNotBeforeInContextAs("valid_from"),
+				)
+
+				mockHandler.EXPECT().ServeHTTP(rec, req)
+			})
+
+			Context("when the dates are decoded as float64", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"exp": float64(1700000600),
+						"iat": float64(1700000000.5),
+						"nbf": float64(1700000000),
+					}))
+				})
+
+				It("includes the dates as times", func() {
+					exp, ok := authorizer.ExpirationFromContext(req.Context())
+					Expect(ok).To(BeTrue())
+					Expect(exp).To(Equal(time.Unix(1700000600, 0)))
+
+					iat, ok := authorizer.IssuedAtFromContext(req.Context())
+					Expect(ok).To(BeTrue())
+					Expect(iat).To(Equal(time.Unix(1700000000, int64(500*time.Millisecond))))
+
+					Expect(req.Context().Value(authorizer.ContextKey("valid_from"))).To(Equal(time.Unix(1700000000, 0)))
+				})
+			})
+
+			Context("when the dates are decoded as json numbers", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"exp": json.Number("1700000600"),
+						"iat": json.Number("1700000000"),
+						"nbf": json.Number("1700000000"),
+					}))
+				})
+
+				It("includes the dates as times", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("exp"))).To(Equal(time.Unix(1700000600, 0)))
+					Expect(req.Context().Value(authorizer.ContextKey("iat"))).To(Equal(time.Unix(1700000000, 0)))
+					Expect(req.Context().Value(authorizer.ContextKey("valid_from"))).To(Equal(time.Unix(1700000000, 0)))
+				})
+			})
+
+			Context("when the dates are integers", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"exp": int64(1700000600),
+						"iat": 1700000000,
+						"nbf": int64(1700000000),
+					}))
+				})
+
+				It("includes the dates as times", func() {
+					Expect(req.Context().Value(authorizer.ContextKey("exp"))).To(Equal(time.Unix(1700000600, 0)))
+					Expect(req.Context().Value(authorizer.ContextKey("iat"))).To(Equal(time.Unix(1700000000, 0)))
+					Expect(req.Context().Value(authorizer.ContextKey("valid_from"))).To(Equal(time.Unix(1700000000, 0)))
+				})
+			})
+
+			Context("when the dates are missing or malformed", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+						"exp": "tomorrow",
+					}))
+				})
+
+				It("does not include the dates", func() {
+					_, ok := authorizer.ExpirationFromContext(req.Context())
+					Expect(ok).To(BeFalse())
+
+					_, ok = authorizer.IssuedAtFromContext(req.Context())
+					Expect(ok).To(BeFalse())
+
+					_, ok = authorizer.NotBeforeFromContext(req.Context())
+					Expect(ok).To(BeFalse())
+				})
+			})
+		})
+
+		Context("when configured with an expiry retry hint", func() {
+			BeforeEach(func() {
+				handler = authorizer.NewHandler(
+					newLogger(),
+					mockHandler,
+					authorizer.WithAuthorizer(mockAuthorizer),
+					authorizer.WithExpiryRetryHint(5*time.Second),
+				)
+			})
+
+			Context("when the token has only just expired", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).Return(&authorizer.TokenExpiredError{
+						Expiry: time.Now().Add(-time.Second),
+					})
+				})
+
+				It("responds with a retry hint", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+					Expect(rec.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="invalid_token", error_description="token expired"`))
+				})
+			})
+
+			Context("when the token expired long ago", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).Return(&authorizer.TokenExpiredError{
+						Expiry: time.Now().Add(-time.Hour),
+					})
+				})
+
+				It("responds without a retry hint", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Header().Get("Retry-After")).To(BeEmpty())
+					Expect(rec.Header().Get("WWW-Authenticate")).To(BeEmpty())
+				})
+			})
+
+			Context("when the authorizer fails for another reason", func() {
+				BeforeEach(func() {
+					mockAuthorizer.EXPECT().Authorize(req).Return(authorizer.ErrInvalidSignature)
+				})
+
+				It("responds without a retry hint", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(rec.Header().Get("Retry-After")).To(BeEmpty())
+				})
+			})
+		})
+	})
+})
+
+var _ = Describe("WithExpiryRetryHint", func() {
+
+	var (
+		server     *ghttp.Server
+		privateKey *rsa.PrivateKey
+		handler    http.Handler
+		rec        *httptest.ResponseRecorder
+	)
+
+	serve := func(expiry time.Time) {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(privateKey, jwt.Claims{
+			Subject:  "subject",
+			Audience: jwt.Audience{"audience"},
+			Expiry:   jwt.NewNumericDate(expiry),
+		}))
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+	}
+
+	BeforeEach(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		server = ghttp.NewServer()
+		server.RouteToHandler("GET", "/token_keys", ghttp.RespondWithJSONEncoded(http.StatusOK, jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{KeyID: "some-key", Algorithm: string(jose.RS256), Key: &privateKey.PublicKey}},
+		}))
+
+		handler = authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithLeeway(time.Minute),
+			)))),
+			authorizer.WithExpiryRetryHint(5*time.Second),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("measures from the end of the leeway", func() {
+		serve(time.Now().Add(-time.Minute - 2*time.Second))
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+	})
+
+	It("gives no hint once the leeway is long past", func() {
+		serve(time.Now().Add(-2 * time.Minute))
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get("Retry-After")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("WithExcludedPaths", func() {
+
+	var (
+		handler http.Handler
+		claims  map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		claims = nil
+
+		handler = authorizer.NewHandler(
+			newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims = authorizer.ClaimsFromContext(r.Context())
+			}),
+			authorizer.WithApiKeys("key"),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"sub": "some-user",
+			}))),
+			authorizer.WithExcludedPaths("/healthz", "/.well-known/*"),
+		)
+	})
+
+	serve := func(target string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code
+	}
+
+	It("forwards exact matches without credentials or claims", func() {
+		Expect(serve("http://localhost/healthz")).To(Equal(http.StatusOK))
+		Expect(claims).To(BeNil())
+	})
+
+	It("forwards paths beneath a prefix pattern", func() {
+		Expect(serve("http://localhost/.well-known/jwks.json")).To(Equal(http.StatusOK))
+		Expect(claims).To(BeNil())
+	})
+
+	It("authorizes other paths", func() {
+		Expect(serve("http://localhost/healthz/details")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("http://localhost/.well-known")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("http://localhost/admin")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("does not let dot segments escape a prefix", func() {
+		Expect(serve("http://localhost/.well-known/../admin")).To(Equal(http.StatusUnauthorized))
+	})
+})
+
+var _ = Describe("AllowPreflight", func() {
+
+	var (
+		req     *http.Request
+		rec     *httptest.ResponseRecorder
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("OPTIONS", "http://localhost/some/path", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec = httptest.NewRecorder()
+
+		handler = authorizer.NewHandler(
+			newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+			authorizer.WithApiKeys("key"),
+			authorizer.WithBasicAuthCredential("user", "pass"),
+			authorizer.WithAuthorizer(authorizer.New()),
+			authorizer.AllowPreflight(),
+		)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(rec, req)
+	})
+
+	Context("when the request is a preflight", func() {
+		BeforeEach(func() {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		})
+
+		It("forwards it without credentials", func() {
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+		})
+	})
+
+	Context("when the request is a plain OPTIONS request", func() {
+		It("authorizes it", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when another method carries the preflight header", func() {
+		BeforeEach(func() {
+			req.Method = http.MethodDelete
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+		})
+
+		It("authorizes it", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+var _ = Describe("RequireApiKeyAndToken", func() {
+
+	var (
+		req     *http.Request
+		rec     *httptest.ResponseRecorder
+		handler http.Handler
+
+		forwarded *http.Request
+	)
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost/some/path", nil)
+		rec = httptest.NewRecorder()
+		forwarded = nil
+
+		handler = authorizer.NewHandler(
+			newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithLabeledApiKey("partner", "partner-key"),
+			authorizer.WithBasicAuthCredential("user", "pass"),
+			authorizer.WithAuthorizedTokens("service-token"),
+			authorizer.WithAuthorizer(authorizerFunc(func(r *http.Request) error {
+				if r.Header.Get("Authorization") != "Bearer user-token" {
+					return authorizer.ErrInvalidSignature
+				}
+				return authorizeWithClaims(map[string]interface{}{"sub": "some-user", "role": "admin"})(r)
+			})),
+			authorizer.IncludeClaimInContextAs("sub", "user"),
+			authorizer.WithAuthorizedClaim("role", "admin"),
+			authorizer.RequireApiKeyAndToken(),
+		)
+	})
+
+	JustBeforeEach(func() {
+		handler.ServeHTTP(rec, req)
+	})
+
+	Context("when neither is presented", func() {
+		It("responds with Unauthorized", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when only the token is presented", func() {
+		BeforeEach(func() {
+			req.Header.Set("Authorization", "Bearer user-token")
+		})
+
+		It("responds with Unauthorized", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when only the api key is presented", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "partner-key")
+		})
+
+		It("responds with Unauthorized", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when the api key comes with basic auth credentials instead of a token", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "partner-key")
+			req.SetBasicAuth("user", "pass")
+		})
+
+		It("responds with Unauthorized", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when only a static token is presented", func() {
+		BeforeEach(func() {
+			req.Header.Set("Authorization", "Bearer service-token")
+		})
+
+		It("responds with Unauthorized", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+	})
+
+	Context("when the api key comes with a static token", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "partner-key")
+			req.Header.Set("Authorization", "Bearer service-token")
+		})
+
+		It("forwards the request", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(authorizer.MechanismFromContext(forwarded.Context())).To(Equal(authorizer.MechanismStaticToken))
+			Expect(authorizer.ApiKeyLabelFromContext(forwarded.Context())).To(Equal("partner"))
+		})
+	})
+
+	Context("when both are presented", func() {
+		BeforeEach(func() {
+			req.Header.Set("X-Api-Key", "partner-key")
+			req.Header.Set("Authorization", "Bearer user-token")
+		})
+
+		It("forwards the request with the key label and the claims", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(forwarded).NotTo(BeNil())
+			Expect(authorizer.ApiKeyLabelFromContext(forwarded.Context())).To(Equal("partner"))
+			Expect(forwarded.Context().Value(authorizer.ContextKey("user"))).To(Equal("some-user"))
+			Expect(authorizer.MatchedRuleFromContext(forwarded.Context())).To(Equal("role=admin"))
+		})
+	})
+
+	It("needs an authorizer or static tokens", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithApiKeys("key"),
+			authorizer.RequireApiKeyAndToken(),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrContradictoryConfiguration))
+
+		h = authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithApiKeys("key"),
+			authorizer.WithAuthorizedTokens("token"),
+			authorizer.RequireApiKeyAndToken(),
+		)
+
+		Expect(h.Validate()).To(Succeed())
+	})
+})
+
+var _ = Describe("WithHashedApiKeys", func() {
+
+	var handler http.Handler
+
+	digest := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	serve := func(key string) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", key)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		handler = authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithApiKeys("plain-key"),
+			authorizer.WithHashedApiKeys(digest("hashed-key")),
+		)
+	})
+
+	It("accepts keys matching a digest", func() {
+		Expect(serve("hashed-key")).To(Equal(http.StatusOK))
+	})
+
+	It("accepts plaintext keys alongside", func() {
+		Expect(serve("plain-key")).To(Equal(http.StatusOK))
+	})
+
+	It("rejects other keys, including the digest itself", func() {
+		Expect(serve("other-key")).To(Equal(http.StatusUnauthorized))
+		Expect(serve(digest("hashed-key"))).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("reports digests that are not hex", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithHashedApiKeys("not-hex"),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrInvalidApiKeyDigest))
+	})
+
+	It("reports digests of the wrong length", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithHashedApiKeys("abcd"),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrInvalidApiKeyDigest))
+	})
+
+	It("matches requests with ApiKey.Matches", func() {
+		sum := sha256.Sum256([]byte("hashed-key"))
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", "hashed-key")
+
+		Expect(authorizer.ApiKey{Digest: sum[:]}.Matches(req)).To(BeTrue())
+	})
+})
+
+var _ = Describe("WithBasicAuthCredentialHash", func() {
+
+	// The bcrypt hash of "secret-pass" at the minimum cost.
+	const hash = "$2a$04$YRiQglAzGaHzUZqNnR88reTo9OFmvzoClOJ4OwWQLUx/.zR7hLImq"
+
+	var handler http.Handler
+
+	serve := func(user, pass string) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.SetBasicAuth(user, pass)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		handler = authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithBasicAuthCredentialHash("user", hash),
+			authorizer.WithBasicAuthCredential("user", "fallback-pass"),
+		)
+	})
+
+	It("accepts the hashed password", func() {
+		Expect(serve("user", "secret-pass")).To(Equal(http.StatusOK))
+	})
+
+	It("falls through to the next credential on a wrong password", func() {
+		Expect(serve("user", "fallback-pass")).To(Equal(http.StatusOK))
+	})
+
+	It("rejects other passwords and users", func() {
+		Expect(serve("user", "wrong-pass")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("other", "secret-pass")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("user", hash)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("compares plain passwords that look like hashes as they are", func() {
+		handler = authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithBasicAuthCredential("user", hash),
+		)
+
+		Expect(serve("user", hash)).To(Equal(http.StatusOK))
+		Expect(serve("user", "secret-pass")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("reports values that are not bcrypt hashes", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithBasicAuthCredentialHash("user", "plain-pass"),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrInvalidPasswordHash))
+	})
+})
+
+var _ = Describe("Credential validators", func() {
+
+	type ctxKey struct{}
+
+	var (
+		req *http.Request
+		rec *httptest.ResponseRecorder
+
+		forwarded *http.Request
+		validated context.Context
+	)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+		req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+		rec = httptest.NewRecorder()
+
+		forwarded = nil
+		validated = nil
+	})
+
+	Describe("WithBasicAuthValidator", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(newLogger(), next,
+				authorizer.WithBasicAuthCredential("static", "static-pass"),
+				authorizer.WithBasicAuthValidator(func(ctx context.Context, user, pass string) (map[string]any, bool) {
+					validated = ctx
+					if user == "rotating" && pass == "current-pass" {
+						return map[string]any{"sub": "rotating-user"}, true
+					}
+					return nil, false
+				}),
+				authorizer.IncludeClaimInContextAs("sub", "user"),
+			)
+		})
+
+		It("authorizes credentials the validator accepts and maps its claims", func() {
+			req.SetBasicAuth("rotating", "current-pass")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(forwarded.Context().Value(authorizer.ContextKey("user"))).To(Equal("rotating-user"))
+			Expect(authorizer.MechanismFromContext(forwarded.Context())).To(Equal(authorizer.MechanismBasicAuth))
+			Expect(validated.Value(ctxKey{})).To(Equal("request"))
+		})
+
+		It("rejects credentials the validator refuses", func() {
+			req.SetBasicAuth("rotating", "old-pass")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+
+		It("checks the static credentials first", func() {
+			req.SetBasicAuth("static", "static-pass")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(validated).To(BeNil())
+		})
+
+		It("holds the validator's claims to the claim requirements", func() {
+			validator := authorizer.WithBasicAuthValidator(func(ctx context.Context, user, pass string) (map[string]any, bool) {
+				return map[string]any{"sub": user}, true
+			})
+
+			handler = authorizer.NewHandler(newLogger(), next, validator,
+				authorizer.WithRequiredClaims(authorizer.AuthorizedClaim{Key: "role", Value: "admin"}),
+			)
+			req.SetBasicAuth("rotating", "current-pass")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(forwarded).To(BeNil())
+
+			rec = httptest.NewRecorder()
+			handler = authorizer.NewHandler(newLogger(), next, validator,
+				authorizer.WithAuthorizedClaim("sub", "rotating"),
+			)
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(authorizer.MatchedRuleFromContext(forwarded.Context())).To(Equal("sub=rotating"))
+		})
+	})
+
+	Describe("WithApiKeyValidator", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(newLogger(), next,
+				authorizer.WithApiKeys("static-key"),
+				authorizer.WithApiKeyValidator(func(ctx context.Context, key string) (map[string]any, bool) {
+					validated = ctx
+					if key == "rotating-key" {
+						return map[string]any{"tenant": "acme"}, true
+					}
+					return nil, false
+				}),
+				authorizer.IncludeClaimInContext("tenant"),
+				authorizer.WithAuthorizedClaim("tenant", "acme"),
+			)
+		})
+
+		It("authorizes keys the validator accepts and evaluates its claims", func() {
+			req.Header.Set("X-Api-Key", "rotating-key")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(forwarded.Context().Value(authorizer.ContextKey("tenant"))).To(Equal("acme"))
+			Expect(authorizer.MechanismFromContext(forwarded.Context())).To(Equal(authorizer.MechanismApiKey))
+			Expect(validated.Value(ctxKey{})).To(Equal("request"))
+		})
+
+		It("rejects keys the validator refuses", func() {
+			req.Header.Set("X-Api-Key", "revoked-key")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(forwarded).To(BeNil())
+		})
+
+		It("does not consult the validator without a key", func() {
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(validated).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Claim value matching", func() {
+
+	decode := func(data string, useNumber bool) map[string]interface{} {
+		decoder := json.NewDecoder(strings.NewReader(data))
+		if useNumber {
+			decoder.UseNumber()
+		}
+
+		claims := map[string]interface{}{}
+		Expect(decoder.Decode(&claims)).To(Succeed())
+		return claims
+	}
+
+	serve := func(claims map[string]interface{}, key string, value interface{}) int {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithAuthorizedClaim(key, value),
+		)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	DescribeTable("matches",
+		func(claims map[string]interface{}, key string, value interface{}) {
+			Expect(serve(claims, key, value)).To(Equal(http.StatusOK))
+		},
+		Entry("an int against a decoded integer", decode(`{"tenant_id": 42}`, false), "tenant_id", 42),
+		Entry("an int against a decoded float", decode(`{"tenant_id": 42.0}`, false), "tenant_id", 42),
+		Entry("an int against a json.Number", decode(`{"tenant_id": 42}`, true), "tenant_id", 42),
+		Entry("an int64 against a decoded integer", decode(`{"tenant_id": 42}`, false), "tenant_id", int64(42)),
+		Entry("a float against a json.Number", decode(`{"tenant_id": 42}`, true), "tenant_id", 42.0),
+		Entry("an int in a decoded array", decode(`{"tenants": [7, 42]}`, false), "tenants", 42),
+		Entry("a bool", decode(`{"verified": true}`, false), "verified", true),
+		Entry("a string", decode(`{"org": "acme"}`, false), "org", "acme"),
+		Entry("a string in a decoded array", decode(`{"aud": ["api", "web"]}`, false), "aud", "api"),
+		Entry("a whole array", decode(`{"aud": ["api", "web"]}`, false), "aud", []interface{}{"api", "web"}),
+		Entry("a whole array as strings", decode(`{"aud": ["api", "web"]}`, false), "aud", []string{"api", "web"}),
+		Entry("an array of mixed types", decode(`{"tags": ["a", 1, true]}`, false), "tags", []interface{}{"a", 1, true}),
+		Entry("an array within an array", decode(`{"pairs": [["a", 1], ["b", 2]]}`, false), "pairs", []interface{}{"b", 2}),
+		Entry("a nested map", decode(`{"org": {"id": 7, "name": "acme", "tags": ["x"]}}`, true), "org", map[string]interface{}{"id": 7, "name": "acme", "tags": []string{"x"}}),
+		Entry("a map of strings", decode(`{"org": {"name": "acme"}}`, false), "org", map[string]string{"name": "acme"}),
+	)
+
+	DescribeTable("does not match",
+		func(claims map[string]interface{}, key string, value interface{}) {
+			Expect(serve(claims, key, value)).To(Equal(http.StatusForbidden))
+		},
+		Entry("a different number", decode(`{"tenant_id": 43}`, false), "tenant_id", 42),
+		Entry("a number against its string form", decode(`{"tenant_id": "42"}`, false), "tenant_id", 42),
+		Entry("a bool against its string form", decode(`{"verified": "true"}`, false), "verified", true),
+		Entry("a false bool", decode(`{"verified": false}`, false), "verified", true),
+		Entry("an array in another order", decode(`{"aud": ["api", "web"]}`, false), "aud", []interface{}{"web", "api"}),
+		Entry("a shorter array", decode(`{"aud": ["api", "web"]}`, false), "aud", []interface{}{"api"}),
+		Entry("an array against a string", decode(`{"aud": "api"}`, false), "aud", []interface{}{"api"}),
+		Entry("a map with another value", decode(`{"org": {"id": 7}}`, false), "org", map[string]interface{}{"id": 8}),
+		Entry("a map with another key", decode(`{"org": {"id": 7}}`, false), "org", map[string]interface{}{"key": 7}),
+		Entry("a map against an array", decode(`{"org": {"id": 7}}`, false), "org", []interface{}{7}),
+		Entry("a map with non-string keys", decode(`{"org": {"7": 7}}`, false), "org", map[int]interface{}{7: 7}),
+	)
+})
+
+var _ = Describe("Default authorizer", func() {
+
+	serve := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	It("allows everything when nothing is configured", func() {
+		Expect(serve(authorizer.NewHandler(newLogger(), ok))).To(Equal(http.StatusOK))
+	})
+
+	It("denies everything with DenyAllAuthorizer", func() {
+		h := authorizer.NewHandler(newLogger(), ok, authorizer.WithAuthorizer(authorizer.DenyAllAuthorizer()))
+
+		Expect(h.Validate()).To(Succeed())
+		Expect(serve(h)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("still admits other credentials with DenyAllAuthorizer", func() {
+		h := authorizer.NewHandler(newLogger(), ok,
+			authorizer.WithAuthorizer(authorizer.DenyAllAuthorizer()),
+			authorizer.WithAuthorizedTokens("some-token"),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer some-token")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	Describe("RequireExplicitAuthorizer", func() {
+		It("fails validation when claims are required without an authorizer", func() {
+			h := authorizer.NewHandler(newLogger(), ok,
+				authorizer.WithAuthorizedSubjects("some-user"),
+				authorizer.RequireExplicitAuthorizer(),
+			)
+
+			Expect(h.Validate()).To(MatchError(authorizer.ErrNoAuthorizerSet))
+			Expect(serve(h)).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("fails validation when tokens are configured without an authorizer", func() {
+			h := authorizer.NewHandler(newLogger(), ok,
+				authorizer.WithAuthorizedTokens("some-token"),
+				authorizer.RequireExplicitAuthorizer(),
+			)
+
+			Expect(h.Validate()).To(MatchError(authorizer.ErrNoAuthorizerSet))
+		})
+
+		It("passes with an authorizer", func() {
+			h := authorizer.NewHandler(newLogger(), ok,
+				authorizer.WithAuthorizer(authorizer.DenyAllAuthorizer()),
+				authorizer.WithAuthorizedSubjects("some-user"),
+				authorizer.RequireExplicitAuthorizer(),
+			)
+
+			Expect(h.Validate()).To(Succeed())
+		})
+
+		It("passes when claims come from upstream", func() {
+			h := authorizer.NewHandler(newLogger(), ok,
+				authorizer.WithClaimsFromContext("claims"),
+				authorizer.WithAuthorizedSubjects("some-user"),
+				authorizer.RequireExplicitAuthorizer(),
+			)
+
+			Expect(h.Validate()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("AllowAnonymous", func() {
+
+	var (
+		forwarded context.Context
+		h         http.Handler
+	)
+
+	serve := func(header string) int {
+		forwarded = nil
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		h = authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Context()
+			}),
+			authorizer.WithAuthorizer(authorizer.New(
+				authorizer.WithNotary(notaryFunc(func(token string) (map[string]interface{}, error) {
+					if token != "good-token" {
+						return nil, errors.New("bad token")
+					}
+					return map[string]interface{}{"sub": "some-user"}, nil
+				})),
+			)),
+			authorizer.WithAuthorizedSubjects("some-user"),
+			authorizer.AllowAnonymous(),
+		)
+	})
+
+	It("forwards requests without credentials anonymously", func() {
+		Expect(serve("")).To(Equal(http.StatusOK))
+		Expect(authorizer.Authenticated(forwarded)).To(BeFalse())
+		Expect(authorizer.ClaimsFromContext(forwarded)).To(BeNil())
+		Expect(authorizer.MechanismFromContext(forwarded)).To(Equal(authorizer.MechanismAnonymous))
+	})
+
+	It("populates the context for a valid token", func() {
+		Expect(serve("Bearer good-token")).To(Equal(http.StatusOK))
+		Expect(authorizer.Authenticated(forwarded)).To(BeTrue())
+		Expect(authorizer.ClaimsFromContext(forwarded)).To(HaveKeyWithValue("sub", "some-user"))
+	})
+
+	It("rejects an invalid token", func() {
+		Expect(serve("Bearer bad-token")).To(Equal(http.StatusUnauthorized))
+		Expect(forwarded).To(BeNil())
+	})
+})
+
+var _ = Describe("StripCredentials", func() {
+
+	var forwarded *http.Request
+
+	serve := func(req *http.Request, strip bool) {
+		forwarded = nil
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r
+		})
+		auth := authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{"sub": "some-user"})))
+
+		h := authorizer.NewHandler(newLogger(), next, auth, authorizer.IncludeTokenInContext())
+		if strip {
+			h = authorizer.NewHandler(newLogger(), next, auth, authorizer.IncludeTokenInContext(), authorizer.StripCredentials())
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer some-token")
+		req.Header.Set("X-Api-Key", "some-key")
+		req.Header.Set("X-Request-Id", "some-request-id")
+		return req
+	}
+
+	It("removes credentials but keeps the claims", func() {
+		req := newRequest()
+		serve(req, true)
+
+		Expect(forwarded).NotTo(BeNil())
+		Expect(forwarded.Header.Get("Authorization")).To(BeEmpty())
+		Expect(forwarded.Header.Get("X-Api-Key")).To(BeEmpty())
+		Expect(forwarded.Header.Get("X-Request-Id")).To(Equal("some-request-id"))
+
+		Expect(authorizer.ClaimsFromContext(forwarded.Context())).To(HaveKeyWithValue("sub", "some-user"))
+		token, ok := authorizer.TokenFromContext(forwarded.Context())
+		Expect(ok).To(BeTrue())
+		Expect(token).To(Equal("some-token"))
+	})
+
+	It("removes basic auth", func() {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.SetBasicAuth("user", "pass")
+		serve(req, true)
+
+		_, _, ok := forwarded.BasicAuth()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("removes tokens read from other headers", func() {
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithAuthorizer(authorizer.New(
+				authorizer.WithNotary(notaryFunc(func(string) (map[string]interface{}, error) {
+					return map[string]interface{}{"sub": "some-user"}, nil
+				})),
+				authorizer.WithBase64HeaderToken("X-Grpc-Web-Authorization"),
+			)),
+			authorizer.StripCredentials(),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Grpc-Web-Authorization", base64.RawURLEncoding.EncodeToString([]byte("some-token")))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(forwarded).NotTo(BeNil())
+		Expect(forwarded.Header.Get("X-Grpc-Web-Authorization")).To(BeEmpty())
+	})
+
+	It("removes the session cookie but keeps other cookies", func() {
+		secret := []byte("some-secret")
+
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithAuthorizer(authorizer.NewSessionAuthorizer(
+				authorizer.WithSessionCookie("sid"),
+				authorizer.WithSessionSecret(secret),
+			)),
+			authorizer.StripCredentials(),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		req.AddCookie(&http.Cookie{Name: "sid", Value: signSession(secret, map[string]interface{}{"sub": "some-user"})})
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(forwarded).NotTo(BeNil())
+		_, err := forwarded.Cookie("sid")
+		Expect(err).To(MatchError(http.ErrNoCookie))
+		Expect(forwarded.Cookie("theme")).To(HaveField("Value", "dark"))
+	})
+
+	It("forwards credentials by default", func() {
+		serve(newRequest(), false)
+
+		Expect(forwarded.Header.Get("Authorization")).To(Equal("Bearer some-token"))
+		Expect(forwarded.Header.Get("X-Api-Key")).To(Equal("some-key"))
+	})
+})
+
+var _ = Describe("Audience policy requirements", func() {
+
+	serve := func(claims map[string]interface{}) int {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithAudiencePolicy("admin",
+				authorizer.WithRequiredClaims(authorizer.AuthorizedClaim{Key: "env", Value: "prod"}),
+				authorizer.WithAuthorizedScopes("admin:write"),
+				authorizer.WithAuthorizedIssuers("https://issuer.example.com"),
+				authorizer.WithRequiredACR("mfa"),
+				authorizer.WithClaimExpression(`claims.role == "admin"`),
+			),
+		)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"aud":   "admin",
+			"env":   "prod",
+			"scope": "admin:write",
+			"iss":   "https://issuer.example.com",
+			"acr":   "mfa",
+			"role":  "admin",
+		}
+	}
+
+	It("allows tokens meeting every requirement", func() {
+		Expect(serve(valid())).To(Equal(http.StatusOK))
+	})
+
+	DescribeTable("rejects tokens missing a requirement",
+		func(claim string, code int) {
+			claims := valid()
+			delete(claims, claim)
+			Expect(serve(claims)).To(Equal(code))
+		},
+		Entry("required claim", "env", http.StatusForbidden),
+		Entry("scope", "scope", http.StatusForbidden),
+		Entry("issuer", "iss", http.StatusForbidden),
+		Entry("acr", "acr", http.StatusUnauthorized),
+		Entry("expression", "role", http.StatusForbidden),
+	)
+
+	It("does not apply to other audiences", func() {
+		Expect(serve(map[string]interface{}{"aud": "api"})).To(Equal(http.StatusOK))
+	})
+
+	It("refuses options the policy cannot apply", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAudiencePolicy("admin",
+				authorizer.WithApiKeys("secret"),
+			),
+		)
+
+		err := h.Validate()
+		Expect(err).To(MatchError(authorizer.ErrInvalidConfiguration))
+		Expect(err).To(MatchError(ContainSubstring("ApiKeys")))
+	})
+})
+
+var _ = Describe("IncludeClaimInContextWith", func() {
+
+	var forwarded context.Context
+
+	BeforeEach(func() {
+		forwarded = nil
+
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Context()
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"email":  "User@Example.com",
+				"exp":    float64(1700000000),
+				"groups": "a,b",
+				"role":   "admin",
+			}))),
+			authorizer.IncludeClaimInContextWith("email", "email", authorizer.Lowercase),
+			authorizer.IncludeClaimInContextWith("exp", "expires", authorizer.NumericDateToTime),
+			authorizer.IncludeClaimInContextWith("groups", "groups", func(value any) any {
+				return strings.Split(value.(string), ",")
+			}),
+			authorizer.IncludeClaimInContextWith("role", "role", func(any) any {
+				return nil
+			}),
+		)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+	})
+
+	It("stores the transformed values", func() {
+		Expect(forwarded.Value(authorizer.ContextKey("email"))).To(Equal("user@example.com"))
+		Expect(forwarded.Value(authorizer.ContextKey("expires"))).To(Equal(time.Unix(1700000000, 0)))
+		Expect(forwarded.Value(authorizer.ContextKey("groups"))).To(Equal([]string{"a", "b"}))
+	})
+
+	It("leaves out nil results", func() {
+		Expect(forwarded.Value(authorizer.ContextKey("role"))).To(BeNil())
+	})
+})
+
+var _ = Describe("Context mapping errors", func() {
+
+	var (
+		log       *logger
+		events    []authorizer.AuditEvent
+		forwarded context.Context
+		rec       *httptest.ResponseRecorder
+	)
+
+	newHandler := func(failOnError bool) http.Handler {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Context()
+		})
+		auth := authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+			"sub": "some-user",
+			"exp": "tomorrow",
+		})))
+		hook := authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+			events = append(events, event)
+		})
+
+		if failOnError {
+			return authorizer.NewHandler(log, next, auth, hook,
+				authorizer.IncludeClaimInContext("sub"),
+				authorizer.IncludeExpirationInContext(),
+				authorizer.FailOnContextMappingError(),
+			)
+		}
+		return authorizer.NewHandler(log, next, auth, hook,
+			authorizer.IncludeClaimInContext("sub"),
+			authorizer.IncludeExpirationInContext(),
+		)
+	}
+
+	BeforeEach(func() {
+		log = newLogger()
+		events = nil
+		forwarded = nil
+		rec = httptest.NewRecorder()
+	})
+
+	It("leaves out the failed mapping by default", func() {
+		newHandler(false).ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Value(authorizer.ContextKey("sub"))).To(Equal("some-user"))
+		Expect(forwarded.Value(authorizer.ContextKey(authorizer.ExpirationKey))).To(BeNil())
+
+		Expect(log.lines).To(ContainElement(ContainSubstring(`claim mapping failed: claim "exp" into "exp"`)))
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Allowed).To(BeTrue())
+		Expect(events[0].MappingErr).To(MatchError(authorizer.ErrClaimMappingFailed))
+	})
+
+	It("rejects the request with FailOnContextMappingError", func() {
+		newHandler(true).ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(forwarded).To(BeNil())
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Allowed).To(BeFalse())
+		Expect(events[0].Err).To(MatchError(authorizer.ErrClaimMappingFailed))
+	})
+})
+
+var _ = Describe("RequireMappedClaims", func() {
+
+	var (
+		log       *logger
+		events    []authorizer.AuditEvent
+		forwarded context.Context
+		rec       *httptest.ResponseRecorder
+	)
+
+	serve := func(claims map[string]interface{}, required bool) {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Context()
+		})
+		auth := authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims)))
+		hook := authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+			events = append(events, event)
+		})
+
+		var h http.Handler
+		if required {
+			h = authorizer.NewHandler(log, next, auth, hook,
+				authorizer.IncludeClaimInContext("sub"),
+				authorizer.IncludeClaimInContextAs("tenant", "org"),
+				authorizer.RequireMappedClaims(),
+			)
+		} else {
+			h = authorizer.NewHandler(log, next, auth, hook,
+				authorizer.IncludeClaimInContext("sub"),
+				authorizer.IncludeClaimInContextAs("tenant", "org"),
+			)
+		}
+
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+	}
+
+	BeforeEach(func() {
+		log = newLogger()
+		events = nil
+		forwarded = nil
+		rec = httptest.NewRecorder()
+	})
+
+	It("forwards requests with every mapped claim", func() {
+		serve(map[string]interface{}{"sub": "some-user", "tenant": "acme"}, true)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Value(authorizer.ContextKey("org"))).To(Equal("acme"))
+	})
+
+	It("rejects requests missing a mapped claim", func() {
+		serve(map[string]interface{}{"sub": "some-user"}, true)
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(forwarded).To(BeNil())
+		Expect(log.lines).To(ContainElement(ContainSubstring("missing mapped claim: tenant")))
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Err).To(MatchError(authorizer.ErrMissingMappedClaim))
+	})
+
+	It("rejects requests with a null mapped claim", func() {
+		serve(map[string]interface{}{"sub": nil, "tenant": "acme"}, true)
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(log.lines).To(ContainElement(ContainSubstring("missing mapped claim: sub")))
+	})
+
+	It("stores nil for missing claims without the option", func() {
+		serve(map[string]interface{}{"sub": "some-user"}, false)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Value(authorizer.ContextKey("org"))).To(BeNil())
+	})
+
+	It("answers with the status set for ErrMissingMappedClaim", func() {
+		h := authorizer.NewHandler(log, http.NotFoundHandler(),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{}))),
+			authorizer.IncludeClaimInContext("sub"),
+			authorizer.RequireMappedClaims(),
+			authorizer.WithRejectionStatus(authorizer.ErrMissingMappedClaim, http.StatusForbidden),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+	})
+})
+
+var _ = Describe("WithAuthorizedAudiences", func() {
+
+	DescribeTable("authorizes",
+		func(aud interface{}, code int) {
+			h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+				authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{"aud": aud}))),
+				authorizer.WithAuthorizedAudiences("a", "c"),
+			)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+			Expect(rec.Code).To(Equal(code))
+		},
+		Entry("a string audience", "a", http.StatusOK),
+		Entry("an array holding an audience", []interface{}{"a", "b"}, http.StatusOK),
+		Entry("an array holding another audience", []interface{}{"b", "c"}, http.StatusOK),
+		Entry("a normalized array", []string{"b", "c"}, http.StatusOK),
+		Entry("not an unknown string audience", "b", http.StatusForbidden),
+		Entry("not an array of unknown audiences", []interface{}{"b", "d"}, http.StatusForbidden),
+		Entry("not a missing audience", nil, http.StatusForbidden),
+	)
+})
+
+var _ = Describe("StrictApiKeyHeader", func() {
+
+	var log *logger
+
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	serve := func(h http.Handler, header string, values ...string) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		for _, value := range values {
+			req.Header.Add(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		log = newLogger()
+	})
+
+	DescribeTable("X-Api-Key",
+		func(strict bool, values []string, code int) {
+			h := authorizer.NewHandler(log, ok, authorizer.WithApiKeys("some-key"))
+			if strict {
+				h = authorizer.NewHandler(log, ok, authorizer.WithApiKeys("some-key"), authorizer.StrictApiKeyHeader())
+			}
+
+			Expect(serve(h, "X-Api-Key", values...)).To(Equal(code))
+		},
+		Entry("accepts a single header", true, []string{"some-key"}, http.StatusOK),
+		Entry("rejects identical duplicates", true, []string{"some-key", "some-key"}, http.StatusUnauthorized),
+		Entry("rejects conflicting duplicates", true, []string{"some-key", "other-key"}, http.StatusUnauthorized),
+		Entry("uses the first value by default", false, []string{"some-key", "other-key"}, http.StatusOK),
+		Entry("rejects by the first value by default", false, []string{"other-key", "some-key"}, http.StatusUnauthorized),
+	)
+
+	It("logs a warning for duplicates", func() {
+		h := authorizer.NewHandler(log, ok, authorizer.WithApiKeys("some-key"), authorizer.StrictApiKeyHeader())
+		serve(h, "X-Api-Key", "some-key", "other-key")
+
+		Expect(log.lines).NotTo(BeEmpty())
+		Expect(log.lines[0]).To(ContainSubstring("duplicate credential header"))
+		Expect(log.lines[0]).To(ContainSubstring("X-Api-Key"))
+		Expect(log.lines[0]).NotTo(ContainSubstring("other-key"))
+	})
+
+	DescribeTable("Authorization",
+		func(values []string, code int) {
+			h := authorizer.NewHandler(log, ok, authorizer.WithAuthorizedTokens("some-token"), authorizer.StrictApiKeyHeader())
+			Expect(serve(h, "Authorization", values...)).To(Equal(code))
+		},
+		Entry("accepts a single header", []string{"Bearer some-token"}, http.StatusOK),
+		Entry("rejects identical duplicates", []string{"Bearer some-token", "Bearer some-token"}, http.StatusUnauthorized),
+		Entry("rejects conflicting duplicates", []string{"Bearer some-token", "Bearer other-token"}, http.StatusUnauthorized),
+	)
+
+	It("leaves Authorization headers alone when credential lists are allowed", func() {
+		h := authorizer.NewHandler(log, ok,
+			authorizer.WithAuthorizer(authorizer.New(
+				authorizer.WithNotary(notaryFunc(func(string) (map[string]interface{}, error) {
+					return map[string]interface{}{"sub": "some-user"}, nil
+				})),
+				authorizer.AllowCredentialList(),
+			)),
+			authorizer.StrictApiKeyHeader(),
+		)
+
+		Expect(serve(h, "Authorization", "Bearer some-token", "Bearer other-token")).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("Credential lists", func() {
+
+	var (
+		outage    bool
+		forwarded *http.Request
+	)
+
+	notary := notaryFunc(func(token string) (map[string]interface{}, error) {
+		switch {
+		case outage:
+			return nil, authorizer.ErrKeySetUnavailable
+		case token == "good-token":
+			return map[string]interface{}{"sub": "some-user"}, nil
+		default:
+			return nil, authorizer.ErrInvalidSignature
+		}
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})
+
+	authz := authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(notary), authorizer.AllowCredentialList()))
+
+	serve := func(h http.Handler, remoteAddr, header string) int {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", header)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		outage = false
+		forwarded = nil
+	})
+
+	It("includes the accepted token in the context", func() {
+		h := authorizer.NewHandler(newLogger(), next, authz, authorizer.IncludeTokenInContext())
+
+		Expect(serve(h, "10.0.0.1:1234", "Bearer bad-token, Bearer good-token")).To(Equal(http.StatusOK))
+
+		token, ok := authorizer.TokenFromContext(forwarded.Context())
+		Expect(ok).To(BeTrue())
+		Expect(token).To(Equal("good-token"))
+	})
+
+	It("binds the accepted token", func() {
+		h := authorizer.NewHandler(newLogger(), next, authz, authorizer.WithTokenBinding(authorizer.NewMemoryBindingStore(), authorizer.BindToClientIP()))
+
+		Expect(serve(h, "10.0.0.1:1234", "Bearer good-token")).To(Equal(http.StatusOK))
+		Expect(serve(h, "10.0.0.2:1234", "Bearer bad-token, Bearer good-token")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("remembers the accepted token for outages", func() {
+		h := authorizer.NewHandler(newLogger(), next, authz, authorizer.FailOpenOnAuthorizerOutage(time.Minute))
+
+		Expect(serve(h, "10.0.0.1:1234", "Bearer bad-token, Bearer good-token")).To(Equal(http.StatusOK))
+
+		outage = true
+
+		Expect(serve(h, "10.0.0.1:1234", "Bearer bad-token, Bearer good-token")).To(Equal(http.StatusOK))
+		Expect(authorizer.DegradedAuthFromContext(forwarded.Context())).To(BeTrue())
+	})
+})
+
+type notaryFunc func(string) (map[string]interface{}, error)
+
+func (f notaryFunc) Notarize(token string) (map[string]interface{}, error) {
+	return f(token)
+}
+
+var _ = Describe("Claim patterns", func() {
+
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	subject := func(sub interface{}) authorizer.Authorizer {
+		return authorizerFunc(authorizeWithClaims(map[string]interface{}{"sub": sub}))
+	}
+
+	serve := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	DescribeTable("WithAuthorizedClaimPattern",
+		func(sub interface{}, code int) {
+			Expect(serve(authorizer.NewHandler(newLogger(), ok,
+				authorizer.WithAuthorizer(subject(sub)),
+				authorizer.WithAuthorizedClaimPattern("sub", `^svc-[a-z]+-prod$`),
+			))).To(Equal(code))
+		},
+		Entry("admits a matching subject", "svc-billing-prod", http.StatusOK),
+		Entry("rejects a subject that does not match", "svc-billing-dev", http.StatusForbidden),
+		Entry("rejects an array claim", []interface{}{"svc-billing-prod"}, http.StatusForbidden),
+		Entry("rejects a number", float64(1), http.StatusForbidden),
+	)
+
+	It("reuses a compiled pattern", func() {
+		claim := authorizer.AuthorizedClaim{Key: "sub", Pattern: regexp.MustCompile(`^svc-`)}
+
+		Expect(serve(authorizer.NewHandler(newLogger(), ok,
+			authorizer.WithAuthorizer(subject("svc-a")),
+			authorizer.WithAuthorizedClaims(claim),
+		))).To(Equal(http.StatusOK))
+
+		Expect(serve(authorizer.NewHandler(newLogger(), ok,
+			authorizer.WithAuthorizer(subject("usr-a")),
+			authorizer.WithRequiredClaims(claim),
+		))).To(Equal(http.StatusForbidden))
+	})
+
+	It("reports an invalid pattern as a configuration error", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizedClaimPattern("sub", `^svc-(`),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrInvalidClaimPattern))
+	})
+})
+
+var _ = Describe("Context values", func() {
+
+	type upstreamKey struct{}
+
+	var forwarded context.Context
+
+	BeforeEach(func() {
+		forwarded = nil
+
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Context()
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"sub":  "some-user",
+				"role": "admin",
+			}))),
+			authorizer.IncludeAllClaimsInContext(),
+			authorizer.IncludeClaimInContextAs("sub", "role"),
+		)
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req = req.WithContext(context.WithValue(req.Context(), upstreamKey{}, "upstream"))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	It("serves mapped values by their raw keys", func() {
+		Expect(forwarded.Value(authorizer.ContextKey("sub"))).To(Equal("some-user"))
+	})
+
+	It("lets later mappings shadow earlier ones", func() {
+		Expect(forwarded.Value(authorizer.ContextKey("role"))).To(Equal("some-user"))
+	})
+
+	It("falls back to values set before the handler", func() {
+		Expect(forwarded.Value(upstreamKey{})).To(Equal("upstream"))
+		Expect(forwarded.Value(authorizer.ContextKey("missing"))).To(BeNil())
+	})
+
+	It("prints like a value context", func() {
+		Expect(fmt.Sprint(forwarded)).To(ContainSubstring("sub, some-user"))
+	})
+})
+
+var _ = Describe("WithApiKeyClaims", func() {
+
+	var (
+		rec       *httptest.ResponseRecorder
+		forwarded *http.Request
+		handler   http.Handler
+	)
+
+	serve := func(key string) {
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", key)
+		handler.ServeHTTP(rec, req)
+	}
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+		forwarded = nil
+
+		handler = authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}),
+			authorizer.WithApiKeys("plain-key", "billing-key"),
+			authorizer.WithApiKeyClaims("billing-key", map[string]any{"sub": "billing-service"}),
+			authorizer.WithApiKeyClaims("reports-key", map[string]any{"sub": "reports-service"}),
+			authorizer.IncludeClaimInContext("sub"),
+		)
+	})
+
+	It("attaches claims to a key that is already configured", func() {
+		serve("billing-key")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Context().Value(authorizer.ContextKey("sub"))).To(Equal("billing-service"))
+		Expect(authorizer.MechanismFromContext(forwarded.Context())).To(Equal(authorizer.MechanismApiKey))
+	})
+
+	It("authorizes a key configured only with claims", func() {
+		serve("reports-key")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Context().Value(authorizer.ContextKey("sub"))).To(Equal("reports-service"))
+	})
+
+	It("leaves keys without claims unchanged", func() {
+		serve("plain-key")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(forwarded.Context().Value(authorizer.ContextKey("sub"))).To(BeNil())
+		Expect(authorizer.ClaimsFromContext(forwarded.Context())).To(BeNil())
+	})
+
+	It("rejects an empty key", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithApiKeyClaims("", map[string]any{"sub": "nobody"}),
+		)
+		Expect(h.Validate()).To(MatchError(authorizer.ErrEmptyCredential))
+	})
+})
+
+var _ = Describe("FailOpenOnAuthorizerOutage", func() {
+
+	var (
+		window time.Duration
+
+		mockCtrl       *gomock.Controller
+		mockAuthorizer *mocks.MockAuthorizer
+		mockHandler    *mocks.MockHandler
+
+		handler http.Handler
+		outage  error
+		cache   authorizer.Cache
+	)
+
+	serve := func(token string) (*http.Request, *httptest.ResponseRecorder) {
+		req, err := http.NewRequest("GET", "http://localhost", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Authorization", "bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return req, rec
+	}
+
+	BeforeEach(func() {
+		window = time.Minute
+		outage = fmt.Errorf("%w: connection refused", authorizer.ErrKeySetUnavailable)
+		cache = nil
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockAuthorizer = mocks.NewMockAuthorizer(mockCtrl)
+		mockHandler = mocks.NewMockHandler(mockCtrl)
+	})
+
+	JustBeforeEach(func() {
+		handler = authorizer.NewHandler(
+			newLogger(),
+			mockHandler,
+			authorizer.WithAuthorizer(mockAuthorizer),
+			authorizer.IncludeClaimInContext("sub"),
+			authorizer.FailOpenOnAuthorizerOutage(window),
+			authorizer.WithVerifiedTokenCache(cache),
+		)
+	})
+
+	Context("when the cache is warm", func() {
+		JustBeforeEach(func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"sub": "some-user",
+			}))
+			mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+
+			req, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+			Expect(authorizer.DegradedAuthFromContext(req.Context())).To(BeFalse())
+		})
+
+		Context("when the authorizer suffers an outage", func() {
+			It("admits the previously verified token in degraded mode", func() {
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+				mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+
+				req, rec := serve("token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				Expect(authorizer.DegradedAuthFromContext(req.Context())).To(BeTrue())
+				Expect(req.Context().Value(authorizer.ContextKey("sub"))).To(Equal("some-user"))
+			})
+
+			It("rejects tokens that were never seen", func() {
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+
+				_, rec := serve("other-token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("when the token is later rejected", func() {
+			It("never admits it during an outage", func() {
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(authorizer.ErrTokenExpired)
+
+				_, rec := serve("token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+
+				_, rec = serve("token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("when the authorizer fails for a non-outage reason", func() {
+			It("rejects the token", func() {
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(errors.New("nope"))
+
+				_, rec := serve("token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("when the window has elapsed", func() {
+			BeforeEach(func() {
+				window = time.Millisecond
+			})
+
+			It("rejects the token", func() {
+				time.Sleep(5 * time.Millisecond)
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+
+				_, rec := serve("token")
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Context("when replicas share a verified token cache", func() {
+		BeforeEach(func() {
+			cache = authorizer.NewMemoryCache(10)
+		})
+
+		It("admits tokens verified by another replica during an outage", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"sub": "some-user",
+			}))
+			mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(2)
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+
+			handler = authorizer.NewHandler(
+				newLogger(),
+				mockHandler,
+				authorizer.WithAuthorizer(mockAuthorizer),
+				authorizer.IncludeClaimInContext("sub"),
+				authorizer.FailOpenOnAuthorizerOutage(window),
+				authorizer.WithVerifiedTokenCache(cache),
+			)
+
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+
+			req, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+			Expect(authorizer.DegradedAuthFromContext(req.Context())).To(BeTrue())
+			Expect(req.Context().Value(authorizer.ContextKey("sub"))).To(Equal("some-user"))
+		})
+	})
+
+	Context("when the verified token cache is remote", func() {
+		var (
+			mockCache *mocks.MockCache
+			key       string
+		)
+
+		BeforeEach(func() {
+			mockCache = mocks.NewMockCache(mockCtrl)
+			cache = mockCache
+
+			sum := sha256.Sum256([]byte("token"))
+			key = "verified:" + hex.EncodeToString(sum[:])
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("stores verified tokens for the window and admits them on a hit", func() {
+			var stored []byte
+
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"sub": "some-user",
+			}))
+			mockCache.EXPECT().SetWithTTL(gomock.Any(), key, gomock.Any(), window).DoAndReturn(
+				func(_ context.Context, _ string, value []byte, _ time.Duration) error {
+					stored = value
+					return nil
+				},
+			)
+			mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(2)
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+			mockCache.EXPECT().Get(gomock.Any(), key).Return(stored, nil)
+
+			req, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+			Expect(authorizer.DegradedAuthFromContext(req.Context())).To(BeTrue())
+			Expect(req.Context().Value(authorizer.ContextKey("sub"))).To(Equal("some-user"))
+		})
+
+		It("rejects the token on a miss", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+			mockCache.EXPECT().Get(gomock.Any(), key).Return(nil, authorizer.ErrCacheMiss)
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects the token when the cache cannot be read", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+			mockCache.EXPECT().Get(gomock.Any(), key).Return(nil, errors.New("connection refused"))
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("still serves the request when the cache cannot be written", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).DoAndReturn(authorizeWithClaims(map[string]interface{}{
+				"sub": "some-user",
+			}))
+			mockCache.EXPECT().SetWithTTL(gomock.Any(), key, gomock.Any(), window).Return(errors.New("connection refused"))
+			mockHandler.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("forgets tokens the authorizer rejects", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(authorizer.ErrTokenExpired)
+			mockCache.EXPECT().Delete(gomock.Any(), key).Return(nil)
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when the cache is cold", func() {
+		It("rejects the token during an outage", func() {
+			mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(outage)
+
+			_, rec := serve("token")
+			Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+func authorizeWithClaims(claims map[string]interface{}) func(*http.Request) error {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(authorizer.ContextWithClaims(r.Context(), claims))
+		return nil
+	}
+}
+
+func BenchmarkHandlerWithManyApiKeys(b *testing.B) {
+	opts := []string{}
+	for i := 0; i < 50; i++ {
+		opts = append(opts, fmt.Sprintf("api-key-%02d", i))
+	}
+
+	h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		authorizer.WithApiKeys(opts...),
+		authorizer.WithAuthorizedTokens("token"),
+	)
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("X-Api-Key", "api-key-49")
+	req.Header.Set("Authorization", "Bearer token")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func newLogger() *logger {
+	return &logger{}
+}
+
+type logger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *logger) Error(args ...interface{}) {
+	line := fmt.Sprintln(args...)
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, line)
+	fmt.Fprint(GinkgoWriter, line)
+}
+
+var _ = Describe("Context accessors", func() {
+
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = nil
+
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx = r.Context()
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"sub":  "subject",
+				"user": map[string]interface{}{"email": "user@example.com"},
+			}))),
+			authorizer.IncludeClaimInContextAs("user.email", "email"),
+		)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+	})
+
+	It("reads the subject", func() {
+		subject, ok := authorizer.Subject(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(subject).To(Equal("subject"))
+	})
+
+	It("reads included values and claims", func() {
+		email, ok := authorizer.Claim(ctx, "email")
+		Expect(ok).To(BeTrue())
+		Expect(email).To(Equal("user@example.com"))
+
+		email, ok = authorizer.Claim(ctx, "user.email")
+		Expect(ok).To(BeTrue())
+		Expect(email).To(Equal("user@example.com"))
+
+		_, ok = authorizer.Claim(ctx, "missing")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("does not store values under plain string keys", func() {
+		Expect(ctx.Value("email")).To(BeNil())
+		Expect(ctx.Value(authorizer.ContextKey("email"))).To(Equal("user@example.com"))
+	})
+
+	It("reports no subject without claims", func() {
+		_, ok := authorizer.Subject(context.Background())
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("with string context keys", func() {
+		BeforeEach(func() {
+			h := authorizer.NewHandler(newLogger(),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ctx = r.Context()
+				}),
+				authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+					"sub": "subject",
+				}))),
+				authorizer.IncludeClaimInContextAs("sub", "user"),
+				authorizer.WithStringContextKeys(),
+			)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost", nil))
+		})
+
+		It("also stores values under plain string keys", func() {
+			Expect(ctx.Value("user")).To(Equal("subject"))
+			Expect(ctx.Value(authorizer.ContextKey("user"))).To(Equal("subject"))
+		})
+	})
+})
+
+func BenchmarkHandlerWithManyMappedClaims(b *testing.B) {
+	claims := map[string]interface{}{}
+	for i := 0; i < 15; i++ {
+		claims[fmt.Sprintf("claim-%02d", i)] = "value"
+	}
+
+	h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+		authorizer.IncludeAllClaimsInContext(),
+	)
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req.Clone(req.Context()))
+	}
 }
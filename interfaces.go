@@ -0,0 +1,70 @@
+package authorizer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+//go:generate mockgen -destination=mocks/mock_authorizer.go -package=mocks github.com/reverted/authorizer Authorizer
+//go:generate mockgen -destination=mocks/mock_notary.go -package=mocks github.com/reverted/authorizer Notary
+//go:generate mockgen -destination=mocks/mock_logger.go -package=mocks github.com/reverted/authorizer Logger
+//go:generate mockgen -destination=mocks/mock_handler.go -package=mocks net/http Handler
+//go:generate mockgen -destination=mocks/mock_cache.go -package=mocks github.com/reverted/authorizer Cache
+//go:generate mockgen -destination=mocks/mock_binding_store.go -package=mocks github.com/reverted/authorizer BindingStore
+
+type Logger interface {
+	Error(a ...interface{})
+}
+
+type Authorizer interface {
+	Authorize(r *http.Request) error
+}
+
+type Notary interface {
+	Notarize(string) (map[string]interface{}, error)
+}
+
+// Cache stores values for the handler's caching features so that they can be
+// shared between replicas, for example through Redis.
+//
+// Get returns ErrCacheMiss for keys that were never set, were deleted or have
+// expired. SetWithTTL replaces any existing value; a ttl of zero or less
+// means the value does not expire, although bounded implementations may
+// still evict it. Implementations may round a ttl up to their own precision,
+// by at most a second, so callers must not rely on sub-second expiry.
+// Delete succeeds for keys that are not present. All methods must be safe
+// for concurrent use, and values returned by Get must not alias values passed
+// to SetWithTTL.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// BindingStore remembers the fingerprint a token was first used with.
+type BindingStore interface {
+	// Bind records fingerprint for key until expiry unless key is already
+	// bound, and returns the fingerprint key is bound to.
+	Bind(key, fingerprint string, expiry time.Time) (string, error)
+}
+
+var (
+	_ Authorizer = (*authorizer)(nil)
+	_ Authorizer = (*noopAuthorizer)(nil)
+	_ Authorizer = (*denyAllAuthorizer)(nil)
+	_ Authorizer = (*sessionAuthorizer)(nil)
+	_ Notary     = (*notary)(nil)
+	_ Logger     = (*slogLogger)(nil)
+
+	_ BindingStore = (*memoryBindingStore)(nil)
+	_ Cache        = (*memoryCache)(nil)
+
+	_ http.Handler = (*handler)(nil)
+	_ http.Handler = (*mux)(nil)
+
+	_ io.Closer = (*handler)(nil)
+	_ io.Closer = (*notary)(nil)
+	_ io.Closer = (*mux)(nil)
+)
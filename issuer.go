@@ -0,0 +1,33 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+var ErrIssuerNotAuthorized = errors.New("issuer not authorized")
+
+// WithAuthorizedIssuers requires the iss claim to be one of issuers. Like
+// required claims it is checked before the authorized claims, and a rejected
+// issuer is logged.
+func WithAuthorizedIssuers(issuers ...string) handlerOpt {
+	return func(h *handler) {
+		h.AuthorizedIssuers = append(h.AuthorizedIssuers, issuers...)
+	}
+}
+
+func (h *handler) checkIssuer(r *http.Request) error {
+
+	if len(h.AuthorizedIssuers) == 0 {
+		return nil
+	}
+
+	issuer, _ := claimValue(r, issKey).(string)
+	if !slices.Contains(h.AuthorizedIssuers, issuer) {
+		return fmt.Errorf("%w: %q", ErrIssuerNotAuthorized, issuer)
+	}
+
+	return nil
+}
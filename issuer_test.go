@@ -0,0 +1,49 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithAuthorizedIssuers", func() {
+
+	var log *logger
+
+	serve := func(claims map[string]interface{}) int {
+		log = newLogger()
+
+		h := authorizer.NewHandler(log, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithAuthorizedIssuers("https://idp-a", "https://idp-b"),
+			authorizer.WithAuthorizedSubjects("some-user", "other-user"),
+		)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	DescribeTable("authorizes",
+		func(claims map[string]interface{}, code int) {
+			Expect(serve(claims)).To(Equal(code))
+		},
+		Entry("the first issuer", map[string]interface{}{"iss": "https://idp-a", "sub": "some-user"}, http.StatusOK),
+		Entry("the second issuer", map[string]interface{}{"iss": "https://idp-b", "sub": "other-user"}, http.StatusOK),
+		Entry("not another issuer", map[string]interface{}{"iss": "https://idp-c", "sub": "some-user"}, http.StatusForbidden),
+		Entry("not a missing issuer", map[string]interface{}{"sub": "some-user"}, http.StatusForbidden),
+		Entry("not an authorized issuer without an authorized claim", map[string]interface{}{"iss": "https://idp-a", "sub": "third-user"}, http.StatusForbidden),
+	)
+
+	It("logs the rejected issuer", func() {
+		serve(map[string]interface{}{"iss": "https://idp-c", "sub": "some-user"})
+
+		Expect(log.lines).To(HaveLen(1))
+		Expect(log.lines[0]).To(ContainSubstring(`issuer not authorized: "https://idp-c"`))
+	})
+})
@@ -0,0 +1,72 @@
+package authorizer
+
+import (
+	"sync"
+	"time"
+)
+
+// workers tracks background goroutines so that Close can stop them and wait
+// for them to exit. The zero value is ready to use and Close is idempotent.
+type workers struct {
+	mu     sync.Mutex
+	done   chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Every runs fn every interval until the workers are closed.
+func (w *workers) Every(interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+
+	w.Go(func(done <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+// Go runs fn in the background. fn must return once done is closed.
+func (w *workers) Go(fn func(done <-chan struct{})) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	if w.done == nil {
+		w.done = make(chan struct{})
+	}
+
+	done := w.done
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(done)
+	}()
+}
+
+func (w *workers) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		if w.done != nil {
+			close(w.done)
+		}
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return nil
+}
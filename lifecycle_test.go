@@ -0,0 +1,74 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Close", func() {
+
+	var goroutines int
+
+	BeforeEach(func() {
+		goroutines = runtime.NumGoroutine()
+	})
+
+	AfterEach(func() {
+		expectNoLeakedGoroutines(goroutines)
+	})
+
+	Describe("handler", func() {
+		It("stops background workers", func() {
+			h := authorizer.NewHandler(
+				newLogger(),
+				http.NotFoundHandler(),
+				authorizer.WithTokenProvider(func() ([]string, error) {
+					return []string{"token"}, nil
+				}, time.Millisecond),
+			)
+			defer h.Close()
+
+			Expect(runtime.NumGoroutine()).To(BeNumerically(">", goroutines))
+		})
+
+		It("is safe to call twice", func() {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler())
+
+			Expect(h.Close()).To(Succeed())
+			Expect(h.Close()).To(Succeed())
+		})
+	})
+
+	Describe("mux", func() {
+		It("stops the background workers of every pattern", func() {
+			m := authorizer.NewMux(newLogger())
+			m.Handle("/a", http.NotFoundHandler(), authorizer.WithTokenProvider(func() ([]string, error) {
+				return []string{"token"}, nil
+			}, time.Millisecond))
+			defer m.Close()
+
+			Expect(runtime.NumGoroutine()).To(BeNumerically(">", goroutines))
+		})
+	})
+
+	Describe("notary", func() {
+		It("is safe to call twice", func() {
+			n := authorizer.NewNotary()
+
+			Expect(n.Close()).To(Succeed())
+			Expect(n.Close()).To(Succeed())
+		})
+	})
+})
+
+// expectNoLeakedGoroutines waits for the goroutine count to fall back to what
+// it was before the spec started.
+func expectNoLeakedGoroutines(before int) {
+	Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+}
@@ -0,0 +1,147 @@
+package authorizer
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	redacted           = "[REDACTED]"
+	maxRedactedLength  = 512
+	truncatedIndicator = "...(truncated)"
+)
+
+var (
+	jwtPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	credentialPattern = regexp.MustCompile(`(?i)\b(basic|bearer)(\s+)[A-Za-z0-9+/=._~-]+`)
+)
+
+// RedactSecrets removes anything that looks like a JWT or an Authorization
+// header credential from value and caps its length, so that it is safe to log.
+func RedactSecrets(value string) string {
+
+	value = jwtPattern.ReplaceAllString(value, redacted)
+	value = credentialPattern.ReplaceAllString(value, "${1}${2}"+redacted)
+
+	if len(value) > maxRedactedLength {
+		value = value[:maxRedactedLength] + truncatedIndicator
+	}
+
+	return value
+}
+
+// Redacted holds a sensitive value, such as a raw token, that prints as
+// [REDACTED] however it is formatted, so that dumping a request context does
+// not leak it. Reveal returns the value for intentional use.
+type Redacted struct {
+	value string
+}
+
+func (r Redacted) Reveal() string {
+	return r.value
+}
+
+func (r Redacted) String() string {
+	return redacted
+}
+
+func (r Redacted) GoString() string {
+	return redacted
+}
+
+func (r Redacted) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+func (r Redacted) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(redacted)), nil
+}
+
+// warnLogger is implemented by loggers that can log below error level.
+type warnLogger interface {
+	Warn(a ...interface{})
+}
+
+// logWarning logs at warning level where the logger supports it.
+func logWarning(logger Logger, args ...interface{}) {
+	if logger, ok := logger.(warnLogger); ok {
+		logger.Warn(args...)
+		return
+	}
+	logger.Error(args...)
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func (f Field) String() string {
+	value := fmt.Sprint(f.Value)
+	if value == "" || strings.IndexFunc(value, needsQuoting) >= 0 {
+		value = strconv.Quote(value)
+	}
+	return f.Key + "=" + value
+}
+
+// needsQuoting reports runes that would make a value ambiguous, or let it
+// forge further fields or lines, if logged bare.
+func needsQuoting(r rune) bool {
+	return r == ' ' || r == '"' || r == '=' || r == utf8.RuneError || !unicode.IsPrint(r)
+}
+
+func requestFields(r *http.Request) []interface{} {
+	fields := []interface{}{
+		Field{"method", r.Method},
+		Field{"path", r.URL.Path},
+		Field{"remote_addr", r.RemoteAddr},
+		Field{"user_agent", r.UserAgent()},
+	}
+
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		fields = append(fields, Field{"request_id", id})
+	}
+
+	return fields
+}
+
+func NewSlogLogger(logger *slog.Logger) *slogLogger {
+	return &slogLogger{logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Error(args ...interface{}) {
+	msg, attrs := slogArgs(args)
+	l.logger.Error(msg, attrs...)
+}
+
+func (l *slogLogger) Warn(args ...interface{}) {
+	msg, attrs := slogArgs(args)
+	l.logger.Warn(msg, attrs...)
+}
+
+func slogArgs(args []interface{}) (string, []interface{}) {
+
+	var msg []string
+	var attrs []interface{}
+
+	for _, arg := range args {
+		if field, ok := arg.(Field); ok {
+			attrs = append(attrs, slog.Any(field.Key, field.Value))
+		} else {
+			msg = append(msg, fmt.Sprint(arg))
+		}
+	}
+
+	return strings.Join(msg, " "), attrs
+}
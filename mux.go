@@ -0,0 +1,59 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func NewMux(logger Logger, opts ...handlerOpt) *mux {
+	return &mux{
+		Logger:   logger,
+		Opts:     opts,
+		serveMux: http.NewServeMux(),
+	}
+}
+
+type mux struct {
+	Logger
+	Opts []handlerOpt
+
+	serveMux *http.ServeMux
+	patterns []string
+	handlers []*handler
+}
+
+func (m *mux) Handle(pattern string, next http.Handler, extra ...handlerOpt) {
+	opts := append(append([]handlerOpt{}, m.Opts...), extra...)
+	opts = append(opts, withRoutePattern(pattern))
+
+	handler := NewHandler(m.Logger, next, opts...)
+	m.patterns = append(m.patterns, pattern)
+	m.handlers = append(m.handlers, handler)
+	m.serveMux.Handle(pattern, handler)
+}
+
+func (m *mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.serveMux.ServeHTTP(w, r)
+}
+
+// Validate reports the configuration errors of every pattern's handler, so
+// that they surface at startup rather than on the first request.
+func (m *mux) Validate() error {
+	var errs []error
+	for i, handler := range m.handlers {
+		if err := handler.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.patterns[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the background work of every pattern's handler.
+func (m *mux) Close() error {
+	var errs []error
+	for _, handler := range m.handlers {
+		errs = append(errs, handler.Close())
+	}
+	return errors.Join(errs...)
+}
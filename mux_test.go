@@ -0,0 +1,154 @@
+package authorizer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reverted/authorizer"
+	"github.com/reverted/authorizer/mocks"
+)
+
+var _ = Describe("Mux", func() {
+
+	var (
+		err error
+		req *http.Request
+		rec *httptest.ResponseRecorder
+
+		mockCtrl       *gomock.Controller
+		mockAuthorizer *mocks.MockAuthorizer
+		mockHandlerA   *mocks.MockHandler
+		mockHandlerB   *mocks.MockHandler
+		mockHandlerC   *mocks.MockHandler
+
+		mux http.Handler
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockAuthorizer = mocks.NewMockAuthorizer(mockCtrl)
+		mockHandlerA = mocks.NewMockHandler(mockCtrl)
+		mockHandlerB = mocks.NewMockHandler(mockCtrl)
+		mockHandlerC = mocks.NewMockHandler(mockCtrl)
+
+		m := authorizer.NewMux(
+			newLogger(),
+			authorizer.WithAuthorizer(mockAuthorizer),
+		)
+
+		m.Handle("/a", mockHandlerA, authorizer.WithAuthorizedClaim("role", "a"))
+		m.Handle("/b", mockHandlerB, authorizer.WithAuthorizedClaim("role", "b"))
+		m.Handle("/c", mockHandlerC)
+
+		mux = m
+	})
+
+	Describe("ServeHTTP", func() {
+		JustBeforeEach(func() {
+			mux.ServeHTTP(rec, req)
+		})
+
+		BeforeEach(func() {
+			rec = httptest.NewRecorder()
+		})
+
+		Context("when the pattern requires a claim", func() {
+			BeforeEach(func() {
+				req, err = http.NewRequest("GET", "http://localhost/a", nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(nil)
+			})
+
+			Context("when the claim matches", func() {
+				BeforeEach(func() {
+					ctx := context.WithValue(context.Background(), "role", "a")
+					*req = *req.WithContext(ctx)
+
+					mockHandlerA.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+				})
+
+				It("forwards the request to the pattern's handler", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("when the claim belongs to another pattern", func() {
+				BeforeEach(func() {
+					ctx := context.WithValue(context.Background(), "role", "b")
+					*req = *req.WithContext(ctx)
+				})
+
+				It("responds with Unauthorized", func() {
+					Expect(rec.Result().StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+		})
+
+		Context("when another pattern requires a different claim", func() {
+			BeforeEach(func() {
+				req, err = http.NewRequest("GET", "http://localhost/b", nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				ctx := context.WithValue(context.Background(), "role", "b")
+				*req = *req.WithContext(ctx)
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(nil)
+				mockHandlerB.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+			})
+
+			It("forwards the request to the pattern's handler", func() {
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the pattern only uses the base policy", func() {
+			BeforeEach(func() {
+				req, err = http.NewRequest("GET", "http://localhost/c", nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockAuthorizer.EXPECT().Authorize(gomock.Any()).Return(nil)
+				mockHandlerC.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+			})
+
+			It("forwards the request to the pattern's handler", func() {
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the pattern is not registered", func() {
+			BeforeEach(func() {
+				req, err = http.NewRequest("GET", "http://localhost/d", nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("responds with NotFound without authorizing", func() {
+				Expect(rec.Result().StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Validate", func() {
+		It("reports the configuration errors of every pattern", func() {
+			m := authorizer.NewMux(newLogger())
+			m.Handle("/a", mockHandlerA)
+			m.Handle("/b", mockHandlerB, authorizer.WithBasicAuthCredential("", ""))
+
+			err := m.Validate()
+			Expect(err).To(MatchError(authorizer.ErrEmptyCredential))
+			Expect(err.Error()).To(HavePrefix("/b: "))
+		})
+
+		It("succeeds when every pattern is configured correctly", func() {
+			m := authorizer.NewMux(newLogger(), authorizer.WithAuthorizer(mockAuthorizer))
+			m.Handle("/a", mockHandlerA)
+
+			Expect(m.Validate()).To(Succeed())
+		})
+	})
+})
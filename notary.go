@@ -1,12 +1,23 @@
 package authorizer
 
 import (
+	"context"
+	"crypto"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
@@ -14,15 +25,43 @@ import (
 )
 
 var (
-	ErrNoPublicKey      = errors.New("no public key")
-	ErrInvalidToken     = errors.New("invalid token")
-	ErrInvalidSignature = errors.New("invalid signature")
-	ErrTokenExpired     = errors.New("token expired")
-	ErrInvalidAudience  = errors.New("invalid audience")
-	ErrNoTargetSet      = errors.New("no target set")
-	ErrNoKeysFound      = errors.New("no keys found")
+	ErrNoPublicKey        = errors.New("no public key")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenNotYetValid   = errors.New("token not yet valid")
+	ErrInvalidAudience    = errors.New("invalid audience")
+	ErrInvalidIssuer      = errors.New("invalid issuer")
+	ErrNoTargetSet        = errors.New("no target set")
+	ErrNoKeysFound        = errors.New("no keys found")
+	ErrNoKeySetURI        = errors.New("no jwks_uri in discovery document")
+	ErrInsecureAlgorithm  = errors.New("insecure algorithm")
+	ErrMissingExpiry      = errors.New("missing expiry")
+	ErrMissingIssuedAt    = errors.New("missing issued at")
+	ErrKeySetUnavailable  = errors.New("key set unavailable")
+	ErrNoAudienceSet      = errors.New("no audience set")
+	ErrNoUsableKeys       = errors.New("no usable keys")
+	ErrDeprecatedAudience = errors.New("deprecated audience")
+
+	ErrUntrustedEmbeddedKey = errors.New("untrusted embedded key")
+	ErrKeyFetchThrottled    = errors.New("key fetch throttled")
 )
 
+type TokenExpiredError struct {
+	Expiry time.Time
+	// Leeway is the leeway the token was checked with; it stopped being
+	// accepted at Expiry plus Leeway.
+	Leeway time.Duration
+}
+
+func (e *TokenExpiredError) Error() string {
+	return ErrTokenExpired.Error()
+}
+
+func (e *TokenExpiredError) Is(target error) bool {
+	return target == ErrTokenExpired
+}
+
 type notaryOpt func(*notary)
 
 func WithTarget(target string) notaryOpt {
@@ -34,6 +73,18 @@ func WithTarget(target string) notaryOpt {
 	}
 }
 
+// WithDiscovery resolves the key set from the jwks_uri of the OpenID Connect
+// discovery document of issuer, which is fetched once and cached. WithTarget
+// takes precedence.
+func WithDiscovery(issuer string) notaryOpt {
+	return func(n *notary) {
+		var err error
+		if n.Discovery, err = url.Parse(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"); err != nil {
+			n.configErr = fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
+		}
+	}
+}
+
 func WithHttpClient(client *http.Client) notaryOpt {
 	return func(n *notary) {
 		n.Client = client
@@ -46,6 +97,110 @@ func WithAudience(auds ...string) notaryOpt {
 	}
 }
 
+// WithAudiencePattern also accepts tokens with an audience matching glob, in
+// which * stands for any run of characters other than /. The whole audience
+// must match, so api://staging-*.example.com does not accept
+// api://staging-eu.example.com.evil.com.
+func WithAudiencePattern(glob string) notaryOpt {
+	return func(n *notary) {
+		n.AudiencePatterns = append(n.AudiencePatterns, glob)
+	}
+}
+
+func WithClock(now func() time.Time) notaryOpt {
+	return func(n *notary) {
+		n.Now = now
+	}
+}
+
+func WithLeeway(leeway time.Duration) notaryOpt {
+	return func(n *notary) {
+		n.Leeway = leeway
+	}
+}
+
+// WithIssuedAtLeeway tolerates tokens issued up to leeway in the future, in
+// place of the leeway set with WithLeeway, for issuers whose clocks run
+// ahead. Tokens accepted only thanks to the leeway are counted in Stats.
+func WithIssuedAtLeeway(leeway time.Duration) notaryOpt {
+	return func(n *notary) {
+		n.IssuedAtLeeway = &leeway
+	}
+}
+
+// RequireExpiry rejects tokens without an exp claim. This is the default.
+func RequireExpiry() notaryOpt {
+	return func(n *notary) {
+		n.AllowMissingExpiry = false
+	}
+}
+
+func AllowMissingExpiry() notaryOpt {
+	return func(n *notary) {
+		n.AllowMissingExpiry = true
+	}
+}
+
+// WithMaxTokenAge rejects tokens issued more than age ago. Tokens must carry
+// an iat claim, which stands in for a missing exp.
+func WithMaxTokenAge(age time.Duration) notaryOpt {
+	return func(n *notary) {
+		n.MaxTokenAge = age
+	}
+}
+
+// WithDeprecatedAudiences keeps accepting tokens for audiences that are being
+// retired. Such tokens are flagged with DeprecatedAudienceFromContext and
+// counted in Stats so that callers can tell when the audience is unused.
+func WithDeprecatedAudiences(auds ...string) notaryOpt {
+	return func(n *notary) {
+		n.DeprecatedAudiences = append(n.DeprecatedAudiences, auds...)
+	}
+}
+
+// WithEmbeddedKeyThumbprints verifies tokens that carry their signing key in
+// the jwk header with that key, without consulting the key set, provided its
+// RFC 7638 SHA-256 thumbprint, base64url encoded without padding, is one of
+// thumbprints. Tokens embedding any other key are rejected. Without this
+// option the jwk header is ignored.
+func WithEmbeddedKeyThumbprints(thumbprints ...string) notaryOpt {
+	return func(n *notary) {
+		n.EmbeddedKeyThumbprints = append(n.EmbeddedKeyThumbprints, thumbprints...)
+	}
+}
+
+// WithIssuer rejects tokens whose iss claim is not one of issuers.
+func WithIssuer(issuers ...string) notaryOpt {
+	return func(n *notary) {
+		n.Issuers = issuers
+	}
+}
+
+// WithRefreshInterval refreshes the key set in the background, once at
+// construction and then every interval, until the notary is closed. Tokens
+// keep being verified against the current keys while a refresh is running.
+func WithRefreshInterval(interval time.Duration) notaryOpt {
+	return func(n *notary) {
+		n.RefreshInterval = interval
+	}
+}
+
+// DisableInlineRefresh stops Notarize from fetching the key set when a token
+// is signed by an unknown key; such tokens are rejected at once, and the key
+// set is only refreshed by WithRefreshInterval or Refresh. It requires
+// WithRefreshInterval.
+func DisableInlineRefresh() notaryOpt {
+	return func(n *notary) {
+		n.InlineRefreshDisabled = true
+	}
+}
+
+func NormalizeAudience() notaryOpt {
+	return func(n *notary) {
+		n.NormalizeAudience = true
+	}
+}
+
 func WithSignatureAlgorithm(alg string) notaryOpt {
 	return func(n *notary) {
 		n.Algorithms = append(n.Algorithms, jose.SignatureAlgorithm(alg))
@@ -53,9 +208,52 @@ func WithSignatureAlgorithm(alg string) notaryOpt {
 	}
 }
 
+func WithoutSharing() notaryOpt {
+	return func(n *notary) {
+		n.Unshared = true
+	}
+}
+
+var sharedNotaries = struct {
+	sync.Mutex
+	notaries map[string]*notary
+}{notaries: map[string]*notary{}}
+
+// SharedNotary returns a process-wide notary for the given options so that
+// handlers configured alike share one key cache. Notaries are shared only
+// when every option that affects verification matches; notaries with their
+// own http client or clock are never shared. WithoutSharing opts out.
+func SharedNotary(opts ...notaryOpt) Notary {
+	candidate := NewNotary(opts...)
+
+	if candidate.Unshared || candidate.URL == nil || !candidate.shareable() {
+		return candidate
+	}
+
+	key := candidate.sharingKey()
+
+	sharedNotaries.Lock()
+	defer sharedNotaries.Unlock()
+
+	if existing, ok := sharedNotaries.notaries[key]; ok {
+		candidate.Close()
+		return existing
+	}
+
+	sharedNotaries.notaries[key] = candidate
+	return candidate
+}
+
+// NewNotary verifies tokens against the key set at WithTarget. Close it when
+// it is no longer needed:
+//
+//	n := authorizer.NewNotary(opts...)
+//	defer n.Close()
 func NewNotary(opts ...notaryOpt) *notary {
 	notary := &notary{
 		Algorithms: []jose.SignatureAlgorithm{jose.RS256},
+		Now:        time.Now,
+		Leeway:     jwt.DefaultLeeway,
 	}
 
 	for _, opt := range opts {
@@ -66,37 +264,223 @@ func NewNotary(opts ...notaryOpt) *notary {
 		WithHttpClient(http.DefaultClient)(notary)
 	}
 
+	if notary.InlineRefreshDisabled && notary.RefreshInterval <= 0 {
+		notary.configErr = fmt.Errorf("%w: inline refresh is disabled without a refresh interval", ErrInvalidConfiguration)
+	}
+
+	for _, glob := range notary.AudiencePatterns {
+		pattern, err := audiencePattern(glob)
+		if err != nil {
+			notary.configErr = err
+			break
+		}
+		notary.audiencePatterns = append(notary.audiencePatterns, pattern)
+	}
+
+	if notary.RefreshInterval > 0 && (notary.URL != nil || notary.Discovery != nil) {
+		notary.workers.Go(notary.refreshEvery)
+	}
+
 	return notary
 }
 
 type notary struct {
-	sync.Mutex
+	sync.RWMutex
 	*url.URL
 	*http.Client
+	Discovery *url.URL
 	*jose.JSONWebKeySet
 	Audience   []string
+	Issuers    []string
 	Algorithms []jose.SignatureAlgorithm
+	Unshared   bool
+	Now        func() time.Time
+	Leeway     time.Duration
+
+	IssuedAtLeeway *time.Duration
+
+	NormalizeAudience   bool
+	AllowMissingExpiry  bool
+	MaxTokenAge         time.Duration
+	DeprecatedAudiences []string
+	AudiencePatterns    []string
+
+	EmbeddedKeyThumbprints []string
+
+	RefreshInterval       time.Duration
+	InlineRefreshDisabled bool
+
+	audiencePatterns []*regexp.Regexp
+
+	// refresh serializes key set fetches, which are made without holding the
+	// notary's lock so that verification never waits on the network.
+	refresh sync.Mutex
+
+	// discovered caches the key set URL resolved through Discovery.
+	discovered *url.URL
+
+	configErr      error
+	generation     uint64
+	lastRefresh    time.Time
+	lastRefreshErr error
+	throttledUntil time.Time
+	failures       int
+	backoffUntil   time.Time
+	workers        workers
+	stats          notaryStats
+}
+
+type NotaryStats struct {
+	DeprecatedAudienceTokens uint64
+	// FutureIssuedAtTokens counts tokens issued in the future that were
+	// accepted within the issued at leeway, as a measure of clock drift.
+	FutureIssuedAtTokens uint64
+}
+
+type notaryStats struct {
+	deprecatedAudienceTokens atomic.Uint64
+	futureIssuedAtTokens     atomic.Uint64
+}
+
+func (n *notary) Stats() NotaryStats {
+	return NotaryStats{
+		DeprecatedAudienceTokens: n.stats.deprecatedAudienceTokens.Load(),
+		FutureIssuedAtTokens:     n.stats.futureIssuedAtTokens.Load(),
+	}
+}
+
+type Claims struct {
+	Raw          map[string]interface{}
+	Header       map[string]string
+	Expiry       time.Time
+	TimeToExpiry time.Duration
+
+	// DeprecatedAudience is set when the token was accepted only because of
+	// an audience passed to WithDeprecatedAudiences.
+	DeprecatedAudience string
+}
+
+// exposedHeaders lists the protected header fields copied into Claims.Header.
+// Anything else, such as embedded keys or certificate chains, is withheld.
+var exposedHeaders = []string{"kid", "alg", "typ", "cty"}
+
+func tokenHeader(header jose.Header) map[string]string {
+
+	values := map[string]string{
+		"kid": header.KeyID,
+		"alg": header.Algorithm,
+	}
+
+	for _, key := range []jose.HeaderKey{jose.HeaderType, jose.HeaderContentType} {
+		if value, ok := header.ExtraHeaders[key].(string); ok {
+			values[string(key)] = value
+		}
+	}
+
+	for key, value := range values {
+		if value == "" {
+			delete(values, key)
+		}
+	}
+
+	return values
+}
+
+// Close stops the notary's background work and waits for it to finish. It is
+// safe to call more than once.
+func (n *notary) Close() error {
+	return n.workers.Close()
+}
+
+func (n *notary) sharingKey() string {
+	auds := append([]string{}, n.Audience...)
+	sort.Strings(auds)
+
+	algs := []string{}
+	for _, alg := range n.Algorithms {
+		algs = append(algs, string(alg))
+	}
+	sort.Strings(algs)
+
+	deprecated := append([]string{}, n.DeprecatedAudiences...)
+	sort.Strings(deprecated)
+
+	thumbprints := append([]string{}, n.EmbeddedKeyThumbprints...)
+	sort.Strings(thumbprints)
+
+	issuers := append([]string{}, n.Issuers...)
+	sort.Strings(issuers)
+
+	patterns := append([]string{}, n.AudiencePatterns...)
+	sort.Strings(patterns)
+
+	issuedAtLeeway := "-"
+	if n.IssuedAtLeeway != nil {
+		issuedAtLeeway = n.IssuedAtLeeway.String()
+	}
+
+	target := n.URL
+	if target == nil {
+		target = n.Discovery
+	}
+
+	return target.String() + "|" + strings.Join(auds, ",") + "|" + strings.Join(algs, ",") + "|" + strings.Join(deprecated, ",") + "|" + strings.Join(thumbprints, ",") + "|" + strings.Join(issuers, ",") +
+		"|" + n.RefreshInterval.String() + "|" + strconv.FormatBool(n.InlineRefreshDisabled) + "|" + strings.Join(patterns, ",") +
+		"|" + n.Leeway.String() + "|" + issuedAtLeeway + "|" + strconv.FormatBool(n.AllowMissingExpiry) + "|" + n.MaxTokenAge.String() + "|" + strconv.FormatBool(n.NormalizeAudience)
+}
+
+// shareable reports whether the notary uses the default http client and
+// clock, which, unlike other options, cannot be compared for a sharing key.
+func (n *notary) shareable() bool {
+	return n.Client == http.DefaultClient && reflect.ValueOf(n.Now).Pointer() == reflect.ValueOf(time.Now).Pointer()
 }
 
 func (n *notary) Notarize(token string) (map[string]interface{}, error) {
 
-	raw, err := n.notarize(token)
+	claims, err := n.NotarizeClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims.Raw, nil
+}
+
+func (n *notary) NotarizeClaims(token string) (*Claims, error) {
+
+	if n.configErr != nil {
+		return nil, n.configErr
+	}
+
+	keySet, generation := n.keySet()
+
+	claims, err := n.notarize(token, keySet)
 
 	switch err {
 	case ErrNoPublicKey, ErrInvalidSignature:
-		if err = n.refreshKeySet(); err != nil {
+		if n.InlineRefreshDisabled {
 			return nil, err
 		}
-		return n.notarize(token)
+		if err = n.refreshKeySetAfter(context.Background(), generation); err != nil {
+			return nil, err
+		}
+		keySet, _ = n.keySet()
+		return n.notarize(token, keySet)
 	default:
-		return raw, err
+		return claims, err
 	}
 }
 
-func (n *notary) notarize(token string) (map[string]interface{}, error) {
+func (n *notary) keySet() (*jose.JSONWebKeySet, uint64) {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.JSONWebKeySet, n.generation
+}
+
+func (n *notary) notarize(token string, keySet *jose.JSONWebKeySet) (*Claims, error) {
 
-	if n.JSONWebKeySet == nil {
-		return nil, ErrNoPublicKey
+	if err := inspectToken(token); err != nil {
+		return nil, err
 	}
 
 	parsed, err := jwt.ParseSigned(token, n.Algorithms)
@@ -107,49 +491,440 @@ func (n *notary) notarize(token string) (map[string]interface{}, error) {
 	var claims jwt.Claims
 	var raw map[string]interface{}
 
-	if err = parsed.Claims(n.JSONWebKeySet, &claims, &raw); err != nil {
-		return nil, ErrInvalidSignature
+	if embedded := parsed.Headers[0].JSONWebKey; embedded != nil && len(n.EmbeddedKeyThumbprints) > 0 {
+		if err = n.trustEmbeddedKey(embedded); err != nil {
+			return nil, err
+		}
+		if err = parsed.Claims(embedded, &claims, &raw); err != nil {
+			// Wrapped so that NotarizeClaims does not refresh the key set,
+			// which cannot help.
+			return nil, fmt.Errorf("%w: embedded key", ErrInvalidSignature)
+		}
+	} else {
+		if keySet == nil {
+			return nil, ErrNoPublicKey
+		}
+		if err = parsed.Claims(keySet, &claims, &raw); err != nil {
+			return nil, ErrInvalidSignature
+		}
 	}
 
-	if err = claims.Validate(jwt.Expected{Time: time.Now()}); err != nil {
+	now := n.Now()
+
+	// The issued at claim is checked separately, against its own leeway.
+	withoutIssuedAt := claims
+	withoutIssuedAt.IssuedAt = nil
+
+	if err = withoutIssuedAt.ValidateWithLeeway(jwt.Expected{Time: now}, n.Leeway); err != nil {
+		if err == jwt.ErrExpired && claims.Expiry != nil {
+			return nil, &TokenExpiredError{claims.Expiry.Time(), n.Leeway}
+		}
+		if err == jwt.ErrNotValidYet {
+			return nil, ErrTokenNotYetValid
+		}
 		return nil, ErrTokenExpired
 	}
 
+	if err = n.checkIssuedAt(claims, now); err != nil {
+		return nil, err
+	}
+
+	expiry, err := n.expiry(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(n.Issuers) > 0 && !slices.Contains(n.Issuers, claims.Issuer) {
+		return nil, ErrInvalidIssuer
+	}
+
+	result := &Claims{Raw: raw, Header: tokenHeader(parsed.Headers[0])}
+
+	if !expiry.IsZero() {
+		result.Expiry = expiry
+		result.TimeToExpiry = expiry.Add(n.Leeway).Sub(now)
+
+		if result.TimeToExpiry <= 0 {
+			return nil, &TokenExpiredError{expiry, n.Leeway}
+		}
+	}
+
+	if n.NormalizeAudience && raw[audKey] != nil {
+		raw[audKey] = []string(claims.Audience)
+	}
+
 	for _, aud := range n.Audience {
 		if claims.Audience.Contains(aud) {
-			return raw, nil
+			return result, nil
+		}
+	}
+
+	for _, pattern := range n.audiencePatterns {
+		for _, aud := range claims.Audience {
+			if pattern.MatchString(aud) {
+				return result, nil
+			}
+		}
+	}
+
+	for _, aud := range n.DeprecatedAudiences {
+		if claims.Audience.Contains(aud) {
+			n.stats.deprecatedAudienceTokens.Add(1)
+			result.DeprecatedAudience = aud
+			return result, nil
 		}
 	}
 
 	return nil, ErrInvalidAudience
 }
 
-func (n *notary) refreshKeySet() error {
+// audiencePattern compiles an audience glob into an anchored expression.
+// Globs without any literal part would accept every audience and are refused.
+func audiencePattern(glob string) (*regexp.Regexp, error) {
+	if strings.Trim(glob, "*") == "" {
+		return nil, fmt.Errorf("%w: audience pattern %q matches any audience", ErrInvalidConfiguration, glob)
+	}
+
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.Compile("^" + strings.Join(parts, "[^/]*") + "$")
+}
+
+func (n *notary) trustEmbeddedKey(key *jose.JSONWebKey) error {
+
+	if !key.Valid() || !key.IsPublic() {
+		return ErrUntrustedEmbeddedKey
+	}
+
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ErrUntrustedEmbeddedKey
+	}
+
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum)
+
+	for _, pinned := range n.EmbeddedKeyThumbprints {
+		if thumbprint == pinned {
+			return nil
+		}
+	}
+
+	return ErrUntrustedEmbeddedKey
+}
+
+func (n *notary) checkIssuedAt(claims jwt.Claims, now time.Time) error {
+
+	if claims.IssuedAt == nil || !claims.IssuedAt.Time().After(now) {
+		return nil
+	}
+
+	leeway := n.Leeway
+	if n.IssuedAtLeeway != nil {
+		leeway = *n.IssuedAtLeeway
+	}
+
+	if claims.IssuedAt.Time().After(now.Add(leeway)) {
+		return ErrTokenNotYetValid
+	}
+
+	n.stats.futureIssuedAtTokens.Add(1)
+	return nil
+}
+
+func (n *notary) expiry(claims jwt.Claims) (time.Time, error) {
+
+	var expiry time.Time
+	if claims.Expiry != nil {
+		expiry = claims.Expiry.Time()
+	}
+
+	if n.MaxTokenAge > 0 {
+		if claims.IssuedAt == nil {
+			return time.Time{}, ErrMissingIssuedAt
+		}
+
+		maxExpiry := claims.IssuedAt.Time().Add(n.MaxTokenAge)
+		if expiry.IsZero() || maxExpiry.Before(expiry) {
+			expiry = maxExpiry
+		}
+	}
+
+	if expiry.IsZero() && !n.AllowMissingExpiry {
+		return time.Time{}, ErrMissingExpiry
+	}
+
+	return expiry, nil
+}
+
+func inspectToken(token string) error {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	var header map[string]interface{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return ErrInvalidToken
+	}
+
+	alg, _ := header["alg"].(string)
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return ErrInsecureAlgorithm
+	}
+
+	if parts[2] == "" {
+		return ErrInsecureAlgorithm
+	}
+
+	return nil
+}
+
+// ParseInsecure decodes the header and claims of token without verifying its
+// signature, expiry or audience, so that diagnostic tools can show what a
+// client sent. Never authorize anything based on its result.
+func ParseInsecure(token string) (header map[string]any, claims map[string]any, err error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, nil, fmt.Errorf("%w: claims: %w", ErrInvalidToken, err)
+	}
+
+	return header, claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// ValidateOnStartup fetches the key set and checks that it holds a key usable
+// with the configured algorithms and that an audience is configured, so that
+// a misconfigured notary is caught before it starts rejecting real users.
+func (n *notary) ValidateOnStartup(ctx context.Context) error {
+
+	var errs []error
+
+	if n.configErr != nil {
+		errs = append(errs, n.configErr)
+	}
+
+	if len(n.Audience) == 0 && len(n.AudiencePatterns) == 0 {
+		errs = append(errs, ErrNoAudienceSet)
+	}
+
+	if err := n.refreshKeySetContext(ctx); err != nil {
+		errs = append(errs, err)
+	} else if !n.hasUsableKey() {
+		errs = append(errs, ErrNoUsableKeys)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (n *notary) hasUsableKey() bool {
+	keySet, _ := n.keySet()
+
+	for _, key := range keySet.Keys {
+		if !key.Valid() || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		if key.Algorithm == "" {
+			return true
+		}
+
+		for _, alg := range n.Algorithms {
+			if key.Algorithm == string(alg) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Refresh fetches the key set now.
+func (n *notary) Refresh(ctx context.Context) error {
+	return n.refreshKeySetContext(ctx)
+}
+
+func (n *notary) refreshEvery(done <-chan struct{}) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(n.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		n.refreshKeySetContext(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (n *notary) refreshKeySetContext(ctx context.Context) error {
+	n.refresh.Lock()
+	defer n.refresh.Unlock()
+
+	return n.fetchAndStoreKeySet(ctx)
+}
+
+// refreshKeySetAfter refreshes the key set unless another caller already did
+// so since generation was observed, so that concurrent callers that all found
+// the same stale key set share a single fetch. After a failed fetch, callers
+// get the failure back without fetching again until the backoff has passed.
+func (n *notary) refreshKeySetAfter(ctx context.Context, generation uint64) error {
+	n.refresh.Lock()
+	defer n.refresh.Unlock()
+
+	n.RLock()
+	refreshed, backoffUntil, lastErr := n.generation != generation, n.backoffUntil, n.lastRefreshErr
+	n.RUnlock()
+
+	if refreshed {
+		return nil
+	}
+
+	if lastErr != nil && n.Now().Before(backoffUntil) {
+		return lastErr
+	}
+
+	return n.fetchAndStoreKeySet(ctx)
+}
+
+// fetchAndStoreKeySet must be called with n.refresh held.
+func (n *notary) fetchAndStoreKeySet(ctx context.Context) error {
+
+	if throttledUntil := n.ThrottledUntil(); n.Now().Before(throttledUntil) {
+		return fmt.Errorf("%w: %w until %s", ErrKeySetUnavailable, ErrKeyFetchThrottled, throttledUntil.Format(time.RFC3339))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, keyFetchTimeout)
+	defer cancel()
+
+	keySet, err := n.fetchKeySet(ctx)
+
 	n.Lock()
 	defer n.Unlock()
 
-	keySet, err := n.fetchKeySet()
 	if err != nil {
-		return err
+		n.lastRefreshErr = fmt.Errorf("%w: %w", ErrKeySetUnavailable, err)
+		n.backoffUntil = n.Now().Add(keyFetchBackoff(n.failures))
+		n.failures++
+		return n.lastRefreshErr
 	}
 
 	n.JSONWebKeySet = keySet
+	n.generation++
+	n.lastRefresh = n.Now()
+	n.lastRefreshErr = nil
+	n.failures = 0
+	n.backoffUntil = time.Time{}
 	return nil
 }
 
-func (n *notary) fetchKeySet() (*jose.JSONWebKeySet, error) {
+const (
+	maxKeyFetchThrottle = 5 * time.Minute
+	keyFetchTimeout     = 10 * time.Second
+	minKeyFetchBackoff  = time.Second
+	maxKeyFetchBackoff  = time.Minute
+)
 
-	if n.URL == nil {
-		return nil, ErrNoTargetSet
+// keyFetchBackoff doubles the wait after each consecutive failed fetch.
+func keyFetchBackoff(failures int) time.Duration {
+	backoff := minKeyFetchBackoff
+	for i := 0; i < failures && backoff < maxKeyFetchBackoff; i++ {
+		backoff *= 2
 	}
+	return min(backoff, maxKeyFetchBackoff)
+}
+
+// LastRefresh reports when the key set was last fetched and the error from
+// the most recent attempt, if it failed.
+func (n *notary) LastRefresh() (time.Time, error) {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.lastRefresh, n.lastRefreshErr
+}
+
+// ThrottledUntil reports when the key set endpoint, having answered 429 or
+// 503 with Retry-After, may be fetched from again: at most five minutes, or
+// the refresh interval if shorter, after the response. Until then refreshes
+// fail with ErrKeyFetchThrottled and the cached keys stay in use.
+func (n *notary) ThrottledUntil() time.Time {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.throttledUntil
+}
+
+// KeyCount reports the number of keys in the current key set.
+func (n *notary) KeyCount() int {
+	keySet, _ := n.keySet()
+	if keySet == nil {
+		return 0
+	}
+	return len(keySet.Keys)
+}
+
+func (n *notary) fetchKeySet(ctx context.Context) (*jose.JSONWebKeySet, error) {
 
-	resp, err := n.Client.Get(n.URL.String())
+	target, err := n.keySetURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := n.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After"), n.Now()); ok {
+			n.Lock()
+			n.throttledUntil = n.Now().Add(min(delay, n.maxThrottle()))
+			n.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrKeyFetchThrottled, resp.Status)
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("Failed to fetch public key: " + resp.Status)
 	}
@@ -165,3 +940,89 @@ func (n *notary) fetchKeySet() (*jose.JSONWebKeySet, error) {
 
 	return &data, nil
 }
+
+// keySetURL returns the WithTarget URL, or else the jwks_uri of the discovery
+// document, which is only fetched until it has been resolved once.
+func (n *notary) keySetURL(ctx context.Context) (*url.URL, error) {
+
+	if n.URL != nil {
+		return n.URL, nil
+	}
+
+	if n.Discovery == nil {
+		return nil, ErrNoTargetSet
+	}
+
+	n.RLock()
+	discovered := n.discovered
+	n.RUnlock()
+
+	if discovered != nil {
+		return discovered, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.Discovery.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Failed to fetch discovery document: " + resp.Status)
+	}
+
+	var document struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if document.JwksURI == "" {
+		return nil, ErrNoKeySetURI
+	}
+
+	if discovered, err = url.Parse(document.JwksURI); err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	n.discovered = discovered
+	n.Unlock()
+
+	return discovered, nil
+}
+
+// maxThrottle bounds how long a Retry-After may hold off fetches, so that an
+// endpoint cannot keep rotated keys out for longer than a refresh interval.
+func (n *notary) maxThrottle() time.Duration {
+	if n.RefreshInterval > 0 {
+		return min(n.RefreshInterval, maxKeyFetchThrottle)
+	}
+	return maxKeyFetchThrottle
+}
+
+// retryAfter parses a Retry-After value, either a number of seconds or an
+// HTTP date, into a delay from now.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds > 0
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return at.Sub(now), at.After(now)
+	}
+
+	return 0, false
+}
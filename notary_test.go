@@ -1,12 +1,21 @@
 package authorizer_test
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	"github.com/go-jose/go-jose/v4"
@@ -19,6 +28,14 @@ type Notary interface {
 	Notarize(token string) (map[string]interface{}, error)
 }
 
+type ClaimsNotary interface {
+	NotarizeClaims(token string) (*authorizer.Claims, error)
+}
+
+type StartupValidator interface {
+	ValidateOnStartup(ctx context.Context) error
+}
+
 var _ = Describe("Notary", func() {
 	var (
 		notary Notary
@@ -62,16 +79,7 @@ var _ = Describe("Notary", func() {
 	Describe("Notarize", func() {
 
 		JustBeforeEach(func() {
-			var signer jose.Signer
-			signingKey := jose.SigningKey{Algorithm: jose.RS256, Key: privateKey}
-			signer, err = jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "some-key"))
-			Expect(err).NotTo(HaveOccurred())
-
-			var token string
-			token, err = jwt.Signed(signer).Claims(claims).Serialize()
-			Expect(err).NotTo(HaveOccurred())
-
-			res, err = notary.Notarize(token)
+			res, err = notary.Notarize(signToken(privateKey, claims))
 		})
 
 		BeforeEach(func() {
@@ -92,7 +100,7 @@ var _ = Describe("Notary", func() {
 			})
 
 			It("errors", func() {
-				Expect(err).To(HaveOccurred())
+				Expect(err).To(MatchError(authorizer.ErrKeySetUnavailable))
 			})
 		})
 
@@ -112,6 +120,1270 @@ var _ = Describe("Notary", func() {
 				Expect(res["iss"]).To(Equal("issuer"))
 				Expect(res["aud"]).To(Equal("audience"))
 			})
+
+			Context("when configured to normalize the audience", func() {
+				BeforeEach(func() {
+					notary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.NormalizeAudience(),
+					)
+				})
+
+				Context("when the audience is encoded as a string", func() {
+					It("returns the audience as a list", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(res["aud"]).To(Equal([]string{"audience"}))
+					})
+				})
+
+				Context("when the audience is encoded as a list", func() {
+					BeforeEach(func() {
+						claims.Audience = jwt.Audience{"audience", "other-audience"}
+					})
+
+					It("returns the audience as a list", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(res["aud"]).To(Equal([]string{"audience", "other-audience"}))
+					})
+				})
+			})
+
+			Context("when the token has expired", func() {
+				BeforeEach(func() {
+					claims.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				})
+
+				It("errors with the expiry", func() {
+					Expect(err).To(MatchError(authorizer.ErrTokenExpired))
+
+					var expired *authorizer.TokenExpiredError
+					Expect(errors.As(err, &expired)).To(BeTrue())
+					Expect(expired.Expiry).To(BeTemporally("~", time.Now().Add(-time.Hour), time.Second))
+				})
+			})
 		})
 	})
-})
+
+	Describe("NotarizeClaims", func() {
+		var (
+			now          time.Time
+			typed        *authorizer.Claims
+			claimsNotary ClaimsNotary
+		)
+
+		clock := func() time.Time {
+			return now
+		}
+
+		BeforeEach(func() {
+			now = time.Now().Truncate(time.Second)
+
+			claimsNotary = authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithClock(clock),
+				authorizer.WithLeeway(0),
+			)
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/token_keys"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+				),
+			)
+		})
+
+		JustBeforeEach(func() {
+			typed, err = claimsNotary.NotarizeClaims(signToken(privateKey, claims))
+		})
+
+		Context("when the token expires in ten minutes", func() {
+			BeforeEach(func() {
+				claims.Expiry = jwt.NewNumericDate(now.Add(10 * time.Minute))
+			})
+
+			It("reports the time to expiry", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.Expiry).To(Equal(now.Add(10 * time.Minute)))
+				Expect(typed.TimeToExpiry).To(Equal(10 * time.Minute))
+				Expect(typed.Raw["sub"]).To(Equal("subject"))
+			})
+		})
+
+		Context("when the token is verified", func() {
+			It("reports the whitelisted header values", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.Header).To(Equal(map[string]string{
+					"kid": "some-key",
+					"alg": "RS256",
+					"typ": "JWT",
+				}))
+			})
+		})
+
+		Context("when the token expires in an hour", func() {
+			BeforeEach(func() {
+				claims.Expiry = jwt.NewNumericDate(now.Add(time.Hour))
+			})
+
+			It("reports the time to expiry", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.TimeToExpiry).To(Equal(time.Hour))
+			})
+		})
+
+		Context("when the token has expired within the leeway", func() {
+			BeforeEach(func() {
+				claimsNotary = authorizer.NewNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+					authorizer.WithClock(clock),
+					authorizer.WithLeeway(time.Minute),
+				)
+
+				claims.Expiry = jwt.NewNumericDate(now.Add(-30 * time.Second))
+			})
+
+			It("reports the time remaining within the leeway", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.TimeToExpiry).To(Equal(30 * time.Second))
+			})
+		})
+
+		Context("when the token expires right now", func() {
+			BeforeEach(func() {
+				claims.Expiry = jwt.NewNumericDate(now)
+			})
+
+			It("errors rather than reporting a zero time to expiry", func() {
+				Expect(err).To(MatchError(authorizer.ErrTokenExpired))
+				Expect(typed).To(BeNil())
+			})
+		})
+
+		Context("when the token has no expiry", func() {
+			BeforeEach(func() {
+				claims.Expiry = nil
+			})
+
+			Context("by default", func() {
+				It("errors", func() {
+					Expect(err).To(Equal(authorizer.ErrMissingExpiry))
+				})
+			})
+
+			Context("when expiry is explicitly required", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.AllowMissingExpiry(),
+						authorizer.RequireExpiry(),
+					)
+				})
+
+				It("errors", func() {
+					Expect(err).To(Equal(authorizer.ErrMissingExpiry))
+				})
+			})
+
+			Context("when missing expiry is allowed", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.AllowMissingExpiry(),
+					)
+				})
+
+				It("succeeds without a time to expiry", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(typed.Expiry).To(BeZero())
+					Expect(typed.TimeToExpiry).To(BeZero())
+				})
+			})
+
+			Context("when a max token age is configured", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.WithClock(clock),
+						authorizer.WithLeeway(0),
+						authorizer.WithMaxTokenAge(time.Hour),
+					)
+				})
+
+				Context("when the token was recently issued", func() {
+					BeforeEach(func() {
+						claims.IssuedAt = jwt.NewNumericDate(now.Add(-10 * time.Minute))
+					})
+
+					It("uses the max age in place of the expiry", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(typed.TimeToExpiry).To(Equal(50 * time.Minute))
+					})
+				})
+
+				Context("when the token is older than the max age", func() {
+					BeforeEach(func() {
+						claims.IssuedAt = jwt.NewNumericDate(now.Add(-2 * time.Hour))
+					})
+
+					It("errors", func() {
+						Expect(err).To(MatchError(authorizer.ErrTokenExpired))
+					})
+				})
+
+				Context("when the token has no issued at", func() {
+					It("errors", func() {
+						Expect(err).To(Equal(authorizer.ErrMissingIssuedAt))
+					})
+				})
+			})
+		})
+
+		Context("when the token is not valid yet", func() {
+			BeforeEach(func() {
+				claims.Expiry = jwt.NewNumericDate(now.Add(time.Hour))
+				claims.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+			})
+
+			It("errors", func() {
+				Expect(err).To(MatchError(authorizer.ErrTokenNotYetValid))
+			})
+		})
+
+		Context("when the token was issued slightly in the future", func() {
+			BeforeEach(func() {
+				claims.Expiry = jwt.NewNumericDate(now.Add(time.Hour))
+				claims.IssuedAt = jwt.NewNumericDate(now.Add(2 * time.Second))
+			})
+
+			Context("without leeway", func() {
+				It("errors", func() {
+					Expect(err).To(MatchError(authorizer.ErrTokenNotYetValid))
+					Expect(err).NotTo(MatchError(authorizer.ErrTokenExpired))
+				})
+			})
+
+			Context("with the default leeway", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.WithClock(clock),
+					)
+				})
+
+				It("accepts the token and counts it", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(claimsNotary.(interface{ Stats() authorizer.NotaryStats }).Stats().FutureIssuedAtTokens).To(Equal(uint64(1)))
+				})
+			})
+
+			Context("with an issued at leeway", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.WithClock(clock),
+						authorizer.WithLeeway(0),
+						authorizer.WithIssuedAtLeeway(5*time.Second),
+					)
+				})
+
+				It("accepts the token", func() {
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("keeps the expiry leeway separate", func() {
+					_, err := claimsNotary.NotarizeClaims(signToken(privateKey, jwt.Claims{
+						Audience: jwt.Audience{"audience"},
+						Expiry:   jwt.NewNumericDate(now.Add(-2 * time.Second)),
+					}))
+					Expect(err).To(MatchError(authorizer.ErrTokenExpired))
+				})
+			})
+
+			Context("with an issued at leeway too small for the skew", func() {
+				BeforeEach(func() {
+					claimsNotary = authorizer.NewNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+						authorizer.WithClock(clock),
+						authorizer.WithLeeway(time.Minute),
+						authorizer.WithIssuedAtLeeway(time.Second),
+					)
+				})
+
+				It("errors", func() {
+					Expect(err).To(MatchError(authorizer.ErrTokenNotYetValid))
+				})
+			})
+		})
+
+		Context("when the token outlives the max token age", func() {
+			BeforeEach(func() {
+				claimsNotary = authorizer.NewNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+					authorizer.WithClock(clock),
+					authorizer.WithLeeway(0),
+					authorizer.WithMaxTokenAge(time.Hour),
+				)
+
+				claims.IssuedAt = jwt.NewNumericDate(now.Add(-30 * time.Minute))
+				claims.Expiry = jwt.NewNumericDate(now.Add(24 * time.Hour))
+			})
+
+			It("bounds the time to expiry by the max age", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.TimeToExpiry).To(Equal(30 * time.Minute))
+			})
+		})
+	})
+
+	Describe("Notarize insecure tokens", func() {
+		var token string
+
+		encode := func(segment string) string {
+			return base64.RawURLEncoding.EncodeToString([]byte(segment))
+		}
+
+		BeforeEach(func() {
+			notary = authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+			)
+		})
+
+		JustBeforeEach(func() {
+			res, err = notary.Notarize(token)
+		})
+
+		Context("when the token uses the none algorithm", func() {
+			BeforeEach(func() {
+				token = encode(`{"alg":"none","typ":"JWT"}`) + "." + encode(`{"sub":"subject","aud":"audience"}`) + "."
+			})
+
+			It("errors without consulting the key set", func() {
+				Expect(err).To(Equal(authorizer.ErrInsecureAlgorithm))
+				Expect(res).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the token uses a differently cased none algorithm", func() {
+			BeforeEach(func() {
+				token = encode(`{"alg":"NoNe"}`) + "." + encode(`{"sub":"subject"}`) + ".c2ln"
+			})
+
+			It("errors", func() {
+				Expect(err).To(Equal(authorizer.ErrInsecureAlgorithm))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the token has no algorithm", func() {
+			BeforeEach(func() {
+				token = encode(`{"typ":"JWT"}`) + "." + encode(`{"sub":"subject"}`) + ".c2ln"
+			})
+
+			It("errors", func() {
+				Expect(err).To(Equal(authorizer.ErrInsecureAlgorithm))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the token has an empty signature", func() {
+			BeforeEach(func() {
+				token = encode(`{"alg":"RS256","kid":"some-key"}`) + "." + encode(`{"sub":"subject"}`) + "."
+			})
+
+			It("errors", func() {
+				Expect(err).To(Equal(authorizer.ErrInsecureAlgorithm))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the token only has two segments", func() {
+			BeforeEach(func() {
+				token = encode(`{"alg":"RS256"}`) + "." + encode(`{"sub":"subject"}`)
+			})
+
+			It("errors", func() {
+				Expect(err).To(Equal(authorizer.ErrInvalidToken))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("WithEmbeddedKeyThumbprints", func() {
+		var (
+			token       string
+			attacker    *rsa.PrivateKey
+			thumbprint  string
+			embedded    jose.JSONWebKey
+			thumbprints []string
+		)
+
+		BeforeEach(func() {
+			attacker, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			embedded = jose.JSONWebKey{Key: &privateKey.PublicKey}
+			sum, err := embedded.Thumbprint(crypto.SHA256)
+			Expect(err).NotTo(HaveOccurred())
+			thumbprint = base64.RawURLEncoding.EncodeToString(sum)
+
+			thumbprints = []string{thumbprint}
+		})
+
+		JustBeforeEach(func() {
+			notary = authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithEmbeddedKeyThumbprints(thumbprints...),
+			)
+
+			res, err = notary.Notarize(token)
+		})
+
+		Context("when the embedded key is pinned", func() {
+			BeforeEach(func() {
+				token = signTokenWithEmbeddedKey(privateKey, claims)
+			})
+
+			It("verifies the token without fetching the key set", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res["sub"]).To(Equal("subject"))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the embedded key is not pinned", func() {
+			BeforeEach(func() {
+				token = signTokenWithEmbeddedKey(attacker, claims)
+			})
+
+			It("never trusts it", func() {
+				Expect(err).To(MatchError(authorizer.ErrUntrustedEmbeddedKey))
+				Expect(res).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when a pinned key is embedded in a token signed by another key", func() {
+			BeforeEach(func() {
+				signer, err := jose.NewSigner(
+					jose.SigningKey{Algorithm: jose.RS256, Key: attacker},
+					(&jose.SignerOptions{}).WithType("JWT").WithHeader("jwk", embedded),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				token, err = jwt.Signed(signer).Claims(claims).Serialize()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("rejects the signature", func() {
+				Expect(err).To(MatchError(authorizer.ErrInvalidSignature))
+				Expect(res).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when no thumbprints are pinned", func() {
+			BeforeEach(func() {
+				thumbprints = nil
+				token = signTokenWithEmbeddedKey(attacker, claims)
+
+				server.AppendHandlers(
+					ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+				)
+			})
+
+			It("ignores the embedded key and verifies against the key set", func() {
+				Expect(err).To(MatchError(authorizer.ErrInvalidSignature))
+				Expect(res).To(BeNil())
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("Notarize concurrently on a cold start", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/token_keys",
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+			)
+
+			notary = authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+			)
+		})
+
+		It("fetches the key set once", func() {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(16))
+
+			token := signToken(privateKey, claims)
+
+			start := make(chan struct{})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					<-start
+					_, err := notary.Notarize(token)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe("IncludeHeaderInContextAs", func() {
+		var req *http.Request
+
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/token_keys"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+				),
+			)
+
+			req, err = http.NewRequest("GET", "http://localhost/some/path", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Authorization", "Bearer "+signToken(privateKey, claims))
+		})
+
+		It("exposes the verifying key id and algorithm downstream", func() {
+			var kid, alg interface{}
+
+			handler := authorizer.NewHandler(
+				newLogger(),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					kid = r.Context().Value(authorizer.ContextKey("key_id"))
+					alg = r.Context().Value(authorizer.ContextKey("algorithm"))
+				}),
+				authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(authorizer.NewNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+				)))),
+				authorizer.IncludeHeaderInContextAs("kid", "key_id"),
+				authorizer.IncludeHeaderInContextAs("alg", "algorithm"),
+			)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(kid).To(Equal("some-key"))
+			Expect(alg).To(Equal("RS256"))
+		})
+
+		It("refuses headers outside the whitelist", func() {
+			handler := authorizer.NewHandler(
+				newLogger(),
+				http.NotFoundHandler(),
+				authorizer.IncludeHeaderInContextAs("jwk", "key"),
+			)
+
+			Expect(handler.Validate()).To(MatchError(authorizer.ErrUnsupportedHeader))
+		})
+	})
+
+	Describe("WithDeprecatedAudiences", func() {
+		var (
+			deprecating interface {
+				ClaimsNotary
+				Stats() authorizer.NotaryStats
+			}
+			typed *authorizer.Claims
+		)
+
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/token_keys",
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+			)
+
+			deprecating = authorizer.NewNotary(
+				authorizer.WithAudience("api://new"),
+				authorizer.WithDeprecatedAudiences("api://old"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+			)
+		})
+
+		JustBeforeEach(func() {
+			typed, err = deprecating.NotarizeClaims(signToken(privateKey, claims))
+		})
+
+		Context("when the token has only the new audience", func() {
+			BeforeEach(func() {
+				claims.Audience = jwt.Audience{"api://new"}
+			})
+
+			It("accepts the token without flagging it", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.DeprecatedAudience).To(BeEmpty())
+				Expect(deprecating.Stats().DeprecatedAudienceTokens).To(BeZero())
+			})
+		})
+
+		Context("when the token has only the old audience", func() {
+			BeforeEach(func() {
+				claims.Audience = jwt.Audience{"api://old"}
+			})
+
+			It("accepts the token and flags it", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.DeprecatedAudience).To(Equal("api://old"))
+				Expect(deprecating.Stats().DeprecatedAudienceTokens).To(BeEquivalentTo(1))
+
+				ctx := authorizer.ContextWithTypedClaims(context.Background(), typed)
+				aud, ok := authorizer.DeprecatedAudienceFromContext(ctx)
+				Expect(ok).To(BeTrue())
+				Expect(aud).To(Equal("api://old"))
+			})
+		})
+
+		Context("when the token has both audiences", func() {
+			BeforeEach(func() {
+				claims.Audience = jwt.Audience{"api://old", "api://new"}
+			})
+
+			It("accepts the token without flagging it", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typed.DeprecatedAudience).To(BeEmpty())
+				Expect(deprecating.Stats().DeprecatedAudienceTokens).To(BeZero())
+			})
+		})
+
+		Context("when the token has neither audience", func() {
+			BeforeEach(func() {
+				claims.Audience = jwt.Audience{"api://other"}
+			})
+
+			It("rejects the token", func() {
+				Expect(err).To(MatchError(authorizer.ErrInvalidAudience))
+			})
+		})
+	})
+
+	Describe("WithAudiencePattern", func() {
+		var patterned ClaimsNotary
+
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/token_keys",
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+			)
+
+			patterned = authorizer.NewNotary(
+				authorizer.WithAudience("api://prod.example.com"),
+				authorizer.WithAudiencePattern("api://staging-*.example.com"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+			)
+		})
+
+		notarize := func(auds ...string) error {
+			claims.Audience = auds
+			_, err := patterned.NotarizeClaims(signToken(privateKey, claims))
+			return err
+		}
+
+		It("accepts audiences matching the pattern", func() {
+			Expect(notarize("api://staging-eu-1.example.com")).To(Succeed())
+			Expect(notarize("api://staging-us-2.example.com")).To(Succeed())
+		})
+
+		It("accepts exact audiences on the same notary", func() {
+			Expect(notarize("api://prod.example.com")).To(Succeed())
+		})
+
+		It("accepts a token when any of its audiences matches", func() {
+			Expect(notarize("api://other", "api://staging-eu-1.example.com")).To(Succeed())
+		})
+
+		It("rejects lookalike audiences", func() {
+			Expect(notarize("api://staging-eu-1.example.com.evil.com")).To(MatchError(authorizer.ErrInvalidAudience))
+			Expect(notarize("xapi://staging-eu-1.example.com")).To(MatchError(authorizer.ErrInvalidAudience))
+			Expect(notarize("api://staging-eu/.example.com")).To(MatchError(authorizer.ErrInvalidAudience))
+			Expect(notarize("api://staging-eu-1.examplexcom")).To(MatchError(authorizer.ErrInvalidAudience))
+			Expect(notarize("api://prod.example.com.evil.com")).To(MatchError(authorizer.ErrInvalidAudience))
+		})
+
+		It("refuses a pattern that matches any audience", func() {
+			patterned = authorizer.NewNotary(
+				authorizer.WithAudiencePattern("**"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+			)
+
+			Expect(notarize("api://anything")).To(MatchError(authorizer.ErrInvalidConfiguration))
+		})
+	})
+
+	Describe("Notarize after a failed key set fetch", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+
+			server.RouteToHandler("GET", "/token_keys",
+				ghttp.RespondWith(http.StatusInternalServerError, nil),
+			)
+
+			notary = authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithClock(func() time.Time { return now }),
+			)
+		})
+
+		It("backs off before fetching again", func() {
+			token := signToken(privateKey, claims)
+
+			for i := 0; i < 3; i++ {
+				_, err = notary.Notarize(token)
+				Expect(err).To(MatchError(authorizer.ErrKeySetUnavailable))
+			}
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+
+			server.RouteToHandler("GET", "/token_keys",
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+			)
+			now = now.Add(time.Second)
+
+			_, err = notary.Notarize(token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	Describe("ValidateOnStartup", func() {
+		var (
+			validator StartupValidator
+			target    string
+			audiences []string
+		)
+
+		BeforeEach(func() {
+			target = server.URL() + "/token_keys"
+			audiences = []string{"audience"}
+		})
+
+		JustBeforeEach(func() {
+			validator = authorizer.New(authorizer.WithNotary(authorizer.NewNotary(
+				authorizer.WithAudience(audiences...),
+				authorizer.WithTarget(target),
+			)))
+			err = validator.ValidateOnStartup(context.Background())
+		})
+
+		Context("when the configuration is good", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/token_keys"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+					),
+				)
+			})
+
+			It("succeeds", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the target is wrong", func() {
+			BeforeEach(func() {
+				target = server.URL() + "/token_kyes"
+				server.AllowUnhandledRequests = true
+			})
+
+			It("reports the key set as unavailable", func() {
+				Expect(err).To(MatchError(authorizer.ErrKeySetUnavailable))
+			})
+		})
+
+		Context("when the key set is empty", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/token_keys"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, jose.JSONWebKeySet{}),
+					),
+				)
+			})
+
+			It("reports that no keys were found", func() {
+				Expect(err).To(MatchError(authorizer.ErrNoKeysFound))
+			})
+		})
+
+		Context("when no key suits the configured algorithms", func() {
+			BeforeEach(func() {
+				jsonWebKeySet.Keys[0].Algorithm = string(jose.ES256)
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/token_keys"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+					),
+				)
+			})
+
+			It("reports that no keys are usable", func() {
+				Expect(err).To(MatchError(authorizer.ErrNoUsableKeys))
+			})
+		})
+
+		Context("when no audience is set and the target is wrong", func() {
+			BeforeEach(func() {
+				audiences = nil
+				target = server.URL() + "/token_kyes"
+				server.AllowUnhandledRequests = true
+			})
+
+			It("reports every problem", func() {
+				Expect(err).To(MatchError(authorizer.ErrNoAudienceSet))
+				Expect(err).To(MatchError(authorizer.ErrKeySetUnavailable))
+			})
+		})
+	})
+
+	Describe("Throttled key fetches", func() {
+		var (
+			now time.Time
+
+			throttled interface {
+				Notary
+				ThrottledUntil() time.Time
+			}
+
+			otherKey   *rsa.PrivateKey
+			otherToken string
+		)
+
+		BeforeEach(func() {
+			now = time.Now().Truncate(time.Second)
+
+			otherKey, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			signer, signErr := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: otherKey}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "other-key"))
+			Expect(signErr).NotTo(HaveOccurred())
+			otherToken, err = jwt.Signed(signer).Claims(claims).Serialize()
+			Expect(err).NotTo(HaveOccurred())
+
+			server.AppendHandlers(
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+				ghttp.RespondWith(http.StatusTooManyRequests, nil, http.Header{"Retry-After": {"30"}}),
+			)
+
+			n := authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithClock(func() time.Time { return now }),
+			)
+			throttled = n
+
+			_, err = throttled.Notarize(signToken(privateKey, claims))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = throttled.Notarize(otherToken)
+		})
+
+		It("reports the throttle", func() {
+			Expect(err).To(MatchError(authorizer.ErrKeyFetchThrottled))
+			Expect(throttled.ThrottledUntil()).To(Equal(now.Add(30 * time.Second)))
+		})
+
+		It("keeps serving the cached keys", func() {
+			_, err = throttled.Notarize(signToken(privateKey, claims))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("does not fetch again until Retry-After elapses", func() {
+			_, err = throttled.Notarize(otherToken)
+			Expect(err).To(MatchError(authorizer.ErrKeyFetchThrottled))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+
+			jsonWebKeySet.Keys = append(jsonWebKeySet.Keys, jose.JSONWebKey{
+				KeyID:     "other-key",
+				Use:       "sig",
+				Algorithm: string(jose.RS256),
+				Key:       &otherKey.PublicKey,
+			})
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet))
+
+			now = now.Add(31 * time.Second)
+
+			_, err = throttled.Notarize(otherToken)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("bounds a long Retry-After", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil, http.Header{"Retry-After": {"86400"}}),
+			)
+
+			n := authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithClock(func() time.Time { return now }),
+			)
+
+			_, err = n.Notarize(otherToken)
+			Expect(err).To(MatchError(authorizer.ErrKeyFetchThrottled))
+			Expect(n.ThrottledUntil()).To(Equal(now.Add(5 * time.Minute)))
+		})
+
+		It("accepts Retry-After as an HTTP date", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil, http.Header{"Retry-After": {now.Add(time.Minute).UTC().Format(http.TimeFormat)}}),
+			)
+
+			n := authorizer.NewNotary(
+				authorizer.WithAudience("audience"),
+				authorizer.WithTarget(server.URL()+"/token_keys"),
+				authorizer.WithClock(func() time.Time { return now }),
+			)
+
+			_, err = n.Notarize(otherToken)
+			Expect(err).To(MatchError(authorizer.ErrKeyFetchThrottled))
+			Expect(n.ThrottledUntil()).To(Equal(now.Add(time.Minute)))
+		})
+	})
+
+	Describe("SharedNotary", func() {
+		var token string
+
+		BeforeEach(func() {
+			token = signToken(privateKey, claims)
+		})
+
+		Context("when two authorizers share a notary", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/token_keys"),
+						ghttp.RespondWithJSONEncoded(http.StatusOK, jsonWebKeySet),
+					),
+				)
+			})
+
+			It("fetches the key set once", func() {
+				for i := 0; i < 2; i++ {
+					shared := authorizer.SharedNotary(
+						authorizer.WithAudience("audience"),
+						authorizer.WithTarget(server.URL()+"/token_keys"),
+					)
+
+					req, err := http.NewRequest("GET", "http://localhost", nil)
+					Expect(err).NotTo(HaveOccurred())
+					req.Header.Set("Authorization", "bearer "+token)
+
+					authz := authorizer.New(authorizer.WithNotary(shared))
+					Expect(authz.Authorize(req)).To(Succeed())
+				}
+
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the notaries are configured differently", func() {
+			It("does not share them", func() {
+				first := authorizer.SharedNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+				)
+				second := authorizer.SharedNotary(
+					authorizer.WithAudience("other-audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+				)
+
+				Expect(first).NotTo(BeIdenticalTo(second))
+			})
+		})
+
+		DescribeTable("does not share notaries that verify differently",
+			func(opt func() authorizer.Notary) {
+				first := authorizer.SharedNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+				)
+
+				Expect(opt()).NotTo(BeIdenticalTo(first))
+			},
+			Entry("leeway", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.WithLeeway(time.Hour))
+			}),
+			Entry("issued at leeway", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.WithIssuedAtLeeway(time.Hour))
+			}),
+			Entry("missing expiry", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.AllowMissingExpiry())
+			}),
+			Entry("max token age", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.WithMaxTokenAge(time.Hour))
+			}),
+			Entry("audience normalization", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.NormalizeAudience())
+			}),
+			Entry("http client", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.WithHttpClient(&http.Client{}))
+			}),
+			Entry("clock", func() authorizer.Notary {
+				return authorizer.SharedNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"), authorizer.WithClock(func() time.Time { return time.Now() }))
+			}),
+		)
+
+		Context("when sharing is opted out of", func() {
+			It("returns a new notary", func() {
+				first := authorizer.SharedNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+				)
+				second := authorizer.SharedNotary(
+					authorizer.WithAudience("audience"),
+					authorizer.WithTarget(server.URL()+"/token_keys"),
+					authorizer.WithoutSharing(),
+				)
+
+				Expect(first).NotTo(BeIdenticalTo(second))
+			})
+		})
+
+		Context("when used concurrently", func() {
+			It("returns the same notary", func() {
+				results := make(chan authorizer.Notary, 10)
+
+				for i := 0; i < 10; i++ {
+					go func() {
+						defer GinkgoRecover()
+						results <- authorizer.SharedNotary(
+							authorizer.WithAudience("audience"),
+							authorizer.WithTarget(server.URL()+"/concurrent_keys"),
+						)
+					}()
+				}
+
+				first := <-results
+				for i := 1; i < 10; i++ {
+					Expect(<-results).To(BeIdenticalTo(first))
+				}
+			})
+		})
+	})
+})
+
+var _ = Describe("DisableInlineRefresh", func() {
+
+	var (
+		server     *ghttp.Server
+		privateKey *rsa.PrivateKey
+		otherKey   *rsa.PrivateKey
+		claims     jwt.Claims
+	)
+
+	keySet := func(key *rsa.PrivateKey) jose.JSONWebKeySet {
+		return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			KeyID:     "some-key",
+			Use:       "sig",
+			Algorithm: string(jose.RS256),
+			Key:       &key.PublicKey,
+		}}}
+	}
+
+	BeforeEach(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		otherKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = true
+		server.RouteToHandler("GET", "/", ghttp.RespondWithJSONEncoded(http.StatusOK, keySet(privateKey)))
+
+		claims = jwt.Claims{
+			Subject:  "subject",
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			Audience: jwt.Audience{"audience"},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("never fetches the key set while notarizing", func() {
+		n := authorizer.NewNotary(
+			authorizer.WithTarget(server.URL()),
+			authorizer.WithAudience("audience"),
+			authorizer.WithRefreshInterval(time.Hour),
+			authorizer.DisableInlineRefresh(),
+		)
+		defer n.Close()
+
+		Eventually(n.KeyCount).Should(Equal(1))
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+
+		_, err := n.Notarize(signToken(privateKey, claims))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = n.Notarize(signToken(otherKey, claims))
+		Expect(err).To(MatchError(authorizer.ErrInvalidSignature))
+
+		Consistently(server.ReceivedRequests, 50*time.Millisecond).Should(HaveLen(1))
+	})
+
+	It("uses keys fetched by Refresh", func() {
+		n := authorizer.NewNotary(
+			authorizer.WithTarget(server.URL()),
+			authorizer.WithAudience("audience"),
+			authorizer.WithRefreshInterval(time.Hour),
+			authorizer.DisableInlineRefresh(),
+		)
+		defer n.Close()
+
+		Eventually(n.KeyCount).Should(Equal(1))
+
+		server.RouteToHandler("GET", "/", ghttp.RespondWithJSONEncoded(http.StatusOK, keySet(otherKey)))
+
+		_, err := n.Notarize(signToken(otherKey, claims))
+		Expect(err).To(MatchError(authorizer.ErrInvalidSignature))
+
+		Expect(n.Refresh(context.Background())).To(Succeed())
+
+		_, err = n.Notarize(signToken(otherKey, claims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("keeps verifying while a periodic refresh is slow", func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		var fetches atomic.Int32
+		respond := ghttp.RespondWithJSONEncoded(http.StatusOK, keySet(privateKey))
+		server.RouteToHandler("GET", "/slow", func(w http.ResponseWriter, r *http.Request) {
+			if fetches.Add(1) > 1 {
+				<-release
+			}
+			respond(w, r)
+		})
+
+		n := authorizer.NewNotary(
+			authorizer.WithTarget(server.URL()+"/slow"),
+			authorizer.WithAudience("audience"),
+			authorizer.WithRefreshInterval(10*time.Millisecond),
+			authorizer.DisableInlineRefresh(),
+		)
+		defer n.Close()
+
+		Eventually(fetches.Load).Should(BeEquivalentTo(2))
+
+		done := make(chan error)
+		go func() {
+			_, err := n.Notarize(signToken(privateKey, claims))
+			done <- err
+		}()
+
+		Eventually(done, time.Second).Should(Receive(BeNil()))
+	})
+
+	It("refreshes inline by default", func() {
+		n := authorizer.NewNotary(
+			authorizer.WithTarget(server.URL()),
+			authorizer.WithAudience("audience"),
+		)
+		defer n.Close()
+
+		_, err := n.Notarize(signToken(privateKey, claims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("is a configuration error without a refresh interval", func() {
+		n := authorizer.NewNotary(
+			authorizer.WithTarget(server.URL()),
+			authorizer.WithAudience("audience"),
+			authorizer.DisableInlineRefresh(),
+		)
+		defer n.Close()
+
+		_, err := n.Notarize(signToken(privateKey, claims))
+		Expect(err).To(MatchError(authorizer.ErrInvalidConfiguration))
+
+		Expect(n.ValidateOnStartup(context.Background())).To(MatchError(authorizer.ErrInvalidConfiguration))
+	})
+})
+
+var _ = Describe("ParseInsecure", func() {
+
+	var privateKey *rsa.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("decodes an expired token signed with an unknown key", func() {
+		token := signToken(privateKey, jwt.Claims{
+			Subject:  "subject",
+			Expiry:   jwt.NewNumericDate(time.Unix(1700000000, 0)),
+			Audience: jwt.Audience{"audience"},
+		})
+
+		header, claims, err := authorizer.ParseInsecure(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header).To(Equal(map[string]any{"alg": "RS256", "kid": "some-key", "typ": "JWT"}))
+		Expect(claims).To(Equal(map[string]any{
+			"sub": "subject",
+			"exp": float64(1700000000),
+			"aud": "audience",
+		}))
+	})
+
+	It("rejects malformed tokens", func() {
+		_, _, err := authorizer.ParseInsecure("not.a-token")
+		Expect(err).To(MatchError(authorizer.ErrInvalidToken))
+
+		_, _, err = authorizer.ParseInsecure("bm90.anNvbg.c2ln")
+		Expect(err).To(MatchError(authorizer.ErrInvalidToken))
+	})
+})
+
+func signToken(privateKey *rsa.PrivateKey, claims interface{}) string {
+	signingKey := jose.SigningKey{Algorithm: jose.RS256, Key: privateKey}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "some-key"))
+	Expect(err).NotTo(HaveOccurred())
+
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	Expect(err).NotTo(HaveOccurred())
+
+	return token
+}
+
+func signTokenWithEmbeddedKey(privateKey *rsa.PrivateKey, claims interface{}) string {
+	signingKey := jose.SigningKey{Algorithm: jose.RS256, Key: privateKey}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{EmbedJWK: true}).WithType("JWT"))
+	Expect(err).NotTo(HaveOccurred())
+
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	Expect(err).NotTo(HaveOccurred())
+
+	return token
+}
@@ -0,0 +1,64 @@
+package authorizer
+
+import (
+	"math/rand/v2"
+	"sort"
+)
+
+// schemaSamples bounds the samples waiting for a busy schema observer.
+const schemaSamples = 64
+
+// WithClaimsSchemaObserver reports the sorted top-level claim names of a
+// sampleRate fraction of authorized requests to fn, so that changes to the
+// shape of the identity provider's tokens can be alerted on. Claim values are
+// never reported. fn runs in the background and samples are dropped while it
+// falls behind. Call Close on the handler to stop it.
+func WithClaimsSchemaObserver(sampleRate float64, fn func(keys []string)) handlerOpt {
+	return func(h *handler) {
+		h.SchemaObserver = &schemaObserver{
+			SampleRate: sampleRate,
+			Observe:    fn,
+		}
+	}
+}
+
+type schemaObserver struct {
+	SampleRate float64
+	Observe    func(keys []string)
+
+	samples chan []string
+}
+
+func (o *schemaObserver) start(workers *workers) {
+
+	o.samples = make(chan []string, schemaSamples)
+
+	workers.Go(func(done <-chan struct{}) {
+		for {
+			select {
+			case keys := <-o.samples:
+				o.Observe(keys)
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+func (o *schemaObserver) sample(claims map[string]interface{}) {
+
+	if len(claims) == 0 || rand.Float64() >= o.SampleRate {
+		return
+	}
+
+	keys := make([]string, 0, len(claims))
+	for key := range claims {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	select {
+	case o.samples <- keys:
+	default:
+	}
+}
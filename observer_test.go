@@ -0,0 +1,159 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithClaimsSchemaObserver", func() {
+
+	var (
+		mu       sync.Mutex
+		observed [][]string
+
+		goroutines int
+
+		claims = authorizerFunc(authorizeWithClaims(map[string]interface{}{
+			"sub":    "some-user",
+			"groups": []interface{}{"admins"},
+			"aud":    "api.example.com",
+		}))
+	)
+
+	observe := func(keys []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		observed = append(observed, keys)
+	}
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(observed)
+	}
+
+	serve := func(h http.Handler, n int) {
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	BeforeEach(func() {
+		observed = nil
+		goroutines = runtime.NumGoroutine()
+	})
+
+	AfterEach(func() {
+		expectNoLeakedGoroutines(goroutines)
+	})
+
+	It("reports the sorted claim names without their values", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithClaimsSchemaObserver(1, observe),
+		)
+		defer h.Close()
+
+		serve(h, 1)
+
+		Eventually(count).Should(Equal(1))
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(observed[0]).To(Equal([]string{"aud", "groups", "sub"}))
+	})
+
+	It("samples a fraction of requests", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithClaimsSchemaObserver(0.5, observe),
+		)
+		defer h.Close()
+
+		serve(h, 60)
+
+		Eventually(count).Should(BeNumerically(">=", 10))
+		Consistently(count, 50*time.Millisecond).Should(BeNumerically("<=", 50))
+	})
+
+	It("never samples at a zero rate", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithClaimsSchemaObserver(0, observe),
+		)
+		defer h.Close()
+
+		serve(h, 100)
+
+		Consistently(count, 50*time.Millisecond).Should(BeZero())
+	})
+
+	It("does not report rejected requests", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithAuthorizedClaim("sub", "someone-else"),
+			authorizer.WithClaimsSchemaObserver(1, observe),
+		)
+		defer h.Close()
+
+		serve(h, 10)
+
+		Consistently(count, 50*time.Millisecond).Should(BeZero())
+	})
+
+	It("does not block requests on a slow observer", func() {
+		release := make(chan struct{})
+
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithClaimsSchemaObserver(1, func(keys []string) {
+				<-release
+			}),
+		)
+		defer h.Close()
+		defer close(release)
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+			serve(h, 1000)
+		}()
+
+		Eventually(served).Should(BeClosed())
+	})
+
+	It("does not allocate when a request is not sampled", func() {
+		if raceEnabled {
+			Skip("the race detector allocates on its own")
+		}
+
+		plain := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+		)
+		sampled := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(claims),
+			authorizer.WithClaimsSchemaObserver(0, observe),
+		)
+		defer sampled.Close()
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		rec := httptest.NewRecorder()
+
+		allocs := func(h http.Handler) float64 {
+			return testing.AllocsPerRun(100, func() {
+				h.ServeHTTP(rec, req.Clone(req.Context()))
+			})
+		}
+
+		Expect(allocs(sampled)).To(Equal(allocs(plain)))
+	})
+})
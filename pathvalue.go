@@ -0,0 +1,65 @@
+package authorizer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// WithAuthorizedClaimMatchingPathValue requires the claim claimKey to equal
+// the request's path value pathParam, or to hold it if the claim is an array.
+// Path values are only known once a ServeMux pattern has matched, so the
+// handler must be registered under such a pattern; otherwise use
+// RequireClaimMatchesPathValue behind the mux.
+func WithAuthorizedClaimMatchingPathValue(claimKey, pathParam string) handlerOpt {
+	return func(h *handler) {
+		if claimKey == "" {
+			h.configError("WithAuthorizedClaimMatchingPathValue", ErrEmptyClaimKey)
+			return
+		}
+		h.PathValueClaims = append(h.PathValueClaims, pathValueClaim{claimKey, pathParam})
+	}
+}
+
+// RequireClaimMatchesPathValue is middleware for handlers registered under a
+// ServeMux pattern behind the authorizer's handler. It answers 403 unless the
+// claim claimKey from the context equals the path value pathParam, or holds
+// it if the claim is an array.
+func RequireClaimMatchesPathValue(claimKey, pathParam string) func(http.Handler) http.Handler {
+	claim := pathValueClaim{claimKey, pathParam}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := claim.check(r); err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type pathValueClaim struct {
+	Claim, Param string
+}
+
+func (c pathValueClaim) check(r *http.Request) error {
+
+	value := r.PathValue(c.Param)
+	claim, _ := lookupClaim(ClaimsFromContext(r.Context()), c.Claim)
+
+	if value == "" || !pathValueMatches(claim, value) {
+		return fmt.Errorf("%w: claim %q does not match path value %q", ErrClaimsNotAuthorized, c.Claim, c.Param)
+	}
+
+	return nil
+}
+
+func pathValueMatches(claim interface{}, value string) bool {
+	if claimMatches(claim, value) {
+		return true
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	return err == nil && claimMatches(claim, number)
+}
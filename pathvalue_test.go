@@ -0,0 +1,97 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Path value claims", func() {
+
+	var (
+		mux    *http.ServeMux
+		served bool
+	)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	})
+
+	auth := authorizerFunc(authorizeWithClaims(map[string]interface{}{
+		"org":     "acme",
+		"team_id": float64(7),
+		"groups":  []interface{}{"ops", "dev"},
+	}))
+
+	serve := func(path string) int {
+		served = false
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		mux = http.NewServeMux()
+	})
+
+	Describe("WithAuthorizedClaimMatchingPathValue", func() {
+		BeforeEach(func() {
+			mux.Handle("GET /orgs/{org}/teams/{team}", authorizer.NewHandler(newLogger(), next,
+				authorizer.WithAuthorizer(auth),
+				authorizer.WithAuthorizedClaimMatchingPathValue("org", "org"),
+				authorizer.WithAuthorizedClaimMatchingPathValue("team_id", "team"),
+			))
+			mux.Handle("GET /groups/{group}", authorizer.NewHandler(newLogger(), next,
+				authorizer.WithAuthorizer(auth),
+				authorizer.WithAuthorizedClaimMatchingPathValue("groups", "group"),
+			))
+			mux.Handle("GET /unmatched", authorizer.NewHandler(newLogger(), next,
+				authorizer.WithAuthorizer(auth),
+				authorizer.WithAuthorizedClaimMatchingPathValue("org", "org"),
+			))
+		})
+
+		It("authorizes when the claims match the path values", func() {
+			Expect(serve("/orgs/acme/teams/7")).To(Equal(http.StatusOK))
+			Expect(served).To(BeTrue())
+		})
+
+		It("rejects when a claim differs from its path value", func() {
+			Expect(serve("/orgs/globex/teams/7")).To(Equal(http.StatusForbidden))
+			Expect(serve("/orgs/acme/teams/8")).To(Equal(http.StatusForbidden))
+			Expect(served).To(BeFalse())
+		})
+
+		It("matches path values against array claims", func() {
+			Expect(serve("/groups/ops")).To(Equal(http.StatusOK))
+			Expect(serve("/groups/finance")).To(Equal(http.StatusForbidden))
+		})
+
+		It("rejects when the pattern has no such path value", func() {
+			Expect(serve("/unmatched")).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Describe("RequireClaimMatchesPathValue", func() {
+		BeforeEach(func() {
+			inner := http.NewServeMux()
+			inner.Handle("GET /orgs/{org}/", authorizer.RequireClaimMatchesPathValue("org", "org")(next))
+
+			mux.Handle("/", authorizer.NewHandler(newLogger(), inner, authorizer.WithAuthorizer(auth)))
+		})
+
+		It("forwards when the claim matches the path value", func() {
+			Expect(serve("/orgs/acme/projects")).To(Equal(http.StatusOK))
+			Expect(served).To(BeTrue())
+		})
+
+		It("forbids when the claim differs", func() {
+			Expect(serve("/orgs/globex/projects")).To(Equal(http.StatusForbidden))
+			Expect(served).To(BeFalse())
+		})
+	})
+})
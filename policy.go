@@ -0,0 +1,514 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var (
+	ErrRedactedSecret        = errors.New("redacted secret")
+	ErrMissingSecret         = errors.New("missing secret")
+	ErrIncompletePolicy      = errors.New("incomplete policy")
+	ErrInvalidPolicyDuration = errors.New("invalid policy duration")
+)
+
+func NewBasicAuthCredential(user, pass string) (BasicAuthCredential, error) {
+	if user == "" || pass == "" {
+		return BasicAuthCredential{}, ErrEmptyCredential
+	}
+	return BasicAuthCredential{Username: user, Password: pass}, nil
+}
+
+func NewAuthorizedToken(value string) (AuthorizedToken, error) {
+	if value == "" {
+		return AuthorizedToken{}, ErrEmptyCredential
+	}
+	return AuthorizedToken{value}, nil
+}
+
+func NewApiKey(label, value string, pathPrefixes ...string) (ApiKey, error) {
+	if value == "" {
+		return ApiKey{}, ErrEmptyCredential
+	}
+	return ApiKey{Value: value, Label: label, PathPrefixes: pathPrefixes}, nil
+}
+
+func NewAuthorizedClaim(key string, value interface{}) (AuthorizedClaim, error) {
+	if key == "" {
+		return AuthorizedClaim{}, ErrEmptyClaimKey
+	}
+	return AuthorizedClaim{Key: key, Value: value}, nil
+}
+
+type basicAuthCredentialJSON struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// MarshalJSON redacts the password.
+func (c BasicAuthCredential) MarshalJSON() ([]byte, error) {
+	return json.Marshal(basicAuthCredentialJSON{c.Username, redacted})
+}
+
+func (c *BasicAuthCredential) UnmarshalJSON(data []byte) error {
+	var v basicAuthCredentialJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkSecret(v.Password); err != nil {
+		return err
+	}
+
+	credential, err := NewBasicAuthCredential(v.Username, v.Password)
+	if err != nil {
+		return err
+	}
+
+	*c = credential
+	return nil
+}
+
+type authorizedTokenJSON struct {
+	Value string `json:"value"`
+}
+
+// MarshalJSON redacts the token.
+func (t AuthorizedToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(authorizedTokenJSON{redacted})
+}
+
+func (t *AuthorizedToken) UnmarshalJSON(data []byte) error {
+	var v authorizedTokenJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkSecret(v.Value); err != nil {
+		return err
+	}
+
+	token, err := NewAuthorizedToken(v.Value)
+	if err != nil {
+		return err
+	}
+
+	*t = token
+	return nil
+}
+
+type apiKeyJSON struct {
+	Value        string         `json:"value"`
+	Label        string         `json:"label,omitempty"`
+	PathPrefixes []string       `json:"path_prefixes,omitempty"`
+	Claims       map[string]any `json:"claims,omitempty"`
+}
+
+// MarshalJSON redacts the key. The digest is left out, as it is enough to
+// recognise a guessed key.
+func (k ApiKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(apiKeyJSON{redacted, k.Label, k.PathPrefixes, k.Claims})
+}
+
+func (k *ApiKey) UnmarshalJSON(data []byte) error {
+	var v apiKeyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkSecret(v.Value); err != nil {
+		return err
+	}
+
+	key, err := NewApiKey(v.Label, v.Value, v.PathPrefixes...)
+	if err != nil {
+		return err
+	}
+
+	key.Claims = v.Claims
+	*k = key
+	return nil
+}
+
+// checkSecret refuses secrets that were redacted when marshaled, so that a
+// redacted policy cannot be loaded back by mistake.
+func checkSecret(value string) error {
+	if value == redacted {
+		return ErrRedactedSecret
+	}
+	return nil
+}
+
+// PolicyDocument describes a handler policy as JSON that is safe to store
+// and review: secrets are given as the names of the environment variables
+// holding them, and only resolved by Options. It covers credentials,
+// sessions, claims, scopes and expressions; options given as code, such as
+// validators, hooks and vetoes, are not part of it.
+type PolicyDocument struct {
+	BasicAuth        []PolicyCredential `json:"basic_auth,omitempty"`
+	TokenEnvs        []string           `json:"token_envs,omitempty"`
+	ApiKeys          []PolicyApiKey     `json:"api_keys,omitempty"`
+	Session          *PolicySession     `json:"session,omitempty"`
+	AuthorizedClaims []PolicyClaim      `json:"authorized_claims,omitempty"`
+	RequiredClaims   []PolicyClaim      `json:"required_claims,omitempty"`
+	AuthorizedScopes []string           `json:"authorized_scopes,omitempty"`
+	ClaimExpressions []string           `json:"claim_expressions,omitempty"`
+	MaxSessionAge    string             `json:"max_session_age,omitempty"`
+
+	// Mechanisms, if set, must list exactly the mechanisms the document
+	// configures, and may add MechanismAnonymous to allow anonymous requests.
+	Mechanisms []string `json:"mechanisms,omitempty"`
+}
+
+type PolicyCredential struct {
+	Username    string `json:"username"`
+	PasswordEnv string `json:"password_env"`
+}
+
+type PolicyApiKey struct {
+	Label        string         `json:"label,omitempty"`
+	ValueEnv     string         `json:"value_env"`
+	PathPrefixes []string       `json:"path_prefixes,omitempty"`
+	Claims       map[string]any `json:"claims,omitempty"`
+}
+
+// PolicySession configures a NewSessionAuthorizer. MaxAge is a duration such
+// as "12h0m0s".
+type PolicySession struct {
+	Cookie    string `json:"cookie,omitempty"`
+	SecretEnv string `json:"secret_env"`
+	MaxAge    string `json:"max_age,omitempty"`
+}
+
+// PolicyClaim matches like AuthorizedClaim; Pattern, if set, is a regular
+// expression used instead of Value. Required claims may instead list the
+// Values any of which is accepted, like WithAuthorizedClaimValues.
+type PolicyClaim struct {
+	Name    string        `json:"name,omitempty"`
+	Key     string        `json:"key"`
+	Value   interface{}   `json:"value,omitempty"`
+	Values  []interface{} `json:"values,omitempty"`
+	Pattern string        `json:"pattern,omitempty"`
+}
+
+// Options resolves the secrets of the document from the environment and
+// returns the equivalent handler options. Every missing or invalid entry is
+// reported.
+func (d PolicyDocument) Options() ([]handlerOpt, error) {
+
+	var (
+		opts []handlerOpt
+		errs []error
+	)
+
+	for _, c := range d.BasicAuth {
+		var credential BasicAuthCredential
+		password, err := secretFromEnv(c.PasswordEnv)
+		if err == nil {
+			credential, err = NewBasicAuthCredential(c.Username, password)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("basic auth %q: %w", c.Username, err))
+			continue
+		}
+		env := c.PasswordEnv
+		opts = append(opts, func(h *handler) {
+			h.BasicAuthCredentials = append(h.BasicAuthCredentials, credential)
+			h.rememberSecretEnv(credential.Password, env)
+		})
+	}
+
+	for _, env := range d.TokenEnvs {
+		var token AuthorizedToken
+		value, err := secretFromEnv(env)
+		if err == nil {
+			token, err = NewAuthorizedToken(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token %q: %w", env, err))
+			continue
+		}
+		opts = append(opts, func(h *handler) {
+			h.AuthorizedTokens = append(h.AuthorizedTokens, token)
+			h.rememberSecretEnv(token.Value, env)
+		})
+	}
+
+	for _, k := range d.ApiKeys {
+		var key ApiKey
+		value, err := secretFromEnv(k.ValueEnv)
+		if err == nil {
+			key, err = NewApiKey(k.Label, value, k.PathPrefixes...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("api key %q: %w", k.ValueEnv, err))
+			continue
+		}
+		key.Claims = k.Claims
+		env := k.ValueEnv
+		opts = append(opts, func(h *handler) {
+			h.ApiKeys = append(h.ApiKeys, key)
+			h.rememberSecretEnv(key.Value, env)
+		})
+	}
+
+	if d.Session != nil {
+		if opt, err := d.Session.option(); err != nil {
+			errs = append(errs, fmt.Errorf("session: %w", err))
+		} else {
+			opts = append(opts, opt)
+		}
+	}
+
+	if claims := policyClaims(d.AuthorizedClaims, &errs); len(claims) > 0 {
+		opts = append(opts, WithAuthorizedClaims(claims...))
+	}
+
+	for _, p := range d.RequiredClaims {
+		if len(p.Values) > 0 {
+			opts = append(opts, WithAuthorizedClaimValues(p.Key, p.Values...))
+		} else if claims := policyClaims([]PolicyClaim{p}, &errs); len(claims) > 0 {
+			opts = append(opts, WithRequiredClaims(claims...))
+		}
+	}
+
+	if len(d.AuthorizedScopes) > 0 {
+		opts = append(opts, WithAuthorizedScopes(d.AuthorizedScopes...))
+	}
+
+	for _, expr := range d.ClaimExpressions {
+		if _, err := ParseClaimExpression(expr); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		opts = append(opts, WithClaimExpression(expr))
+	}
+
+	if d.MaxSessionAge != "" {
+		age, err := policyDuration(d.MaxSessionAge)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("max session age: %w", err))
+		} else {
+			opts = append(opts, WithMaxSessionAge(age))
+		}
+	}
+
+	if len(d.Mechanisms) > 0 {
+		configured := d.mechanisms()
+		for _, mechanism := range d.Mechanisms {
+			if mechanism != MechanismAnonymous && !slices.Contains(configured, mechanism) {
+				errs = append(errs, fmt.Errorf("%w: mechanism %q is not configured", ErrIncompletePolicy, mechanism))
+			}
+		}
+		for _, mechanism := range configured {
+			if !slices.Contains(d.Mechanisms, mechanism) {
+				errs = append(errs, fmt.Errorf("%w: mechanism %q is configured but not listed", ErrIncompletePolicy, mechanism))
+			}
+		}
+		if slices.Contains(d.Mechanisms, MechanismAnonymous) {
+			opts = append(opts, AllowAnonymous())
+		}
+	}
+
+	return opts, errors.Join(errs...)
+}
+
+// mechanisms lists the mechanisms the document configures, in the order of
+// handler.Mechanisms.
+func (d PolicyDocument) mechanisms() []string {
+
+	mechanisms := []string{}
+
+	if len(d.ApiKeys) > 0 {
+		mechanisms = append(mechanisms, MechanismApiKey)
+	}
+	if len(d.BasicAuth) > 0 {
+		mechanisms = append(mechanisms, MechanismBasicAuth)
+	}
+	if len(d.TokenEnvs) > 0 {
+		mechanisms = append(mechanisms, MechanismStaticToken)
+	}
+	if d.Session != nil {
+		mechanisms = append(mechanisms, MechanismBearerToken)
+	}
+
+	return mechanisms
+}
+
+func (s PolicySession) option() (handlerOpt, error) {
+
+	secret, err := secretFromEnv(s.SecretEnv)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, ErrEmptyCredential
+	}
+
+	opts := []sessionOpt{WithSessionSecret([]byte(secret))}
+	if s.Cookie != "" {
+		opts = append(opts, WithSessionCookie(s.Cookie))
+	}
+	if s.MaxAge != "" {
+		maxAge, err := policyDuration(s.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSessionMaxAge(maxAge))
+	}
+
+	env := s.SecretEnv
+	return func(h *handler) {
+		h.Authorizer = NewSessionAuthorizer(opts...)
+		h.rememberSecretEnv(secret, env)
+	}, nil
+}
+
+// Policy exports the handler's configuration as a PolicyDocument, which
+// Options turns back into an equivalent handler. Only secrets loaded by
+// Options can be exported, as the document names their environment
+// variables; any other secret, and any mechanism the document cannot
+// describe, such as a validator or a custom authorizer, is reported as
+// ErrIncompletePolicy.
+func (h *handler) Policy() (PolicyDocument, error) {
+
+	var (
+		doc  PolicyDocument
+		errs []error
+	)
+
+	for _, c := range h.BasicAuthCredentials {
+		env, ok := h.PolicySecretEnvs[c.Password]
+		if !ok || c.PasswordHash != "" {
+			errs = append(errs, fmt.Errorf("%w: basic auth %q was not loaded from the environment", ErrIncompletePolicy, c.Username))
+			continue
+		}
+		doc.BasicAuth = append(doc.BasicAuth, PolicyCredential{c.Username, env})
+	}
+
+	for i, t := range h.AuthorizedTokens {
+		env, ok := h.PolicySecretEnvs[t.Value]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: token %d was not loaded from the environment", ErrIncompletePolicy, i))
+			continue
+		}
+		doc.TokenEnvs = append(doc.TokenEnvs, env)
+	}
+
+	for _, k := range h.ApiKeys {
+		env, ok := h.PolicySecretEnvs[k.Value]
+		if !ok || k.Value == "" {
+			errs = append(errs, fmt.Errorf("%w: api key %q was not loaded from the environment", ErrIncompletePolicy, k.Label))
+			continue
+		}
+		doc.ApiKeys = append(doc.ApiKeys, PolicyApiKey{k.Label, env, k.PathPrefixes, k.Claims})
+	}
+
+	if session, ok := h.Authorizer.(*sessionAuthorizer); ok {
+		env, ok := h.PolicySecretEnvs[string(session.Secret)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: the session secret was not loaded from the environment", ErrIncompletePolicy))
+		}
+		doc.Session = &PolicySession{Cookie: session.Cookie, SecretEnv: env}
+		if session.MaxAge > 0 {
+			doc.Session.MaxAge = session.MaxAge.String()
+		}
+	}
+
+	for _, c := range h.AuthorizedClaims {
+		doc.AuthorizedClaims = append(doc.AuthorizedClaims, PolicyClaim{
+			Name:    c.Name,
+			Key:     c.Key,
+			Value:   c.Value,
+			Pattern: patternSource(c.Pattern),
+		})
+	}
+
+	for _, c := range h.RequiredClaims {
+		doc.RequiredClaims = append(doc.RequiredClaims, PolicyClaim{
+			Key:     c.Key,
+			Value:   c.Value,
+			Values:  c.Values,
+			Pattern: patternSource(c.Pattern),
+		})
+	}
+
+	doc.AuthorizedScopes = slices.Clone(h.RequiredScopes)
+
+	for _, expr := range h.ClaimExpressions {
+		doc.ClaimExpressions = append(doc.ClaimExpressions, expr.Source)
+	}
+
+	if h.MaxSessionAge > 0 {
+		doc.MaxSessionAge = h.MaxSessionAge.String()
+	}
+
+	doc.Mechanisms = h.Mechanisms()
+
+	configured := doc.mechanisms()
+	for _, mechanism := range doc.Mechanisms {
+		if mechanism != MechanismAnonymous && !slices.Contains(configured, mechanism) {
+			errs = append(errs, fmt.Errorf("%w: mechanism %q cannot be described", ErrIncompletePolicy, mechanism))
+		}
+	}
+
+	return doc, errors.Join(errs...)
+}
+
+func (h *handler) rememberSecretEnv(secret, env string) {
+	if h.PolicySecretEnvs == nil {
+		h.PolicySecretEnvs = map[string]string{}
+	}
+	h.PolicySecretEnvs[secret] = env
+}
+
+func patternSource(pattern *regexp.Regexp) string {
+	if pattern == nil {
+		return ""
+	}
+	return pattern.String()
+}
+
+func policyDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidPolicyDuration, err)
+	}
+	return duration, nil
+}
+
+func secretFromEnv(env string) (string, error) {
+	value, ok := os.LookupEnv(env)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not set", ErrMissingSecret, env)
+	}
+	return value, nil
+}
+
+func policyClaims(policies []PolicyClaim, errs *[]error) []AuthorizedClaim {
+
+	var claims []AuthorizedClaim
+	for _, p := range policies {
+		claim, err := NewAuthorizedClaim(p.Key, p.Value)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+
+		if p.Pattern != "" {
+			pattern, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("%w: %v", ErrInvalidClaimPattern, err))
+				continue
+			}
+			claim.Value, claim.Pattern = nil, pattern
+		}
+
+		claim.Name = p.Name
+		claims = append(claims, claim)
+	}
+
+	return claims
+}
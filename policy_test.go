@@ -0,0 +1,270 @@
+package authorizer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("Credential constructors", func() {
+
+	It("reject empty values", func() {
+		_, err := authorizer.NewBasicAuthCredential("user", "")
+		Expect(err).To(MatchError(authorizer.ErrEmptyCredential))
+
+		_, err = authorizer.NewAuthorizedToken("")
+		Expect(err).To(MatchError(authorizer.ErrEmptyCredential))
+
+		_, err = authorizer.NewApiKey("label", "")
+		Expect(err).To(MatchError(authorizer.ErrEmptyCredential))
+
+		_, err = authorizer.NewAuthorizedClaim("", "value")
+		Expect(err).To(MatchError(authorizer.ErrEmptyClaimKey))
+	})
+
+	It("redact secrets when marshaled", func() {
+		credential, err := authorizer.NewBasicAuthCredential("user", "pass")
+		Expect(err).NotTo(HaveOccurred())
+		token, err := authorizer.NewAuthorizedToken("some-token")
+		Expect(err).NotTo(HaveOccurred())
+		key, err := authorizer.NewApiKey("ci", "some-key", "/builds")
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := json.Marshal([]interface{}{credential, token, key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data).To(MatchJSON(`[
+			{"username": "user", "password": "[REDACTED]"},
+			{"value": "[REDACTED]"},
+			{"value": "[REDACTED]", "label": "ci", "path_prefixes": ["/builds"]}
+		]`))
+	})
+
+	It("validate when unmarshaled", func() {
+		var credential authorizer.BasicAuthCredential
+		Expect(json.Unmarshal([]byte(`{"username":"user","password":"pass"}`), &credential)).To(Succeed())
+		Expect(credential).To(Equal(authorizer.BasicAuthCredential{Username: "user", Password: "pass"}))
+
+		Expect(json.Unmarshal([]byte(`{"username":"user"}`), &credential)).To(MatchError(authorizer.ErrEmptyCredential))
+		Expect(json.Unmarshal([]byte(`{"username":"user","password":"[REDACTED]"}`), &credential)).To(MatchError(authorizer.ErrRedactedSecret))
+
+		var token authorizer.AuthorizedToken
+		Expect(json.Unmarshal([]byte(`{"value":""}`), &token)).To(MatchError(authorizer.ErrEmptyCredential))
+
+		var key authorizer.ApiKey
+		Expect(json.Unmarshal([]byte(`{"value":"[REDACTED]"}`), &key)).To(MatchError(authorizer.ErrRedactedSecret))
+	})
+})
+
+var _ = Describe("PolicyDocument", func() {
+
+	const document = `{
+		"basic_auth": [{"username": "admin", "password_env": "TEST_POLICY_PASSWORD"}],
+		"token_envs": ["TEST_POLICY_TOKEN"],
+		"api_keys": [{"label": "ci", "value_env": "TEST_POLICY_KEY", "path_prefixes": ["/builds"]}],
+		"authorized_claims": [{"name": "services", "key": "sub", "pattern": "^svc-"}],
+		"required_claims": [{"key": "tenant", "value": "acme"}]
+	}`
+
+	var (
+		doc  authorizer.PolicyDocument
+		code func(*http.Request) int
+	)
+
+	BeforeEach(func() {
+		os.Setenv("TEST_POLICY_PASSWORD", "some-password")
+		os.Setenv("TEST_POLICY_TOKEN", "some-token")
+		os.Setenv("TEST_POLICY_KEY", "some-key")
+
+		doc = authorizer.PolicyDocument{}
+		Expect(json.Unmarshal([]byte(document), &doc)).To(Succeed())
+
+		code = func(req *http.Request) int {
+			opts, err := doc.Options()
+			Expect(err).NotTo(HaveOccurred())
+
+			h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), opts...)
+			Expect(h.Validate()).To(Succeed())
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec.Code
+		}
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("TEST_POLICY_PASSWORD")
+		os.Unsetenv("TEST_POLICY_TOKEN")
+		os.Unsetenv("TEST_POLICY_KEY")
+	})
+
+	It("round trips through JSON", func() {
+		data, err := json.Marshal(doc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(document))
+		Expect(string(data)).NotTo(ContainSubstring("some-password"))
+	})
+
+	It("resolves secrets from the environment", func() {
+		doc.ApiKeys, doc.AuthorizedClaims, doc.RequiredClaims = nil, nil, nil
+
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.SetBasicAuth("admin", "some-password")
+		Expect(code(req)).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+		req.SetBasicAuth("admin", "other-password")
+		Expect(code(req)).To(Equal(http.StatusUnauthorized))
+
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("Authorization", "Bearer some-token")
+		Expect(code(req)).To(Equal(http.StatusOK))
+	})
+
+	It("resolves api keys from the environment", func() {
+		doc.BasicAuth, doc.TokenEnvs, doc.AuthorizedClaims, doc.RequiredClaims = nil, nil, nil, nil
+
+		req := httptest.NewRequest("GET", "http://localhost/builds/1", nil)
+		req.Header.Set("X-Api-Key", "some-key")
+		Expect(code(req)).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest("GET", "http://localhost/deploys/1", nil)
+		req.Header.Set("X-Api-Key", "some-key")
+		Expect(code(req)).To(Equal(http.StatusForbidden))
+	})
+
+	It("applies the claims", func() {
+		doc.BasicAuth, doc.TokenEnvs, doc.ApiKeys = nil, nil, nil
+
+		authorize := func(claims map[string]interface{}) int {
+			opts, err := doc.Options()
+			Expect(err).NotTo(HaveOccurred())
+
+			h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+				append(opts, authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))))...,
+			)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+			return rec.Code
+		}
+
+		Expect(authorize(map[string]interface{}{"sub": "svc-a", "tenant": "acme"})).To(Equal(http.StatusOK))
+		Expect(authorize(map[string]interface{}{"sub": "svc-a", "tenant": "other"})).To(Equal(http.StatusForbidden))
+		Expect(authorize(map[string]interface{}{"sub": "usr-a", "tenant": "acme"})).To(Equal(http.StatusForbidden))
+	})
+
+	It("reports every missing secret", func() {
+		os.Unsetenv("TEST_POLICY_PASSWORD")
+		os.Unsetenv("TEST_POLICY_KEY")
+
+		_, err := doc.Options()
+		Expect(err).To(MatchError(authorizer.ErrMissingSecret))
+		Expect(err).To(MatchError(ContainSubstring("TEST_POLICY_PASSWORD")))
+		Expect(err).To(MatchError(ContainSubstring("TEST_POLICY_KEY")))
+	})
+
+	It("reports an invalid pattern", func() {
+		doc.AuthorizedClaims[0].Pattern = "^svc-("
+
+		_, err := doc.Options()
+		Expect(err).To(MatchError(authorizer.ErrInvalidClaimPattern))
+	})
+
+	Describe("Policy", func() {
+
+		const full = `{
+			"basic_auth": [{"username": "admin", "password_env": "TEST_POLICY_PASSWORD"}],
+			"token_envs": ["TEST_POLICY_TOKEN"],
+			"api_keys": [{"label": "ci", "value_env": "TEST_POLICY_KEY", "path_prefixes": ["/builds"], "claims": {"sub": "ci"}}],
+			"session": {"cookie": "sid", "secret_env": "TEST_POLICY_SESSION", "max_age": "12h0m0s"},
+			"authorized_claims": [{"name": "services", "key": "sub", "pattern": "^svc-"}],
+			"required_claims": [{"key": "tenant", "value": "acme"}, {"key": "plan", "values": ["pro", "enterprise"]}],
+			"authorized_scopes": ["read"],
+			"claim_expressions": ["claims.org == \"acme\""],
+			"max_session_age": "1h0m0s",
+			"mechanisms": ["api_key", "basic_auth", "static_token", "bearer_token", "anonymous"]
+		}`
+
+		BeforeEach(func() {
+			os.Setenv("TEST_POLICY_SESSION", "some-session-secret")
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("TEST_POLICY_SESSION")
+		})
+
+		It("exports a document that round trips through the handler", func() {
+			var doc authorizer.PolicyDocument
+			Expect(json.Unmarshal([]byte(full), &doc)).To(Succeed())
+
+			opts, err := doc.Options()
+			Expect(err).NotTo(HaveOccurred())
+
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(), opts...)
+			Expect(h.Validate()).To(Succeed())
+
+			exported, err := h.Policy()
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := json.Marshal(exported)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(full))
+			Expect(string(data)).NotTo(ContainSubstring("some-session-secret"))
+		})
+
+		It("reports secrets that were not loaded from the environment", func() {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithBasicAuthCredential("admin", "some-password"),
+			)
+
+			_, err := h.Policy()
+			Expect(err).To(MatchError(authorizer.ErrIncompletePolicy))
+			Expect(err).NotTo(MatchError(ContainSubstring("some-password")))
+		})
+
+		It("reports mechanisms the document cannot describe", func() {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(nil))),
+			)
+
+			_, err := h.Policy()
+			Expect(err).To(MatchError(authorizer.ErrIncompletePolicy))
+			Expect(err).To(MatchError(ContainSubstring("bearer_token")))
+		})
+	})
+
+	It("reports mechanisms it does not configure", func() {
+		doc.Mechanisms = []string{"api_key", "basic_auth", "bearer_token"}
+
+		_, err := doc.Options()
+		Expect(err).To(MatchError(authorizer.ErrIncompletePolicy))
+		Expect(err).To(MatchError(ContainSubstring("bearer_token")))
+		Expect(err).To(MatchError(ContainSubstring("static_token")))
+	})
+
+	It("allows anonymous requests when listed", func() {
+		os.Setenv("TEST_POLICY_SESSION", "some-session-secret")
+		defer os.Unsetenv("TEST_POLICY_SESSION")
+
+		doc = authorizer.PolicyDocument{
+			Session:    &authorizer.PolicySession{SecretEnv: "TEST_POLICY_SESSION"},
+			Mechanisms: []string{"bearer_token", "anonymous"},
+		}
+
+		Expect(code(httptest.NewRequest("GET", "http://localhost", nil))).To(Equal(http.StatusOK))
+	})
+
+	It("reports an invalid duration", func() {
+		doc.MaxSessionAge = "soon"
+
+		_, err := doc.Options()
+		Expect(err).To(MatchError(authorizer.ErrInvalidPolicyDuration))
+	})
+})
@@ -0,0 +1,60 @@
+package authorizer
+
+import (
+	"strings"
+)
+
+// NewAuth0Notary verifies access tokens issued by the Auth0 tenant at domain,
+// such as example.eu.auth0.com, for the API identified by audience. Like the
+// other presets, it finds the provider's keys through OpenID Connect
+// discovery. opts are applied last and override the preset.
+func NewAuth0Notary(domain, audience string, opts ...notaryOpt) *notary {
+	domain = strings.TrimSuffix(strings.TrimPrefix(domain, "https://"), "/")
+
+	return NewNotary(append([]notaryOpt{
+		WithDiscovery("https://" + domain + "/"),
+		WithIssuer("https://" + domain + "/"),
+		WithAudience(audience),
+	}, opts...)...)
+}
+
+// NewOktaNotary verifies access tokens issued by the Okta authorization server
+// at issuer, such as https://example.okta.com/oauth2/default, for audience,
+// which is api://default unless the server was configured otherwise. opts are
+// applied last and override the preset.
+func NewOktaNotary(issuer, audience string, opts ...notaryOpt) *notary {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	return NewNotary(append([]notaryOpt{
+		WithDiscovery(issuer),
+		WithIssuer(issuer),
+		WithAudience(audience),
+	}, opts...)...)
+}
+
+// NewGoogleNotary verifies ID tokens issued by Google for the OAuth client
+// clientID. Google issues tokens under two issuer spellings, both of which
+// are accepted. opts are applied last and override the preset.
+func NewGoogleNotary(clientID string, opts ...notaryOpt) *notary {
+	return NewNotary(append([]notaryOpt{
+		WithDiscovery("https://accounts.google.com"),
+		WithIssuer("https://accounts.google.com", "accounts.google.com"),
+		WithAudience(clientID),
+	}, opts...)...)
+}
+
+// NewAzureADNotary verifies access tokens issued by the Azure AD tenant
+// tenantID for the application appID. Both v1 and v2 tokens are accepted:
+// they differ in issuer, and v1 tokens carry the application ID URI rather
+// than the bare appID as audience. opts are applied last and override the
+// preset.
+func NewAzureADNotary(tenantID, appID string, opts ...notaryOpt) *notary {
+	return NewNotary(append([]notaryOpt{
+		WithDiscovery("https://login.microsoftonline.com/" + tenantID + "/v2.0"),
+		WithIssuer(
+			"https://login.microsoftonline.com/"+tenantID+"/v2.0",
+			"https://sts.windows.net/"+tenantID+"/",
+		),
+		WithAudience(appID, "api://"+appID),
+	}, opts...)...)
+}
@@ -0,0 +1,190 @@
+package authorizer_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/reverted/authorizer"
+)
+
+type preset struct {
+	discovery string
+	jwksURI   string
+	notary    func(client *http.Client) Notary
+}
+
+var _ = Describe("Provider presets", func() {
+
+	var (
+		server     *ghttp.Server
+		client     *http.Client
+		privateKey *rsa.PrivateKey
+	)
+
+	BeforeEach(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+
+		target, err := url.Parse(server.URL())
+		Expect(err).NotTo(HaveOccurred())
+		client = &http.Client{Transport: rewriteTransport{target}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	serveKeys := func(path string) {
+		server.RouteToHandler("GET", path, ghttp.RespondWithJSONEncoded(http.StatusOK, jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{
+				KeyID:     "some-key",
+				Use:       "sig",
+				Algorithm: string(jose.RS256),
+				Key:       &privateKey.PublicKey,
+			}},
+		}))
+	}
+
+	// serveProvider mimics a provider's discovery document, which points at
+	// its key set.
+	serveProvider := func(p *preset) {
+		server.RouteToHandler("GET", p.discovery, ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{
+			"issuer":   "https://provider.example.com",
+			"jwks_uri": p.jwksURI,
+		}))
+
+		jwksURI, err := url.Parse(p.jwksURI)
+		Expect(err).NotTo(HaveOccurred())
+		serveKeys(jwksURI.Path)
+	}
+
+	token := func(issuer, audience string) string {
+		return signToken(privateKey, jwt.Claims{
+			Subject:  "subject",
+			Issuer:   issuer,
+			Audience: jwt.Audience{audience},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		})
+	}
+
+	auth0 := preset{"/.well-known/openid-configuration", "https://example.eu.auth0.com/.well-known/jwks.json", func(client *http.Client) Notary {
+		return authorizer.NewAuth0Notary("https://example.eu.auth0.com/", "https://api.example.com", authorizer.WithHttpClient(client))
+	}}
+	okta := preset{"/oauth2/default/.well-known/openid-configuration", "https://example.okta.com/oauth2/default/v1/keys", func(client *http.Client) Notary {
+		return authorizer.NewOktaNotary("https://example.okta.com/oauth2/default", "api://default", authorizer.WithHttpClient(client))
+	}}
+	google := preset{"/.well-known/openid-configuration", "https://www.googleapis.com/oauth2/v3/certs", func(client *http.Client) Notary {
+		return authorizer.NewGoogleNotary("client-id.apps.googleusercontent.com", authorizer.WithHttpClient(client))
+	}}
+	azure := preset{"/some-tenant/v2.0/.well-known/openid-configuration", "https://login.microsoftonline.com/some-tenant/discovery/v2.0/keys", func(client *http.Client) Notary {
+		return authorizer.NewAzureADNotary("some-tenant", "some-app", authorizer.WithHttpClient(client))
+	}}
+
+	DescribeTable("accepts the provider's tokens",
+		func(p *preset, issuer, audience string) {
+			serveProvider(p)
+
+			claims, err := p.notary(client).Notarize(token(issuer, audience))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claims).To(HaveKeyWithValue("sub", "subject"))
+		},
+		Entry("auth0", &auth0, "https://example.eu.auth0.com/", "https://api.example.com"),
+		Entry("okta", &okta, "https://example.okta.com/oauth2/default", "api://default"),
+		Entry("google", &google, "https://accounts.google.com", "client-id.apps.googleusercontent.com"),
+		Entry("google without scheme", &google, "accounts.google.com", "client-id.apps.googleusercontent.com"),
+		Entry("azure v2", &azure, "https://login.microsoftonline.com/some-tenant/v2.0", "some-app"),
+		Entry("azure v1", &azure, "https://sts.windows.net/some-tenant/", "api://some-app"),
+	)
+
+	DescribeTable("rejects tokens from other issuers",
+		func(p *preset, audience string) {
+			serveProvider(p)
+
+			_, err := p.notary(client).Notarize(token("https://attacker.example.com/", audience))
+			Expect(err).To(MatchError(authorizer.ErrInvalidIssuer))
+		},
+		Entry("auth0", &auth0, "https://api.example.com"),
+		Entry("okta", &okta, "api://default"),
+		Entry("google", &google, "client-id.apps.googleusercontent.com"),
+		Entry("azure", &azure, "some-app"),
+	)
+
+	DescribeTable("rejects tokens for other audiences",
+		func(p *preset, issuer string) {
+			serveProvider(p)
+
+			_, err := p.notary(client).Notarize(token(issuer, "other-audience"))
+			Expect(err).To(MatchError(authorizer.ErrInvalidAudience))
+		},
+		Entry("auth0", &auth0, "https://example.eu.auth0.com/"),
+		Entry("okta", &okta, "https://example.okta.com/oauth2/default"),
+		Entry("google", &google, "https://accounts.google.com"),
+		Entry("azure", &azure, "https://login.microsoftonline.com/some-tenant/v2.0"),
+	)
+
+	It("caches the discovered key set location", func() {
+		serveProvider(&okta)
+
+		n := okta.notary(client).(interface {
+			Refresh(context.Context) error
+		})
+		Expect(n.Refresh(context.Background())).To(Succeed())
+		Expect(n.Refresh(context.Background())).To(Succeed())
+
+		discoveries := 0
+		for _, req := range server.ReceivedRequests() {
+			if req.URL.Path == okta.discovery {
+				discoveries++
+			}
+		}
+		Expect(discoveries).To(Equal(1))
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("reports a discovery document without a key set", func() {
+		server.RouteToHandler("GET", okta.discovery, ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{}))
+
+		_, err := okta.notary(client).Notarize(token("https://example.okta.com/oauth2/default", "api://default"))
+		Expect(err).To(MatchError(authorizer.ErrNoKeySetURI))
+	})
+
+	It("lets options override the preset", func() {
+		serveKeys("/custom/keys")
+
+		n := authorizer.NewAuth0Notary("example.eu.auth0.com", "https://api.example.com",
+			authorizer.WithTarget(server.URL()+"/custom/keys"),
+			authorizer.WithAudience("other-audience"),
+		)
+
+		_, err := n.Notarize(token("https://example.eu.auth0.com/", "other-audience"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+// rewriteTransport sends every request to target, keeping its path, so that
+// tests can stand in for a provider's fixed host.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
@@ -0,0 +1,68 @@
+package authorizer
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithTokenProvider authorizes the static tokens returned by provider,
+// refreshing them every interval. If the provider fails, the previous tokens
+// stay in effect. Empty tokens are dropped with a warning. Call Close on the handler to stop refreshing.
+func WithTokenProvider(provider func() ([]string, error), every time.Duration) handlerOpt {
+	return func(h *handler) {
+		h.TokenProvider = &tokenProvider{
+			Provider: provider,
+			Every:    every,
+		}
+	}
+}
+
+type tokenProvider struct {
+	sync.RWMutex
+	Provider func() ([]string, error)
+	Every    time.Duration
+
+	tokens []AuthorizedToken
+}
+
+func (p *tokenProvider) start(logger Logger, workers *workers) {
+
+	p.refresh(logger)
+
+	workers.Every(p.Every, func() {
+		p.refresh(logger)
+	})
+}
+
+func (p *tokenProvider) refresh(logger Logger) {
+
+	values, err := p.Provider()
+	if err != nil {
+		logger.Error(&redactedError{RedactSecrets(err.Error()), err}, Field{"token_provider", "refresh failed"})
+		return
+	}
+
+	tokens := make([]AuthorizedToken, 0, len(values))
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		tokens = append(tokens, AuthorizedToken{value})
+	}
+
+	if dropped := len(values) - len(tokens); dropped > 0 {
+		logWarning(logger, ErrEmptyCredential, Field{"token_provider", "empty tokens dropped"}, Field{"dropped", dropped})
+	}
+
+	p.Lock()
+	p.tokens = tokens
+	p.Unlock()
+}
+
+func (p *tokenProvider) Tokens() []AuthorizedToken {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.tokens
+}
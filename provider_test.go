@@ -0,0 +1,162 @@
+package authorizer_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithTokenProvider", func() {
+
+	var (
+		mu     sync.Mutex
+		tokens []string
+		fail   bool
+		calls  int
+
+		log        *logger
+		goroutines int
+		handler    interface {
+			http.Handler
+			Close() error
+		}
+	)
+
+	provider := func() ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if fail {
+			return nil, errors.New("vault unavailable")
+		}
+		return append([]string{}, tokens...), nil
+	}
+
+	rotate := func(values ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens = values
+	}
+
+	status := func(token string) int {
+		req, err := http.NewRequest("GET", "http://localhost/some/path", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		tokens = []string{"first"}
+		fail = false
+		calls = 0
+		log = newLogger()
+		goroutines = runtime.NumGoroutine()
+
+		handler = authorizer.NewHandler(
+			log,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			authorizer.WithAuthorizer(rejectingAuthorizer{}),
+			authorizer.WithTokenProvider(provider, 10*time.Millisecond),
+		)
+	})
+
+	AfterEach(func() {
+		Expect(handler.Close()).To(Succeed())
+		expectNoLeakedGoroutines(goroutines)
+	})
+
+	It("authorizes the provided tokens", func() {
+		Expect(status("first")).To(Equal(http.StatusOK))
+		Expect(status("second")).To(Equal(http.StatusUnauthorized))
+	})
+
+	Context("when the tokens rotate", func() {
+		BeforeEach(func() {
+			rotate("second")
+		})
+
+		It("stops accepting the old tokens", func() {
+			Eventually(func() int { return status("second") }).Should(Equal(http.StatusOK))
+			Expect(status("first")).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when the provider fails", func() {
+		var failedAfter int
+
+		BeforeEach(func() {
+			mu.Lock()
+			fail = true
+			failedAfter = calls
+			mu.Unlock()
+		})
+
+		It("keeps the previous tokens", func() {
+			Eventually(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return calls
+			}).Should(BeNumerically(">", failedAfter+1))
+
+			Expect(status("first")).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the provider returns empty tokens", func() {
+		BeforeEach(func() {
+			rotate("second", "", " ")
+		})
+
+		It("drops them with a warning", func() {
+			Eventually(func() int { return status("second") }).Should(Equal(http.StatusOK))
+			Expect(status("")).To(Equal(http.StatusUnauthorized))
+			Eventually(func() []string {
+				log.Lock()
+				defer log.Unlock()
+				return append([]string{}, log.lines...)
+			}).Should(ContainElement(ContainSubstring("dropped=2")))
+		})
+	})
+
+	It("serves traffic safely while rotating", func() {
+		var wg sync.WaitGroup
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for j := 0; j < 200; j++ {
+					Expect(status("first")).To(BeElementOf(http.StatusOK, http.StatusUnauthorized))
+				}
+			}()
+		}
+
+		for i := 0; i < 20; i++ {
+			rotate("first", "second")
+			rotate("first")
+			time.Sleep(time.Millisecond)
+		}
+
+		wg.Wait()
+	})
+})
+
+type rejectingAuthorizer struct{}
+
+func (rejectingAuthorizer) Authorize(r *http.Request) error {
+	return errors.New("rejected")
+}
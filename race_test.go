@@ -0,0 +1,7 @@
+//go:build race
+
+package authorizer_test
+
+// raceEnabled reports whether the tests run under the race detector, which
+// allocates on its own and so skews allocation counts.
+const raceEnabled = true
@@ -0,0 +1,142 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the body written for rejected requests, as JSON when the
+// client accepts it and as plain text otherwise.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+var errorCodes = map[error]string{
+	ErrInvalidConfiguration: "invalid_configuration",
+	ErrApiKeyRequired:       "api_key_required",
+	ErrApiKeyOutOfScope:     "api_key_out_of_scope",
+	ErrNoCredentials:        "no_credentials",
+	ErrCredentialsRejected:  "credentials_rejected",
+	ErrClaimsNotAuthorized:  "claims_not_authorized",
+	ErrRequestVetoed:        "request_vetoed",
+}
+
+func (h *handler) errorResponse(r *http.Request, err *rejection) ErrorResponse {
+
+	response := ErrorResponse{
+		Code:      errorCodes[err.Reason],
+		Message:   err.Reason.Error(),
+		RequestID: r.Header.Get("X-Request-Id"),
+	}
+
+	// Only causes known to be safe to show the client are detailed.
+	switch {
+	case errors.Is(err.Cause, ErrInsufficientAuthentication):
+		response.Detail = "step-up authentication required"
+	case errors.Is(err.Cause, ErrTokenExpired):
+		response.Detail = "token expired"
+	case errors.Is(err.Cause, ErrMissingUpstreamClaims):
+		response.Detail = ErrMissingUpstreamClaims.Error()
+	}
+
+	return response
+}
+
+func (h *handler) writeErrorResponse(w http.ResponseWriter, r *http.Request, err *rejection, status int) {
+
+	if h.JSONErrors {
+		h.writeJSONError(w, r, err, status)
+		return
+	}
+
+	response := h.errorResponse(r, err)
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+
+	text := response.Message
+	if response.Detail != "" {
+		text += ": " + response.Detail
+	}
+	if response.RequestID != "" {
+		text += " (request_id " + response.RequestID + ")"
+	}
+	w.Write([]byte(text + "\n"))
+}
+
+// WithJSONErrors writes the ErrorResponse of every rejection as a JSON body
+// in the form of RFC 6750, such as
+// {"error":"invalid_token","error_description":"token expired"}, whatever the
+// client accepts. It cannot be combined with WithUnauthorizedResponder.
+func WithJSONErrors() handlerOpt {
+	return func(h *handler) {
+		h.JSONErrors = true
+	}
+}
+
+// bearerError is an error response as defined by RFC 6750.
+type bearerError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (h *handler) writeJSONError(w http.ResponseWriter, r *http.Request, err *rejection, status int) {
+
+	// The description is the reason or a detail known to be safe, never the
+	// cause, which may quote the credentials.
+	response := h.errorResponse(r, err)
+	description := response.Message
+	if response.Detail != "" {
+		description = response.Detail
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(bearerError{bearerErrorCode(err, status), description})
+}
+
+func bearerErrorCode(err *rejection, status int) string {
+	if status >= http.StatusInternalServerError {
+		return "server_error"
+	}
+
+	switch ClassifyError(err) {
+	case RejectionNoCredentials, RejectionMalformed:
+		return "invalid_request"
+	case RejectionPolicyDenied:
+		return "insufficient_scope"
+	}
+	return "invalid_token"
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+
+			if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package authorizer_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("ErrorResponse", func() {
+
+	var (
+		req *http.Request
+		rec *httptest.ResponseRecorder
+	)
+
+	stepUp := func() http.Handler {
+		return authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"acr": "pwd",
+			}))),
+			authorizer.WithRequiredACR("mfa"),
+		)
+	}
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost/some/path", nil)
+		req.Header.Set("X-Request-Id", "some-request-id")
+		rec = httptest.NewRecorder()
+	})
+
+	Context("when the client accepts json", func() {
+		BeforeEach(func() {
+			req.Header.Set("Accept", "application/json")
+			stepUp().ServeHTTP(rec, req)
+		})
+
+		It("writes the stable json schema", func() {
+			golden, err := os.ReadFile("testdata/error_response.golden")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rec.Body.String()).To(Equal(string(golden)))
+		})
+	})
+
+	Context("when the client does not accept json", func() {
+		BeforeEach(func() {
+			req.Header.Set("Accept", "text/html, application/json;q=0")
+			stepUp().ServeHTTP(rec, req)
+		})
+
+		It("falls back to plain text", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+			Expect(rec.Body.String()).To(Equal("claims not authorized: step-up authentication required (request_id some-request-id)\n"))
+		})
+	})
+
+	Context("when the rejection has an unsafe cause", func() {
+		BeforeEach(func() {
+			req.Header.Set("Accept", "application/problem+json")
+
+			authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizerFunc(func(*http.Request) error {
+					return errNope
+				})),
+			).ServeHTTP(rec, req)
+		})
+
+		It("leaves it out of the response", func() {
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Body.String()).To(Equal(`{"code":"credentials_rejected","message":"credentials rejected","request_id":"some-request-id"}` + "\n"))
+		})
+	})
+
+	Describe("precedence", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			handler = authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizer.New()),
+				authorizer.WithLoginRedirect("https://login.example.com"),
+				authorizer.WithUnauthorizedResponder(func(w http.ResponseWriter, r *http.Request, err error) {
+					w.WriteHeader(http.StatusTeapot)
+				}),
+			)
+		})
+
+		It("redirects browser navigations to the login page", func() {
+			req.Header.Set("Accept", "text/html")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusFound))
+			Expect(rec.Body.String()).To(ContainSubstring("login.example.com"))
+		})
+
+		It("hands other clients to the responder", func() {
+			req.Header.Set("Accept", "application/json")
+			handler.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusTeapot))
+			Expect(rec.Body.String()).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("WithJSONErrors", func() {
+
+	DescribeTable("answers rejections in the form of RFC 6750",
+		func(err error, code int, body string) {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizerFunc(func(r *http.Request) error {
+					if err != nil {
+						return err
+					}
+					return authorizeWithClaims(map[string]interface{}{"role": "user"})(r)
+				})),
+				authorizer.WithAuthorizedClaim("role", "admin"),
+				authorizer.WithJSONErrors(),
+			)
+
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Authorization", "Bearer some-secret-token")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(code))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rec.Body.String()).To(Equal(body + "\n"))
+			Expect(rec.Body.String()).NotTo(ContainSubstring("some-secret-token"))
+		},
+		Entry("an expired token", &authorizer.TokenExpiredError{}, http.StatusUnauthorized,
+			`{"error":"invalid_token","error_description":"token expired"}`),
+		Entry("an invalid signature", fmt.Errorf("%w: some-secret-token", authorizer.ErrInvalidSignature), http.StatusUnauthorized,
+			`{"error":"invalid_token","error_description":"credentials rejected"}`),
+		Entry("a malformed header", authorizer.ErrInvalidAuthorizationHeader, http.StatusUnauthorized,
+			`{"error":"invalid_request","error_description":"credentials rejected"}`),
+		Entry("missing credentials", authorizer.ErrMissingAuthorizationHeader, http.StatusUnauthorized,
+			`{"error":"invalid_request","error_description":"no credentials"}`),
+		Entry("unauthorized claims", nil, http.StatusForbidden,
+			`{"error":"insufficient_scope","error_description":"claims not authorized"}`),
+	)
+
+	It("answers configuration errors as server errors", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithBasicAuthCredential("user", ""),
+			authorizer.WithJSONErrors(),
+		)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rec.Body.String()).To(Equal(`{"error":"server_error","error_description":"invalid configuration"}` + "\n"))
+	})
+
+	It("cannot be combined with an unauthorized responder", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithUnauthorizedResponder(func(w http.ResponseWriter, r *http.Request, err error) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			authorizer.WithJSONErrors(),
+		)
+
+		Expect(h.Validate()).To(MatchError(authorizer.ErrContradictoryConfiguration))
+	})
+})
@@ -0,0 +1,64 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	scopeKey = "scope"
+	scpKey   = "scp"
+)
+
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// WithAuthorizedScopes requires the token to grant every one of scopes,
+// either in a space-delimited scope claim or in an scp array. Like required
+// claims it is checked before the authorized claims.
+func WithAuthorizedScopes(scopes ...string) handlerOpt {
+	return func(h *handler) {
+		h.RequiredScopes = append(h.RequiredScopes, scopes...)
+	}
+}
+
+func (h *handler) checkScopes(r *http.Request) error {
+
+	if len(h.RequiredScopes) == 0 {
+		return nil
+	}
+
+	granted := tokenScopes(ClaimsFromContext(r.Context()))
+
+	for _, scope := range h.RequiredScopes {
+		if !slices.Contains(granted, scope) {
+			return fmt.Errorf("%w: %q", ErrInsufficientScope, scope)
+		}
+	}
+
+	return nil
+}
+
+func tokenScopes(claims map[string]interface{}) []string {
+
+	var scopes []string
+
+	for _, key := range []string{scopeKey, scpKey} {
+		switch value := claims[key].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(value)...)
+		case []string:
+			scopes = append(scopes, value...)
+		case []interface{}:
+			for _, item := range value {
+				if scope, ok := item.(string); ok {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+
+	return scopes
+}
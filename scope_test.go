@@ -0,0 +1,53 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithAuthorizedScopes", func() {
+
+	var rejected error
+
+	serve := func(claims map[string]interface{}) int {
+		rejected = nil
+
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithAuthorizedScopes("read:users", "write:users"),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				rejected = event.Err
+			}),
+		)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+		return rec.Code
+	}
+
+	DescribeTable("authorizes tokens granting every scope",
+		func(claims map[string]interface{}) {
+			Expect(serve(claims)).To(Equal(http.StatusOK))
+		},
+		Entry("in a scope string", map[string]interface{}{"scope": "openid read:users write:users"}),
+		Entry("in an scp array", map[string]interface{}{"scp": []interface{}{"write:users", "read:users"}}),
+		Entry("split across both", map[string]interface{}{"scope": "read:users", "scp": []interface{}{"write:users"}}),
+	)
+
+	DescribeTable("rejects other tokens",
+		func(claims map[string]interface{}) {
+			Expect(serve(claims)).To(Equal(http.StatusForbidden))
+			Expect(rejected).To(MatchError(authorizer.ErrInsufficientScope))
+		},
+		Entry("missing a scope", map[string]interface{}{"scope": "openid read:users"}),
+		Entry("with a scope only as a prefix", map[string]interface{}{"scope": "read:users write:users:all"}),
+		Entry("missing an scp entry", map[string]interface{}{"scp": []interface{}{"read:users"}}),
+		Entry("without scope claims", map[string]interface{}{"sub": "some-user"}),
+	)
+})
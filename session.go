@@ -0,0 +1,136 @@
+package authorizer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingSessionCookie = errors.New("missing session cookie")
+	ErrInvalidSession       = errors.New("invalid session")
+	ErrSessionExpired       = errors.New("session expired")
+)
+
+type sessionOpt func(*sessionAuthorizer)
+
+func WithSessionCookie(name string) sessionOpt {
+	return func(a *sessionAuthorizer) {
+		a.Cookie = name
+	}
+}
+
+func WithSessionSecret(key []byte) sessionOpt {
+	return func(a *sessionAuthorizer) {
+		a.Secret = key
+	}
+}
+
+// WithSessionMaxAge rejects sessions whose iat is older than maxAge, or
+// missing.
+func WithSessionMaxAge(maxAge time.Duration) sessionOpt {
+	return func(a *sessionAuthorizer) {
+		a.MaxAge = maxAge
+	}
+}
+
+// NewSessionAuthorizer authorizes requests carrying a session cookie of the
+// form base64(payload).base64(mac), where mac is the HMAC-SHA256 of the
+// payload under the session secret and the payload is a JSON object of
+// claims. Both parts use unpadded URL-safe base64.
+func NewSessionAuthorizer(opts ...sessionOpt) *sessionAuthorizer {
+	auth := &sessionAuthorizer{
+		Cookie: "session",
+		Now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(auth)
+	}
+
+	return auth
+}
+
+type sessionAuthorizer struct {
+	Cookie string
+	Secret []byte
+	MaxAge time.Duration
+	Now    func() time.Time
+}
+
+func (a *sessionAuthorizer) credentialHeaders() []string {
+	return nil
+}
+
+func (a *sessionAuthorizer) credentialCookies() []string {
+	return []string{a.Cookie}
+}
+
+func (a *sessionAuthorizer) Authorize(r *http.Request) error {
+
+	cookie, err := r.Cookie(a.Cookie)
+	if err != nil {
+		return ErrMissingSessionCookie
+	}
+
+	payload, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return ErrInvalidSession
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrInvalidSession
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil {
+		return ErrInvalidSession
+	}
+
+	if len(a.Secret) == 0 || !hmac.Equal(signature, a.sign(data)) {
+		return ErrInvalidSession
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return ErrInvalidSession
+	}
+
+	if err := a.checkAge(claims); err != nil {
+		return err
+	}
+
+	*r = *r.WithContext(ContextWithClaims(r.Context(), claims))
+
+	return nil
+}
+
+func (a *sessionAuthorizer) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (a *sessionAuthorizer) checkAge(claims map[string]interface{}) error {
+
+	if a.MaxAge <= 0 {
+		return nil
+	}
+
+	issuedAt, ok := toTime(claims[IssuedAtKey])
+	if !ok {
+		return ErrSessionExpired
+	}
+
+	if a.Now().Sub(issuedAt) > a.MaxAge {
+		return ErrSessionExpired
+	}
+
+	return nil
+}
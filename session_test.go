@@ -0,0 +1,155 @@
+package authorizer_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("SessionAuthorizer", func() {
+
+	var (
+		secret = []byte("some-secret")
+
+		req  *http.Request
+		auth Authorizer
+		err  error
+	)
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost", nil)
+
+		auth = authorizer.NewSessionAuthorizer(
+			authorizer.WithSessionCookie("sid"),
+			authorizer.WithSessionSecret(secret),
+			authorizer.WithSessionMaxAge(time.Hour),
+		)
+	})
+
+	JustBeforeEach(func() {
+		err = auth.Authorize(req)
+	})
+
+	Context("when the cookie is missing", func() {
+		It("reports missing credentials", func() {
+			Expect(err).To(MatchError(authorizer.ErrMissingSessionCookie))
+		})
+	})
+
+	Context("when the cookie is valid", func() {
+		BeforeEach(func() {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: signSession(secret, map[string]interface{}{
+				"sub": "some-user",
+				"iat": time.Now().Add(-time.Minute).Unix(),
+			})})
+		})
+
+		It("adds the payload to the context as claims", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authorizer.ClaimsFromContext(req.Context())).To(HaveKeyWithValue("sub", "some-user"))
+		})
+	})
+
+	Context("when the cookie is signed with another secret", func() {
+		BeforeEach(func() {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: signSession([]byte("other-secret"), map[string]interface{}{
+				"sub": "some-user",
+				"iat": time.Now().Unix(),
+			})})
+		})
+
+		It("rejects it", func() {
+			Expect(err).To(MatchError(authorizer.ErrInvalidSession))
+		})
+	})
+
+	Context("when the payload has been tampered with", func() {
+		BeforeEach(func() {
+			valid := signSession(secret, map[string]interface{}{
+				"sub": "some-user",
+				"iat": time.Now().Unix(),
+			})
+			_, mac, _ := strings.Cut(valid, ".")
+
+			payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"some-admin","iat":` + strconv.FormatInt(time.Now().Unix(), 10) + `}`))
+			req.AddCookie(&http.Cookie{Name: "sid", Value: payload + "." + mac})
+		})
+
+		It("rejects it", func() {
+			Expect(err).To(MatchError(authorizer.ErrInvalidSession))
+		})
+	})
+
+	Context("when the cookie is malformed", func() {
+		BeforeEach(func() {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: "not-a-session"})
+		})
+
+		It("rejects it", func() {
+			Expect(err).To(MatchError(authorizer.ErrInvalidSession))
+		})
+	})
+
+	Context("when the session is older than the max age", func() {
+		BeforeEach(func() {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: signSession(secret, map[string]interface{}{
+				"sub": "some-user",
+				"iat": time.Now().Add(-2 * time.Hour).Unix(),
+			})})
+		})
+
+		It("rejects it", func() {
+			Expect(err).To(MatchError(authorizer.ErrSessionExpired))
+		})
+	})
+
+	Context("when the session has no issued at", func() {
+		BeforeEach(func() {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: signSession(secret, map[string]interface{}{
+				"sub": "some-user",
+			})})
+		})
+
+		It("rejects it", func() {
+			Expect(err).To(MatchError(authorizer.ErrSessionExpired))
+		})
+	})
+
+	Describe("in a handler", func() {
+		It("answers 401 for missing and forged cookies", func() {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(), authorizer.WithAuthorizer(auth))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+
+			forged := httptest.NewRequest("GET", "http://localhost", nil)
+			forged.AddCookie(&http.Cookie{Name: "sid", Value: "e30.bm9wZQ"})
+
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, forged)
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+func signSession(secret []byte, claims map[string]interface{}) string {
+	payload, err := json.Marshal(claims)
+	Expect(err).NotTo(HaveOccurred())
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const authTimeKey = "auth_time"
+
+var ErrReauthenticationRequired = errors.New("reauthentication required")
+
+// WithMaxSessionAge rejects requests whose user authenticated more than age
+// ago, by the auth_time claim or failing that iat, however long their token
+// remains valid. Claims with neither are rejected as well; requests
+// authorized by an api key alone are exempt. Rejections are 401 with an insufficient_user_authentication
+// challenge carrying max_age.
+func WithMaxSessionAge(age time.Duration) handlerOpt {
+	return func(h *handler) {
+		h.MaxSessionAge = age
+	}
+}
+
+func (h *handler) checkSessionAge(r *http.Request, mechanism string) error {
+
+	// Api keys have no session to age, but a session or token presented
+	// alongside one does.
+	if h.MaxSessionAge <= 0 || mechanism == MechanismApiKey {
+		return nil
+	}
+
+	authenticated, ok := toTime(claimValue(r, authTimeKey))
+	if !ok {
+		authenticated, ok = toTime(claimValue(r, IssuedAtKey))
+	}
+	if !ok {
+		return fmt.Errorf("%w: no auth_time or iat claim", ErrReauthenticationRequired)
+	}
+
+	if age := time.Since(authenticated); age > h.MaxSessionAge {
+		return fmt.Errorf("%w: authenticated %s ago", ErrReauthenticationRequired, age.Round(time.Second))
+	}
+
+	return nil
+}
+
+func (h *handler) setReauthenticationChallenge(w http.ResponseWriter) {
+	maxAge := strconv.Itoa(int(h.MaxSessionAge / time.Second))
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="reauthentication required", max_age=`+maxAge)
+}
@@ -0,0 +1,90 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithMaxSessionAge", func() {
+
+	var (
+		rec    *httptest.ResponseRecorder
+		events []authorizer.AuditEvent
+	)
+
+	recent := float64(time.Now().Add(-time.Hour).Unix())
+	old := float64(time.Now().Add(-9 * time.Hour).Unix())
+
+	serve := func(claims map[string]interface{}) {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(claims))),
+			authorizer.WithMaxSessionAge(8*time.Hour),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				events = append(events, event)
+			}),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+	}
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+		events = nil
+	})
+
+	DescribeTable("checks the authentication time",
+		func(claims map[string]interface{}, code int) {
+			serve(claims)
+			Expect(rec.Code).To(Equal(code))
+		},
+		Entry("recent auth_time", map[string]interface{}{"auth_time": recent}, http.StatusOK),
+		Entry("old auth_time", map[string]interface{}{"auth_time": old}, http.StatusUnauthorized),
+		Entry("recent iat", map[string]interface{}{"iat": recent}, http.StatusOK),
+		Entry("old iat", map[string]interface{}{"iat": old}, http.StatusUnauthorized),
+		Entry("recent auth_time and old iat", map[string]interface{}{"auth_time": recent, "iat": old}, http.StatusOK),
+		Entry("old auth_time and recent iat", map[string]interface{}{"auth_time": old, "iat": recent}, http.StatusUnauthorized),
+		Entry("neither", map[string]interface{}{"sub": "subject"}, http.StatusUnauthorized),
+	)
+
+	It("asks for reauthentication", func() {
+		serve(map[string]interface{}{"auth_time": old})
+
+		Expect(rec.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="insufficient_user_authentication", error_description="reauthentication required", max_age=28800`))
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Err).To(MatchError(authorizer.ErrReauthenticationRequired))
+		Expect(events[0].Class).To(Equal(authorizer.RejectionExpired))
+	})
+
+	It("does not apply to api keys", func() {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithApiKeys("secret"),
+			authorizer.WithMaxSessionAge(8*time.Hour),
+		)
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("applies to sessions presented with an api key", func() {
+		h := authorizer.NewHandler(newLogger(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			authorizer.WithApiKeys("secret"),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{"auth_time": old}))),
+			authorizer.WithMaxSessionAge(8*time.Hour),
+		)
+		req := httptest.NewRequest("GET", "http://localhost", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get("WWW-Authenticate")).To(ContainSubstring("insufficient_user_authentication"))
+	})
+})
@@ -0,0 +1,93 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Status is the body served by StatusHandler. It never includes keys or
+// credentials.
+type Status struct {
+	Healthy     bool       `json:"healthy"`
+	ValidConfig bool       `json:"valid_config"`
+	Mechanisms  []string   `json:"mechanisms"`
+	KeySet      *KeyStatus `json:"key_set,omitempty"`
+}
+
+type KeyStatus struct {
+	Keys             int        `json:"keys"`
+	LastRefresh      *time.Time `json:"last_refresh,omitempty"`
+	LastRefreshError string     `json:"last_refresh_error,omitempty"`
+	ThrottledUntil   *time.Time `json:"throttled_until,omitempty"`
+}
+
+// StatusHandler reports the health of h and, when n is not nil, of the key
+// set n verifies tokens with. It answers 503 when h fails validation, or n
+// holds no keys or failed its last refresh.
+func StatusHandler(h *handler, n *notary) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		status := Status{
+			ValidConfig: h.Validate() == nil,
+			Mechanisms:  h.Mechanisms(),
+		}
+		status.Healthy = status.ValidConfig
+
+		if n != nil {
+			keys := &KeyStatus{Keys: n.KeyCount()}
+
+			lastRefresh, err := n.LastRefresh()
+			if !lastRefresh.IsZero() {
+				keys.LastRefresh = &lastRefresh
+			}
+			if err != nil {
+				keys.LastRefreshError = RedactSecrets(err.Error())
+			}
+			if throttledUntil := n.ThrottledUntil(); throttledUntil.After(n.Now()) {
+				keys.ThrottledUntil = &throttledUntil
+			}
+
+			status.KeySet = keys
+			status.Healthy = status.Healthy && keys.Keys > 0 && err == nil
+		}
+
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// Mechanisms lists the ways the handler is configured to authorize requests,
+// using the Mechanism constants.
+func (h *handler) Mechanisms() []string {
+
+	mechanisms := []string{}
+
+	if len(h.ApiKeys) > 0 || h.ApiKeyValidator != nil || h.ApiKeySecret != nil {
+		mechanisms = append(mechanisms, MechanismApiKey)
+	}
+	if len(h.BasicAuthCredentials) > 0 || h.BasicAuthValidator != nil {
+		mechanisms = append(mechanisms, MechanismBasicAuth)
+	}
+	if len(h.AuthorizedTokens) > 0 || h.TokenProvider != nil {
+		mechanisms = append(mechanisms, MechanismStaticToken)
+	}
+	if h.UpstreamClaimsKey != nil {
+		mechanisms = append(mechanisms, MechanismUpstreamClaims)
+	}
+	if _, noop := h.Authorizer.(*noopAuthorizer); !noop {
+		mechanisms = append(mechanisms, MechanismBearerToken)
+	}
+	if h.AllowAnonymous {
+		mechanisms = append(mechanisms, MechanismAnonymous)
+	}
+
+	return mechanisms
+}
@@ -0,0 +1,117 @@
+package authorizer_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-jose/go-jose/v4"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("StatusHandler", func() {
+
+	var (
+		server *ghttp.Server
+		rec    *httptest.ResponseRecorder
+		body   map[string]interface{}
+	)
+
+	status := func(h http.Handler) {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost/status", nil))
+
+		body = nil
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when the key set is fresh", func() {
+		BeforeEach(func() {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			server.RouteToHandler("GET", "/token_keys", ghttp.RespondWithJSONEncoded(http.StatusOK, jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{KeyID: "some-key", Algorithm: string(jose.RS256), Key: &privateKey.PublicKey}},
+			}))
+
+			n := authorizer.NewNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"))
+			Expect(n.ValidateOnStartup(context.Background())).To(Succeed())
+
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(n))),
+				authorizer.WithApiKeys("some-secret-key"),
+			)
+
+			status(authorizer.StatusHandler(h, n))
+		})
+
+		It("reports healthy", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(body).To(HaveKeyWithValue("healthy", true))
+			Expect(body).To(HaveKeyWithValue("valid_config", true))
+			Expect(body).To(HaveKeyWithValue("mechanisms", []interface{}{"api_key", "bearer_token"}))
+			Expect(body["key_set"]).To(HaveKeyWithValue("keys", BeEquivalentTo(1)))
+			Expect(body["key_set"]).To(HaveKey("last_refresh"))
+			Expect(body["key_set"]).NotTo(HaveKey("last_refresh_error"))
+		})
+
+		It("leaves out credentials", func() {
+			Expect(rec.Body.String()).NotTo(ContainSubstring("some-secret-key"))
+		})
+	})
+
+	Context("when the key set cannot be fetched", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/token_keys", ghttp.RespondWith(http.StatusInternalServerError, nil))
+
+			n := authorizer.NewNotary(authorizer.WithAudience("audience"), authorizer.WithTarget(server.URL()+"/token_keys"))
+			Expect(n.ValidateOnStartup(context.Background())).NotTo(Succeed())
+
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithAuthorizer(authorizer.New(authorizer.WithNotary(n))),
+			)
+
+			status(authorizer.StatusHandler(h, n))
+		})
+
+		It("reports degraded", func() {
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(body).To(HaveKeyWithValue("healthy", false))
+			Expect(body["key_set"]).To(HaveKeyWithValue("keys", BeEquivalentTo(0)))
+			Expect(body["key_set"]).To(HaveKeyWithValue("last_refresh_error", ContainSubstring("key set unavailable")))
+		})
+	})
+
+	Context("when the handler is misconfigured", func() {
+		BeforeEach(func() {
+			h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+				authorizer.WithApiKeys(""),
+			)
+
+			status(authorizer.StatusHandler(h, nil))
+		})
+
+		It("reports degraded without a key set", func() {
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(body).To(HaveKeyWithValue("valid_config", false))
+			Expect(body).To(HaveKeyWithValue("mechanisms", BeEmpty()))
+			Expect(body).NotTo(HaveKey("key_set"))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package authorizer
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrContradictoryConfiguration = errors.New("contradictory configuration")
+
+// StrictConfiguration reports option combinations that can never authorize
+// anything, or that are silently ignored, as configuration errors. The
+// handler then fails Validate and rejects every request.
+func StrictConfiguration() handlerOpt {
+	return func(h *handler) {
+		h.StrictConfiguration = true
+	}
+}
+
+func (h *handler) contradictions() []error {
+
+	var errs []error
+	contradiction := func(format string, args ...interface{}) {
+		errs = append(errs, &optionError{"StrictConfiguration", fmt.Errorf("%w: "+format, append([]interface{}{ErrContradictoryConfiguration}, args...)...)})
+	}
+
+	_, noop := h.Authorizer.(*noopAuthorizer)
+	noClaims := noop && h.UpstreamClaimsKey == nil
+
+	if noClaims && (len(h.AuthorizedClaims) > 0 || len(h.RequiredClaims) > 0 || len(h.RequiredScopes) > 0 || len(h.AuthorizedIssuers) > 0 || len(h.PathValueClaims) > 0 || len(h.ClaimExpressions) > 0 || h.MaxSessionAge > 0) {
+		contradiction("claims are required but no authorizer or upstream claims can provide them, so no request is authorized")
+	}
+
+	if noClaims && len(h.AudiencePolicies) > 0 {
+		contradiction("audience policies are configured but no authorizer or upstream claims can provide an audience")
+	}
+
+	if noClaims && (len(h.ClaimMapping) > 0 || len(h.HeaderMapping) > 0 || h.IncludeAllClaims || h.TokenTTLKey != "") {
+		contradiction("claims are included in the context but no authorizer or upstream claims can provide them")
+	}
+
+	if noClaims && (len(h.RequiredACR) > 0 || len(h.RequiredAMR) > 0) {
+		contradiction("step-up authentication is required but no authorizer or upstream claims can provide acr or amr")
+	}
+
+	if noop && h.BindingStore != nil {
+		contradiction("token binding is configured but no authorizer verifies bearer tokens")
+	}
+
+	if noop && (h.FailOpenWindow > 0 || h.RetryHintThreshold > 0) {
+		contradiction("authorizer outage handling is configured but no authorizer is set")
+	}
+
+	if !noop && h.RequireUpstreamClaims {
+		contradiction("upstream claims are required, so the configured authorizer never runs")
+	}
+
+	if h.VerifiedTokens != nil && h.FailOpenWindow <= 0 {
+		contradiction("a verified token cache is configured without FailOpenOnAuthorizerOutage, so it is never used")
+	}
+
+	if h.RejectOversizedClaims && h.MaxClaimsSize <= 0 {
+		contradiction("oversized claims are rejected but no maximum claims size is set")
+	}
+
+	for _, policy := range h.AudiencePolicies {
+		if len(policy.AuthorizedClaims) == 0 && len(policy.ClaimMapping) == 0 && len(policy.requirements().requiredClaimNames()) == 0 {
+			contradiction("the audience policy for %q has no effect", policy.Audience)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,139 @@
+package authorizer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("StrictConfiguration", func() {
+
+	var (
+		next http.Handler
+
+		allowAll = authorizerFunc(func(*http.Request) error { return nil })
+	)
+
+	BeforeEach(func() {
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	DescribeTable("rejects contradictory configurations",
+		func(build func(next http.Handler) http.Handler) {
+			h := build(next)
+			Expect(h.(Validator).Validate()).To(MatchError(authorizer.ErrContradictoryConfiguration))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost", nil))
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		},
+		Entry("claims without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAuthorizedClaim("role", "admin"),
+			)
+		}),
+		Entry("claim expressions without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithClaimExpression(`claims.role == "admin"`),
+			)
+		}),
+		Entry("context claims without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.IncludeClaimInContext("sub"),
+			)
+		}),
+		Entry("audience policies without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAudiencePolicy("api", authorizer.WithAuthorizedClaim("role", "admin")),
+			)
+		}),
+		Entry("step-up without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithRequiredACR("mfa"),
+			)
+		}),
+		Entry("token binding without an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithTokenBinding(authorizer.NewMemoryBindingStore(), authorizer.BindToClientIP()),
+			)
+		}),
+		Entry("required upstream claims with an authorizer", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAuthorizer(allowAll),
+				authorizer.RequireClaimsFromContext("claims"),
+			)
+		}),
+		Entry("a verified token cache without fail open", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAuthorizer(allowAll),
+				authorizer.WithVerifiedTokenCache(authorizer.NewMemoryCache(10)),
+			)
+		}),
+		Entry("rejecting oversized claims without a maximum", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAuthorizer(allowAll),
+				authorizer.RejectOversizedClaims(),
+			)
+		}),
+		Entry("an empty audience policy", func(next http.Handler) http.Handler {
+			return authorizer.NewHandler(newLogger(), next,
+				authorizer.StrictConfiguration(),
+				authorizer.WithAuthorizer(allowAll),
+				authorizer.WithAudiencePolicy("api"),
+			)
+		}),
+	)
+
+	It("explains the contradiction", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.StrictConfiguration(),
+			authorizer.WithAuthorizedClaim("role", "admin"),
+		)
+
+		Expect(h.Validate()).To(MatchError(ContainSubstring("no authorizer or upstream claims can provide them")))
+	})
+
+	It("accepts a complex but coherent configuration", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.StrictConfiguration(),
+			authorizer.WithAuthorizer(allowAll),
+			authorizer.WithApiKeys("key"),
+			authorizer.WithBasicAuthCredential("user", "pass"),
+			authorizer.WithAuthorizedClaim("role", "admin"),
+			authorizer.WithAuthorizedClaimsMap(map[string]interface{}{"org": "acme"}),
+			authorizer.WithAudiencePolicy("api", authorizer.IncludeClaimInContext("scope")),
+			authorizer.IncludeClaimInContext("sub"),
+			authorizer.IncludeExpirationInContext(),
+			authorizer.WithRequiredAMR("mfa"),
+			authorizer.WithMaxClaimsSize(4096),
+			authorizer.RejectOversizedClaims(),
+			authorizer.FailOpenOnAuthorizerOutage(time.Minute),
+			authorizer.WithVerifiedTokenCache(authorizer.NewMemoryCache(10)),
+		)
+
+		Expect(h.Validate()).NotTo(HaveOccurred())
+	})
+
+	It("stays permissive by default", func() {
+		h := authorizer.NewHandler(newLogger(), next,
+			authorizer.WithAuthorizedClaim("role", "admin"),
+		)
+
+		Expect(h.Validate()).NotTo(HaveOccurred())
+	})
+})
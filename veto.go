@@ -0,0 +1,103 @@
+package authorizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var ErrRequestVetoed = errors.New("request vetoed")
+
+// WithAuthorizationVeto runs fn once a request would otherwise be authorized,
+// with the request's claims, which are nil when it carried none. A non-nil
+// error or a panic rejects the request with ErrRequestVetoed, wrapping the
+// error. Vetoes run in the order they were added and may read the request
+// body; it is replayed for the next veto and the wrapped handler.
+func WithAuthorizationVeto(fn func(r *http.Request, claims map[string]any) error) handlerOpt {
+	return func(h *handler) {
+		h.Vetoes = append(h.Vetoes, fn)
+	}
+}
+
+func (h *handler) veto(r *http.Request) error {
+
+	if len(h.Vetoes) == 0 {
+		return nil
+	}
+
+	var body *replayBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &replayBody{src: r.Body}
+		defer func() {
+			r.Body = body.rest()
+		}()
+	}
+
+	claims := ClaimsFromContext(r.Context())
+
+	for _, fn := range h.Vetoes {
+		if body != nil {
+			r.Body = body.replay()
+		}
+
+		if err := callVeto(fn, r, claims); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func callVeto(fn func(*http.Request, map[string]any) error, r *http.Request, claims map[string]any) (err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("veto panicked: %v", p)
+		}
+	}()
+
+	return fn(r, claims)
+}
+
+// replayBody remembers what has been read from src so that it can be read
+// again from the start.
+type replayBody struct {
+	src  io.ReadCloser
+	read []byte
+}
+
+func (b *replayBody) replay() io.ReadCloser {
+	return &replayReader{body: b}
+}
+
+func (b *replayBody) rest() io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b.read), b.src), b.src}
+}
+
+type replayReader struct {
+	body   *replayBody
+	offset int
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+
+	if r.offset < len(r.body.read) {
+		n := copy(p, r.body.read[r.offset:])
+		r.offset += n
+		return n, nil
+	}
+
+	n, err := r.body.src.Read(p)
+	r.body.read = append(r.body.read, p[:n]...)
+	r.offset += n
+	return n, err
+}
+
+func (r *replayReader) Close() error {
+	return nil
+}
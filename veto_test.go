@@ -0,0 +1,161 @@
+package authorizer_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/reverted/authorizer"
+)
+
+var _ = Describe("WithAuthorizationVeto", func() {
+
+	var (
+		req *http.Request
+		rec *httptest.ResponseRecorder
+
+		downstream string
+		rejected   error
+		veto       func(*http.Request, map[string]any) error
+	)
+
+	errWrongTenant := errors.New("wrong tenant")
+
+	tenantVeto := func(r *http.Request, claims map[string]any) error {
+		var body struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return err
+		}
+		if body.Tenant != claims["tenant"] {
+			return errWrongTenant
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		req = httptest.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{"tenant":"acme","item":"anvil"}`))
+		rec = httptest.NewRecorder()
+
+		downstream = ""
+		rejected = nil
+		veto = nil
+	})
+
+	JustBeforeEach(func() {
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				Expect(err).NotTo(HaveOccurred())
+				downstream = string(body)
+			}),
+			authorizer.WithAuthorizer(authorizerFunc(authorizeWithClaims(map[string]interface{}{
+				"tenant": "acme",
+			}))),
+			authorizer.WithAuditHook(func(event authorizer.AuditEvent) {
+				rejected = event.Err
+			}),
+			authorizer.WithAuthorizationVeto(veto),
+		)
+
+		h.ServeHTTP(rec, req)
+	})
+
+	Context("when the veto passes", func() {
+		BeforeEach(func() {
+			veto = tenantVeto
+		})
+
+		It("forwards the request with its body intact", func() {
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(downstream).To(Equal(`{"tenant":"acme","item":"anvil"}`))
+		})
+	})
+
+	Context("when the veto fails", func() {
+		BeforeEach(func() {
+			req = httptest.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{"tenant":"other"}`))
+			veto = tenantVeto
+		})
+
+		It("rejects the request with the veto's error", func() {
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(rejected).To(MatchError(authorizer.ErrRequestVetoed))
+			Expect(rejected).To(MatchError(errWrongTenant))
+			Expect(downstream).To(BeEmpty())
+		})
+	})
+
+	Context("when the veto panics", func() {
+		BeforeEach(func() {
+			veto = func(*http.Request, map[string]any) error {
+				panic("boom")
+			}
+		})
+
+		It("rejects the request", func() {
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(rejected).To(MatchError(authorizer.ErrRequestVetoed))
+			Expect(rejected.Error()).To(ContainSubstring("boom"))
+		})
+	})
+})
+
+var _ = Describe("Multiple vetoes", func() {
+
+	var (
+		rec   *httptest.ResponseRecorder
+		calls []string
+		body  string
+	)
+
+	recordingVeto := func(name string, err error) func(*http.Request, map[string]any) error {
+		return func(r *http.Request, claims map[string]any) error {
+			data, readErr := io.ReadAll(r.Body)
+			Expect(readErr).NotTo(HaveOccurred())
+			calls = append(calls, name+":"+string(data))
+			return err
+		}
+	}
+
+	BeforeEach(func() {
+		rec = httptest.NewRecorder()
+		calls = nil
+		body = ""
+	})
+
+	It("runs them in order and replays the body to each", func() {
+		h := authorizer.NewHandler(newLogger(),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, err := io.ReadAll(r.Body)
+				Expect(err).NotTo(HaveOccurred())
+				body = string(data)
+			}),
+			authorizer.WithAuthorizationVeto(recordingVeto("first", nil)),
+			authorizer.WithAuthorizationVeto(recordingVeto("second", nil)),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "http://localhost", strings.NewReader("payload")))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal([]string{"first:payload", "second:payload"}))
+		Expect(body).To(Equal("payload"))
+	})
+
+	It("stops at the first veto that fails", func() {
+		h := authorizer.NewHandler(newLogger(), http.NotFoundHandler(),
+			authorizer.WithAuthorizationVeto(recordingVeto("first", errors.New("no"))),
+			authorizer.WithAuthorizationVeto(recordingVeto("second", nil)),
+		)
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "http://localhost", strings.NewReader("payload")))
+
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(calls).To(Equal([]string{"first:payload"}))
+	})
+})